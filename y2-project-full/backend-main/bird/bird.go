@@ -8,11 +8,14 @@ import (
 // duration is the length of the minigame.
 const duration = 60 * time.Second
 
-var won = 0
-
 // ProtoSp is the prototype for a single-player "Flappy Bird" type game.
 var ProtoSp = core.MinigamePrototype{
 	Name:        "fb_sp",
+	DisplayName: "Flappy Bird",
+	Description: "Fly through as many gaps as you can.",
+	Rules: "Tap to flap and keep clear of the pipes. Beat the best score on the flag to " +
+		"capture it.",
+	Tags:        []string{"reflex", "solo"},
 	PlayerCount: 1,
 	Worth:       1,
 	Cooldown:    5 * time.Second,
@@ -25,9 +28,9 @@ var ProtoSp = core.MinigamePrototype{
 	Constructor: func(
 		proto *core.MinigamePrototype,
 		store core.ScoreStore,
-		ship *core.Ship,
+		host core.MinigameHost,
 	) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, newState())
+		return core.NewMinigameContext(proto, store, host, newState())
 	},
 }
 
@@ -48,7 +51,7 @@ func (s *state) Start(ctx *core.MinigameContext) error {
 	_ = msg.Add("duration", duration.Seconds())
 	_ = msg.Add("to_beat", *ctx.Store.(*int))
 
-	s.timer = core.SingleTimer(ctx.Ship.Scheduler, time.Now().Add(duration), func() error {
+	s.timer = core.SingleTimer(ctx.Scheduler(), time.Now().Add(duration), func() error {
 		return ctx.ExactlyOnePlayer().Client.Send(core.NewMessage("bird_timeout"))
 	})
 
@@ -57,11 +60,6 @@ func (s *state) Start(ctx *core.MinigameContext) error {
 
 // end cleans up and ends the minigame.
 func (s *state) end(ctx *core.MinigameContext, result core.MinigameResult) error {
-	if ctx.Ship.Recorder != nil {
-		timeSpent := duration.Seconds() - s.timer.TimeLeft().Seconds()
-		ctx.Ship.Recorder.RecordSP(ctx.ExactlyOnePlayer(), float64(*(ctx.Store.(*int))), uint8(won), timeSpent,
-			"fb_sp")
-	}
 	s.timer.Stop()
 
 	return ctx.End(result)
@@ -82,13 +80,17 @@ func (s *state) Handle(ctx *core.MinigameContext, player *core.Player, message *
 
 	if score > *store {
 		*store = score
-		won = 1
-		return s.end(ctx, core.SinglePlayerWin(player))
+		return s.end(ctx, core.SinglePlayerWin(player).WithScore(player, float64(score)))
 	}
 
-	return s.end(ctx, core.SinglePlayerLoss())
+	return s.end(ctx, core.SinglePlayerLoss().WithScore(player, float64(score)))
 }
 
 func (s *state) HandleDisconnection(ctx *core.MinigameContext, player *core.Player) error {
 	return s.end(ctx, core.SinglePlayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(ProtoSp)
+}
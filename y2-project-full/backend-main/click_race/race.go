@@ -1,6 +1,7 @@
 package click_race
 
 import (
+	"errors"
 	"server/core"
 	"time"
 )
@@ -11,6 +12,11 @@ const duration = 5 * time.Second
 // ProtoSp is the prototype for a single-player "cookie clicker" type game.
 var ProtoSp = core.MinigamePrototype{
 	Name:        "cps_race_sp",
+	DisplayName: "Click Race",
+	Description: "Click as fast as you can.",
+	Rules: "Click as many times as you can before the time runs out. Beat the best score on " +
+		"the flag to capture it.",
+	Tags:        []string{"reflex", "solo"},
 	PlayerCount: 1,
 	Worth:       1,
 	Cooldown:    5 * time.Second,
@@ -25,26 +31,35 @@ var ProtoSp = core.MinigamePrototype{
 	Constructor: func(
 		proto *core.MinigamePrototype,
 		store core.ScoreStore,
-		ship *core.Ship,
+		host core.MinigameHost,
 	) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, newState())
+		return core.NewMinigameContext(proto, store, host, newState())
 	},
 }
 
-// Proto1v1 is the prototype for a 1v1 "cookie clicker" type game.
+// Proto1v1 is the prototype for a 1v1 "cookie clicker" type game. In lobbies with more than two
+// teams, one player from each team plays.
 var Proto1v1 = core.MinigamePrototype{
 	Name:        "cps_race_1v1",
+	DisplayName: "Click Race Duel",
+	Description: "Out-click your opponent.",
+	Rules: "Click as many times as you can before the time runs out. The team with the most " +
+		"clicks wins.",
+	Tags:        []string{"reflex", "versus"},
 	PlayerCount: 2,
 	Worth:       1,
 	Cooldown:    10 * time.Second,
 	StoreCtor:   nil,
 
+	// Any number of teams can race each other.
+	TeamCounts: []int{2, 3, 4},
+
 	Constructor: func(
 		proto *core.MinigamePrototype,
 		store core.ScoreStore,
-		ship *core.Ship,
+		host core.MinigameHost,
 	) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, newState())
+		return core.NewMinigameContext(proto, store, host, newState())
 	},
 }
 
@@ -71,9 +86,16 @@ func newState() *state {
 func (s *state) onTimeout(ctx *core.MinigameContext) error {
 	msg := core.NewMessage("cps_timeout")
 
-	return ctx.ForAllPlayers(func(p *core.Player) error {
+	sendErr := ctx.ForAllPlayers(func(p *core.Player) error {
+		if p.IsBot() {
+			// Bots can't click, so they report no clicks rather than holding up the result.
+			s.reportedScores[p] = 0
+		}
+
 		return p.Client.Send(msg)
 	})
+
+	return errors.Join(sendErr, s.tryEnd(ctx))
 }
 
 func (s *state) Start(ctx *core.MinigameContext) error {
@@ -88,7 +110,7 @@ func (s *state) Start(ctx *core.MinigameContext) error {
 		return p.Client.Send(msg)
 	})
 
-	s.timer = core.SingleTimer(ctx.Ship.Scheduler, time.Now().Add(duration), func() error {
+	s.timer = core.SingleTimer(ctx.Scheduler(), time.Now().Add(duration), func() error {
 		return s.onTimeout(ctx)
 	})
 
@@ -108,78 +130,38 @@ func (s *state) endSp(ctx *core.MinigameContext) error {
 		*threshold = score
 
 		// Won.
-		if ctx.Ship.Recorder != nil { // If recording is enabled...
-			ctx.Ship.Recorder.RecordSP(player, float64(score), 1, 5, "cps_race_sp")
-		}
-		return ctx.End(core.SinglePlayerWin(player))
+		return ctx.End(core.SinglePlayerWin(player).WithScore(player, float64(score)))
 	}
 
 	// Lost.
-	if ctx.Ship.Recorder != nil {
-		ctx.Ship.Recorder.RecordSP(player, float64(score), 0, 5, "cps_race_sp")
-	}
-	return ctx.End(core.SinglePlayerLoss())
+	return ctx.End(core.SinglePlayerLoss().WithScore(player, float64(score)))
 }
 
-// endMp determines the result of this multiplayer game and ends the minigame with it.
+// endMp determines the result of this multiplayer game and ends the minigame with it. Teams are
+// ranked by their total number of clicks.
 func (s *state) endMp(ctx *core.MinigameContext) error {
 	if ctx.Store != nil {
 		panic("multiplayer CPS game should have nil store")
 	}
 
-	teamScores := [2]int{0, 0}
+	teamScores := make(map[*core.Team]int)
 	// Add each player's score to their team's total.
 	_ = ctx.ForAllPlayers(func(p *core.Player) error {
-		teamScores[p.Team.Index()] += s.reportedScores[p]
+		teamScores[p.Team] += s.reportedScores[p]
 
 		return nil
 	})
-	if teamScores[0] == teamScores[1] {
-		if ctx.Ship.Recorder != nil { // If recording is enabled...
-			ps := make(map[*core.Player]float64)
-			pw := make(map[*core.Player]uint8)
-			for k := range ctx.GetTeam(0).Players { // Team 0 lost
-				ps[k] = float64(teamScores[0])
-				pw[k] = 0
-			}
-			for k := range ctx.GetTeam(1).Players { // Team 1 lost
-				ps[k] = float64(teamScores[1])
-				pw[k] = 0
-			}
-			ctx.Ship.Recorder.RecordMP(ps, pw, 5, "cps_race_1v1") // Record the results.
-		}
-		return ctx.End(core.MultiplayerResult(nil))
-	}
-	if teamScores[0] > teamScores[1] {
-		if ctx.Ship.Recorder != nil {
-			ps := make(map[*core.Player]float64)
-			pw := make(map[*core.Player]uint8)
-			for k := range ctx.GetTeam(0).Players { // Team 0 won
-				ps[k] = float64(teamScores[0])
-				pw[k] = 1
-			}
-			for k := range ctx.GetTeam(1).Players { // Team 1 lost
-				ps[k] = float64(teamScores[1])
-				pw[k] = 0
-			}
-			ctx.Ship.Recorder.RecordMP(ps, pw, 5, "cps_race_sp")
-		}
-		return ctx.End(core.MultiplayerResult(ctx.GetTeam(0)))
-	}
-	if ctx.Ship.Recorder != nil {
-		ps := make(map[*core.Player]float64)
-		pw := make(map[*core.Player]uint8)
-		for k := range ctx.GetTeam(0).Players { // Team 0 lost
-			ps[k] = float64(teamScores[0])
-			pw[k] = 0
-		}
-		for k := range ctx.GetTeam(1).Players { // Team 1 won
-			ps[k] = float64(teamScores[1])
-			pw[k] = 1
-		}
-		ctx.Ship.Recorder.RecordMP(ps, pw, 5, "cps_race_sp")
+
+	result := core.ScoredResult(ctx.Teams(), func(team *core.Team) float64 {
+		return float64(teamScores[team])
+	})
+
+	// Each player's score is their own number of clicks.
+	for p, score := range s.reportedScores {
+		result = result.WithScore(p, float64(score))
 	}
-	return ctx.End(core.MultiplayerResult(ctx.GetTeam(1)))
+
+	return ctx.End(result)
 }
 
 // tryEnd checks to see if all participating clients have reported scores. If they have, a winner
@@ -238,3 +220,9 @@ func (s *state) HandleDisconnection(ctx *core.MinigameContext, player *core.Play
 
 	return ctx.End(core.MultiplayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(ProtoSp)
+	core.RegisterMinigame(Proto1v1)
+}
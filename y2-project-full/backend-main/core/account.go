@@ -0,0 +1,474 @@
+package core
+
+import (
+	"crypto/subtle"
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// minUsernameLength is the length of the shortest allowed username.
+const minUsernameLength = 3
+
+// maxUsernameLength is the length of the longest allowed username.
+const maxUsernameLength = 20
+
+// maxDisplayNameLength is the length of the longest allowed display name. This is limited by the
+// size of the name column in the database.
+const maxDisplayNameLength = 10
+
+// minPasswordLength is the length of the shortest allowed password.
+const minPasswordLength = 8
+
+// maxPasswordLength is the length of the longest allowed password.
+const maxPasswordLength = 128
+
+// maxDeviceTokens is the number of devices that can stay logged in to one account. Logging in on
+// another device forgets the oldest token.
+const maxDeviceTokens = 5
+
+// loginFailureWindow is the period over which failed logins are counted.
+const loginFailureWindow = 5 * time.Minute
+
+// maxLoginFailuresPerIP is the number of failed logins that all connections from one IP address may
+// make within loginFailureWindow before further attempts are refused.
+const maxLoginFailuresPerIP = 10
+
+// passwordAttemptWindow is the period over which password hashes are counted.
+const passwordAttemptWindow = 1 * time.Minute
+
+// maxPasswordAttemptsPerIP is the number of registrations and password logins that all connections
+// from one IP address may make within passwordAttemptWindow, whether or not they succeed. Each one
+// needs a slow password hash, so this stops one address from keeping the server busy.
+const maxPasswordAttemptsPerIP = 20
+
+// maxConcurrentHashes is the number of passwords that may be hashed at once. Registrations and
+// logins that would need another are refused until one finishes.
+const maxConcurrentHashes = 4
+
+// An Account lets a player log in to the same profile from any device.
+type Account struct {
+	// Username is the name used to log in, with the capitalisation it was registered with.
+	Username string `json:"username"`
+
+	// DisplayName is the name that other players see.
+	DisplayName string `json:"display_name"`
+
+	// Password is the hash of the account's password.
+	Password PasswordHash `json:"password"`
+
+	// ProfileID is the ID of the profile that holds the account's rating and stats.
+	ProfileID string `json:"profile_id"`
+
+	// DeviceTokens holds the hashes of the tokens given to devices that have logged in, oldest
+	// first.
+	DeviceTokens []string `json:"device_tokens"`
+}
+
+// accountKey returns the key under which the account with the given username is stored. Usernames
+// that differ only in case belong to the same account.
+func accountKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// checkName returns a description of what is wrong with the given username, or an empty string if
+// there is nothing wrong with it.
+func checkName(name string, maxLength int) string {
+	if len(name) < minUsernameLength || len(name) > maxLength {
+		return "wrong length"
+	}
+
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_') {
+			return "may only contain letters, digits and underscores"
+		}
+	}
+
+	return ""
+}
+
+// addDeviceToken adds the hash of a new device token to the account, forgetting the oldest token
+// if there are too many. The token itself is returned.
+func (account *Account) addDeviceToken() (string, error) {
+	token, hash, err := newDeviceToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	account.DeviceTokens = append(account.DeviceTokens, hash)
+
+	if len(account.DeviceTokens) > maxDeviceTokens {
+		account.DeviceTokens = account.DeviceTokens[len(account.DeviceTokens)-maxDeviceTokens:]
+	}
+
+	return token, nil
+}
+
+// hasDeviceToken returns true if and only if the given token was issued to a device that is still
+// logged in to the account.
+func (account *Account) hasDeviceToken(token string) bool {
+	hash := hashDeviceToken(token)
+	found := false
+
+	// Check every token so that the time taken doesn't depend on which one matches.
+	for _, stored := range account.DeviceTokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(stored)) == 1 {
+			found = true
+		}
+	}
+
+	return found
+}
+
+// notifyAccount tells the client which account it is logged in to. The device token is only
+// included if a new one has been issued.
+func (c *Client) notifyAccount(deviceToken string) error {
+	msg := NewMessage("account_welcome")
+	_ = msg.Add("username", c.Account.Username)
+	_ = msg.Add("display_name", c.Account.DisplayName)
+	_ = msg.Add("profile_id", c.Profile.ID)
+	_ = msg.Add("rating", c.Profile.Rating)
+	_ = msg.Add("games_played", c.Profile.GamesPlayed)
+
+	if deviceToken != "" {
+		_ = msg.Add("device_token", deviceToken)
+	}
+
+	return c.Send(msg)
+}
+
+// checkCanAuthenticate returns true if the client may register or log in. Otherwise, it sends an
+// error message to the client and returns false.
+func (c *Client) checkCanAuthenticate() (bool, error) {
+	if c.Player != nil {
+		// The player's name and rating are already in use by the lobby.
+		return false, c.Send(NewMessage("account_in_lobby_error"))
+	}
+
+	if c.Account != nil {
+		return false, c.Send(NewMessage("account_already_logged_in_error"))
+	}
+
+	if c.authPending {
+		// Another register or login is being worked on.
+		return false, c.Send(NewMessage("account_busy_error"))
+	}
+
+	if wait := c.lobbyMgr.loginFailures.RetryAfter(clientIP(c)); wait > 0 {
+		return false, c.Send(NewMessage("account_login_rate_limited").Add(
+			"retry_after",
+			wait.Seconds(),
+		))
+	}
+
+	return true, nil
+}
+
+// hashOffThread runs fn away from the main thread and then calls then on the main thread with the
+// result, unless the client has disconnected in the meantime. Password hashing is slow enough that
+// it would hold up every lobby if it ran on the main thread.
+//
+// Hashing is refused, and the client is told why, if too many hashes are already running or the
+// client's IP address has asked for too many recently.
+func (c *Client) hashOffThread(fn func() any, then func(any) error) error {
+	mgr := c.lobbyMgr
+
+	if len(mgr.hashSlots) == cap(mgr.hashSlots) {
+		return c.Send(NewMessage("account_server_busy_error"))
+	}
+
+	if wait := mgr.passwordAttempts.RetryAfter(clientIP(c)); wait > 0 {
+		return c.Send(NewMessage("account_login_rate_limited").Add("retry_after", wait.Seconds()))
+	}
+
+	mgr.passwordAttempts.Record(clientIP(c))
+
+	// Only the main thread takes slots, so this never blocks.
+	mgr.hashSlots <- struct{}{}
+
+	c.authPending = true
+
+	go func() {
+		result := fn()
+
+		<-mgr.hashSlots
+
+		c.lobbyMgr.scheduler.Add(func() error {
+			c.authPending = false
+
+			if c.closed {
+				return nil
+			}
+
+			if c.Player != nil {
+				// The client joined a lobby while waiting, so its profile can't change now.
+				return c.Send(NewMessage("account_in_lobby_error"))
+			}
+
+			return then(result)
+		})
+	}()
+
+	return nil
+}
+
+// doAccountRegister handles a message from a client asking to create an account. If the client
+// has been playing as a guest, the guest profile becomes the account's profile.
+func (c *Client) doAccountRegister(message *Message) error {
+	if ok, err := c.checkCanAuthenticate(); !ok {
+		return err
+	}
+
+	username, usernameErr := message.GetString("username")
+	password, passwordErr := message.GetString("password")
+
+	if usernameErr != nil || passwordErr != nil {
+		return c.Send(NewMessage("account_format_error"))
+	}
+
+	// The display name is optional and is the same as the username unless given.
+	displayName := username
+
+	if message.TryGet("display_name") != nil {
+		var err error
+
+		if displayName, err = message.GetString("display_name"); err != nil {
+			return c.Send(NewMessage("account_format_error"))
+		}
+	}
+
+	invalid := func(field string, reason string) error {
+		msg := NewMessage("account_register_invalid_error")
+		_ = msg.Add("field", field)
+		_ = msg.Add("reason", reason)
+
+		return c.Send(msg)
+	}
+
+	if reason := checkName(username, maxUsernameLength); reason != "" {
+		return invalid("username", reason)
+	}
+
+	displayName = normaliseDisplayName(displayName)
+
+	if reason := c.lobbyMgr.names.CheckDisplayName(displayName); reason != "" {
+		return invalid("display_name", reason)
+	}
+
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return invalid("password", "wrong length")
+	}
+
+	if existing, err := c.lobbyMgr.storage.LoadAccount(username); err != nil || existing != nil {
+		return errors.Join(err, c.Send(NewMessage("account_username_taken_error")))
+	}
+
+	return c.hashOffThread(func() any {
+		hash, err := hashPassword(password)
+
+		if err != nil {
+			return err
+		}
+
+		return hash
+	}, func(result any) error {
+		hash, ok := result.(PasswordHash)
+
+		if !ok {
+			return errors.Join(result.(error), c.Send(NewMessage("account_unavailable_error")))
+		}
+
+		return c.lobbyMgr.finishRegister(c, &Account{
+			Username:     username,
+			DisplayName:  displayName,
+			Password:     hash,
+			ProfileID:    "",
+			DeviceTokens: nil,
+		})
+	})
+}
+
+// finishRegister stores a new account once its password has been hashed and logs the client in to
+// it.
+func (mgr *LobbyManager) finishRegister(client *Client, account *Account) error {
+	// Somebody else may have taken the username while the password was being hashed.
+	if existing, err := mgr.storage.LoadAccount(account.Username); err != nil || existing != nil {
+		return errors.Join(err, client.Send(NewMessage("account_username_taken_error")))
+	}
+
+	profile := client.Profile
+
+	if profile == nil {
+		var err error
+
+		if profile, err = newProfile(); err != nil {
+			return errors.Join(err, client.Send(NewMessage("account_unavailable_error")))
+		}
+	}
+
+	profile.Account = account.Username
+	account.ProfileID = profile.ID
+
+	token, err := account.addDeviceToken()
+
+	if err != nil {
+		return errors.Join(err, client.Send(NewMessage("account_unavailable_error")))
+	}
+
+	saveErr := errors.Join(mgr.storage.SaveProfile(profile), mgr.storage.SaveAccount(account))
+
+	if saveErr != nil {
+		return errors.Join(saveErr, client.Send(NewMessage("account_unavailable_error")))
+	}
+
+	Logger.Info("registered account", zap.String("username", account.Username))
+
+	client.Account = account
+	mgr.useProfile(client, profile)
+
+	return client.notifyAccount(token)
+}
+
+// loginFailed counts a failed login against the client's IP address and tells the client. The same
+// message is used whether the username or the password was wrong.
+func (c *Client) loginFailed() error {
+	c.lobbyMgr.loginFailures.Record(clientIP(c))
+
+	return c.Send(NewMessage("account_login_failed"))
+}
+
+// doAccountLogin handles a message from a client logging in with a username and password. A new
+// device token is issued so that the client can log in again without the password.
+func (c *Client) doAccountLogin(message *Message) error {
+	if ok, err := c.checkCanAuthenticate(); !ok {
+		return err
+	}
+
+	username, usernameErr := message.GetString("username")
+	password, passwordErr := message.GetString("password")
+
+	if usernameErr != nil || passwordErr != nil || len(password) > maxPasswordLength {
+		return c.Send(NewMessage("account_format_error"))
+	}
+
+	account, err := c.lobbyMgr.storage.LoadAccount(username)
+
+	if err != nil {
+		return errors.Join(err, c.loginFailed())
+	}
+
+	// Check the password even if there is no such account, so that the time taken doesn't give
+	// away which usernames have accounts.
+	hash := dummyPasswordHash
+
+	if account != nil {
+		hash = account.Password
+	}
+
+	return c.hashOffThread(func() any {
+		return hash.Matches(password)
+	}, func(result any) error {
+		if account == nil || !result.(bool) {
+			return c.loginFailed()
+		}
+
+		// Load the account again in case it changed while the password was being checked.
+		account, err := c.lobbyMgr.storage.LoadAccount(username)
+
+		if err != nil || account == nil {
+			return errors.Join(err, c.Send(NewMessage("account_unavailable_error")))
+		}
+
+		token, err := account.addDeviceToken()
+
+		if err != nil {
+			return errors.Join(err, c.Send(NewMessage("account_unavailable_error")))
+		}
+
+		return c.lobbyMgr.finishLogin(c, account, token)
+	})
+}
+
+// doAccountLoginToken handles a message from a client logging in with a device token that was
+// issued when it last logged in with a password.
+func (c *Client) doAccountLoginToken(message *Message) error {
+	if ok, err := c.checkCanAuthenticate(); !ok {
+		return err
+	}
+
+	username, usernameErr := message.GetString("username")
+	token, tokenErr := message.GetString("device_token")
+
+	if usernameErr != nil || tokenErr != nil {
+		return c.Send(NewMessage("account_format_error"))
+	}
+
+	account, err := c.lobbyMgr.storage.LoadAccount(username)
+
+	if err != nil || account == nil || !account.hasDeviceToken(token) {
+		return errors.Join(err, c.loginFailed())
+	}
+
+	return c.lobbyMgr.finishLogin(c, account, "")
+}
+
+// finishLogin logs the client in to the given account once its credentials have been checked. If a
+// new device token was issued, it is passed in so that the client can be told it.
+func (mgr *LobbyManager) finishLogin(client *Client, account *Account, token string) error {
+	if other, ok := mgr.profileClients[account.ProfileID]; ok && other != client {
+		// Two connections sharing a profile would overwrite each other's rating changes.
+		return client.Send(NewMessage("account_in_use_error"))
+	}
+
+	profile, err := mgr.storage.LoadProfile(account.ProfileID)
+
+	if err != nil {
+		return errors.Join(err, client.Send(NewMessage("account_unavailable_error")))
+	}
+
+	if profile == nil {
+		// This should only happen if the storage file has been edited by hand.
+		Logger.Warn("account has no profile", zap.String("username", account.Username))
+
+		if profile, err = newProfile(); err != nil {
+			return errors.Join(err, client.Send(NewMessage("account_unavailable_error")))
+		}
+
+		profile.Account = account.Username
+		account.ProfileID = profile.ID
+	}
+
+	saveErr := errors.Join(mgr.storage.SaveProfile(profile), mgr.storage.SaveAccount(account))
+
+	if saveErr != nil {
+		return errors.Join(saveErr, client.Send(NewMessage("account_unavailable_error")))
+	}
+
+	Logger.Info("logged in", zap.String("username", account.Username))
+
+	client.Account = account
+	mgr.useProfile(client, profile)
+
+	return client.notifyAccount(token)
+}
+
+// doAccountLogout handles a message from a client logging out of its account. The client goes back
+// to having no profile, so it will be given a new guest profile if it joins a lobby.
+func (c *Client) doAccountLogout() error {
+	if c.Player != nil {
+		return c.Send(NewMessage("account_in_lobby_error"))
+	}
+
+	if c.Account == nil {
+		return c.Send(NewMessage("account_not_logged_in_error"))
+	}
+
+	delete(c.lobbyMgr.profileClients, c.Profile.ID)
+
+	c.Account = nil
+	c.Profile = nil
+
+	return c.Send(NewMessage("account_logged_out"))
+}
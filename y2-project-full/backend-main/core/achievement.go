@@ -0,0 +1,450 @@
+package core
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"time"
+)
+
+// xpPerLevel is the XP needed to get from level 1 to level 2. Each level after that needs this
+// much more XP than the one before.
+const xpPerLevel = 100
+
+// gamePlayedXP is the XP given to every player who finishes a ship game.
+const gamePlayedXP = 25
+
+// gameWonXP is the extra XP given to every player on the team that wins a ship game.
+const gameWonXP = 25
+
+// minigameWonXP is the XP given for each minigame a player's team won during a ship game.
+const minigameWonXP = 5
+
+// defaultAchievements is the list of achievements used unless another is given with
+// `--achievements`.
+//
+//go:embed achievements.json
+var defaultAchievements []byte
+
+// An achievementTrigger is the kind of thing that counts towards an achievement.
+type achievementTrigger string
+
+const (
+	// triggerMinigameWin counts minigames won by the player's team.
+	triggerMinigameWin achievementTrigger = "minigame_win"
+
+	// triggerWinStreak is reached by winning minigames in a row. The streak ends when the player
+	// finishes a minigame without winning it, even in a later game.
+	triggerWinStreak achievementTrigger = "win_streak"
+
+	// triggerFlagCapture counts minigames won that gave the player's team a flag it didn't own.
+	triggerFlagCapture achievementTrigger = "flag_capture"
+
+	// triggerGameWin counts ship games won by the player's team.
+	triggerGameWin achievementTrigger = "game_win"
+
+	// triggerGamePlayed counts ship games finished by the player.
+	triggerGamePlayed achievementTrigger = "game_played"
+
+	// triggerFeat counts feats reported by minigames, such as clearing a board without a mistake.
+	triggerFeat achievementTrigger = "feat"
+)
+
+// achievementTriggers contains every trigger that achievements may use.
+var achievementTriggers = []achievementTrigger{
+	triggerMinigameWin,
+	triggerWinStreak,
+	triggerFlagCapture,
+	triggerGameWin,
+	triggerGamePlayed,
+	triggerFeat,
+}
+
+// An Achievement is a goal that players can unlock for XP.
+type Achievement struct {
+	// ID identifies the achievement in profiles and messages. It must never change once players
+	// may have unlocked the achievement.
+	ID string `json:"id"`
+
+	// Name is the name shown to players.
+	Name string `json:"name"`
+
+	// Description tells players how to unlock the achievement.
+	Description string `json:"description"`
+
+	// XP is the XP given to the player who unlocks the achievement.
+	XP int `json:"xp"`
+
+	// Trigger is the kind of thing that counts towards the achievement.
+	Trigger achievementTrigger `json:"trigger"`
+
+	// Feat is the feat that counts towards the achievement. It is only used with triggerFeat.
+	Feat string `json:"feat,omitempty"`
+
+	// Minigames limits the achievement to the minigames with these names. If it is empty, any
+	// minigame counts. It is ignored by triggers that aren't about minigames.
+	Minigames []string `json:"minigames,omitempty"`
+
+	// Count is how many times the trigger must happen, or the length of the streak for
+	// triggerWinStreak. Zero is the same as one.
+	Count int `json:"count,omitempty"`
+}
+
+// required returns the number of times the trigger must happen to unlock the achievement.
+func (a *Achievement) required() int {
+	return max(a.Count, 1)
+}
+
+// ToMap returns the achievement in the form used in messages.
+func (a *Achievement) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          a.ID,
+		"name":        a.Name,
+		"description": a.Description,
+		"xp":          a.XP,
+		"count":       a.required(),
+	}
+}
+
+// validate returns an error describing what is wrong with the achievement, or nil if nothing is.
+// Achievements may only name minigames that exist.
+func (a *Achievement) validate(minigames map[string]MinigamePrototype) error {
+	if a.ID == "" {
+		return errors.New("achievement has no ID")
+	}
+
+	if !slices.Contains(achievementTriggers, a.Trigger) {
+		return fmt.Errorf("achievement %q has unknown trigger %q", a.ID, a.Trigger)
+	}
+
+	if (a.Trigger == triggerFeat) != (a.Feat != "") {
+		return fmt.Errorf("achievement %q must give a feat if and only if it uses feats", a.ID)
+	}
+
+	for _, name := range a.Minigames {
+		if _, ok := minigames[name]; !ok {
+			return fmt.Errorf("achievement %q names unknown minigame %q", a.ID, name)
+		}
+	}
+
+	return nil
+}
+
+// parseAchievements reads a JSON list of achievements and checks that it makes sense.
+func parseAchievements(data []byte, minigames map[string]MinigamePrototype) ([]Achievement, error) {
+	var achievements []Achievement
+
+	if err := json.Unmarshal(data, &achievements); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+
+	for i := range achievements {
+		if err := achievements[i].validate(minigames); err != nil {
+			return nil, err
+		}
+
+		if ids[achievements[i].ID] {
+			return nil, fmt.Errorf("achievement ID %q is used twice", achievements[i].ID)
+		}
+
+		ids[achievements[i].ID] = true
+	}
+
+	return achievements, nil
+}
+
+// AchievementsFromArgs returns the achievements in the JSON file given by the `--achievements`
+// command-line flag, or the built-in achievements if the flag is missing. The server exits if the
+// file can't be read or describes achievements that can't be unlocked.
+func AchievementsFromArgs(minigames map[string]MinigamePrototype) []Achievement {
+	data := defaultAchievements
+
+	if path := argValue("--achievements"); path != nil {
+		var err error
+
+		if data, err = os.ReadFile(*path); err != nil {
+			Logger.Fatal("failed to read achievements", zap.String("path", *path), zap.Error(err))
+		}
+	}
+
+	achievements, err := parseAchievements(data, minigames)
+
+	if err != nil {
+		Logger.Fatal("invalid achievements", zap.Error(err))
+	}
+
+	return achievements
+}
+
+// levelFor returns the level reached with the given total XP.
+func levelFor(xp int) int {
+	level, needed := 1, xpPerLevel
+
+	for xp >= needed {
+		xp -= needed
+		level++
+		needed += xpPerLevel
+	}
+
+	return level
+}
+
+// Level returns the level the profile has reached.
+func (profile *Profile) Level() int {
+	return levelFor(profile.XP)
+}
+
+// achievementList describes every achievement along with the profile's progress towards it.
+func (profile *Profile) achievementList(achievements []Achievement) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(achievements))
+
+	for i := range achievements {
+		a := &achievements[i]
+		m := a.ToMap()
+
+		if unlocked, ok := profile.Achievements[a.ID]; ok {
+			m["unlocked_at"] = unlocked.Unix()
+		} else if a.Trigger == triggerWinStreak {
+			m["progress"] = min(profile.WinStreak, a.required())
+		} else {
+			m["progress"] = profile.Progress[a.ID]
+		}
+
+		list = append(list, m)
+	}
+
+	return list
+}
+
+// An achievementEvent is something that a player has done which may count towards achievements.
+type achievementEvent struct {
+	// trigger is the kind of thing that happened.
+	trigger achievementTrigger
+
+	// minigame is the name of the minigame it happened in, or empty if it didn't happen in one.
+	minigame string
+
+	// feat is the feat performed, for triggerFeat.
+	feat string
+}
+
+// counts returns true if and only if the event counts towards the achievement.
+func (event achievementEvent) counts(a *Achievement) bool {
+	if a.Trigger != event.trigger || a.Feat != event.feat {
+		return false
+	}
+
+	if len(a.Minigames) == 0 || event.minigame == "" {
+		return true
+	}
+
+	return slices.Contains(a.Minigames, event.minigame)
+}
+
+// gameProgress is what a player has earned towards their profile during one ship game.
+type gameProgress struct {
+	// startLevel is the player's level when they started earning during the game.
+	startLevel int
+
+	// xpGained is the XP the player has earned during the game.
+	xpGained int
+
+	// unlocked lists the IDs of the achievements the player has unlocked during the game.
+	unlocked []string
+}
+
+// progressFor returns the player's progress in this game, creating it if needed.
+func (ship *Ship) progressFor(p *Player) *gameProgress {
+	progress, ok := ship.progression[p]
+
+	if !ok {
+		progress = &gameProgress{startLevel: p.Client.Profile.Level(), unlocked: []string{}}
+		ship.progression[p] = progress
+	}
+
+	return progress
+}
+
+// giveXP adds XP to the player's profile and to what they have earned this game.
+func (ship *Ship) giveXP(p *Player, xp int) {
+	ship.progressFor(p).xpGained += xp
+	p.Client.Profile.XP += xp
+}
+
+// unlockAchievement gives the player the achievement and its XP, and tells them about it.
+func (ship *Ship) unlockAchievement(p *Player, a *Achievement) error {
+	profile := p.Client.Profile
+
+	ship.logger().Info(
+		"achievement unlocked",
+		zap.String("name", p.Name),
+		zap.String("achievement", a.ID),
+	)
+
+	if profile.Achievements == nil {
+		profile.Achievements = make(map[string]time.Time)
+	}
+
+	profile.Achievements[a.ID] = time.Now()
+	delete(profile.Progress, a.ID)
+
+	progress := ship.progressFor(p)
+	progress.unlocked = append(progress.unlocked, a.ID)
+
+	ship.giveXP(p, a.XP)
+
+	msg := NewMessage("achievement_unlocked")
+	_ = msg.Add("achievement", a.ToMap())
+	_ = msg.Add("xp", profile.XP)
+	_ = msg.Add("level", profile.Level())
+
+	return p.Client.Send(msg)
+}
+
+// progress counts the event towards the player's achievements, unlocking any that are now
+// complete, and stores the player's profile. Bots don't earn achievements.
+func (ship *Ship) progress(p *Player, event achievementEvent) error {
+	if p.IsBot() {
+		return nil
+	}
+
+	profile := p.Client.Profile
+	achievements := ship.lobby.manager.achievements
+
+	var errs []error
+
+	for i := range achievements {
+		a := &achievements[i]
+
+		if _, unlocked := profile.Achievements[a.ID]; unlocked || !event.counts(a) {
+			continue
+		}
+
+		reached := profile.WinStreak
+
+		if a.Trigger != triggerWinStreak {
+			if profile.Progress == nil {
+				profile.Progress = make(map[string]int)
+			}
+
+			profile.Progress[a.ID]++
+			reached = profile.Progress[a.ID]
+		}
+
+		if reached >= a.required() {
+			errs = append(errs, ship.unlockAchievement(p, a))
+		}
+	}
+
+	errs = append(errs, ship.lobby.manager.saveProfiles([]*Player{p}))
+
+	return errors.Join(errs...)
+}
+
+// progressMinigame counts a finished minigame towards a participant's achievements.
+func (ship *Ship) progressMinigame(p *Player, minigame string, won bool, captured bool) error {
+	if p.IsBot() {
+		return nil
+	}
+
+	if !won {
+		// The streak is over, but nothing else counts.
+		p.Client.Profile.WinStreak = 0
+
+		return ship.lobby.manager.saveProfiles([]*Player{p})
+	}
+
+	p.Client.Profile.WinStreak++
+
+	errs := []error{
+		ship.progress(p, achievementEvent{trigger: triggerMinigameWin, minigame: minigame}),
+		ship.progress(p, achievementEvent{trigger: triggerWinStreak, minigame: minigame}),
+	}
+
+	if captured {
+		event := achievementEvent{trigger: triggerFlagCapture, minigame: minigame}
+		errs = append(errs, ship.progress(p, event))
+	}
+
+	return errors.Join(errs...)
+}
+
+// awardGameProgress gives every player who finished the game their XP for it, and counts the game
+// towards their achievements.
+func (ship *Ship) awardGameProgress(winner *Team) error {
+	var errs []error
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		if p.IsBot() || p.InLobbyActivity() {
+			// Bots don't earn, and players who joined during the endgame didn't play.
+			return nil
+		}
+
+		xp := gamePlayedXP
+
+		if stats, ok := ship.playerStats[p]; ok {
+			_, won := stats.totals()
+			xp += won * minigameWonXP
+		}
+
+		if p.Team == winner {
+			xp += gameWonXP
+		}
+
+		ship.giveXP(p, xp)
+
+		errs = append(errs, ship.progress(p, achievementEvent{trigger: triggerGamePlayed}))
+
+		if p.Team == winner {
+			errs = append(errs, ship.progress(p, achievementEvent{trigger: triggerGameWin}))
+		}
+
+		return nil
+	})
+
+	return errors.Join(errs...)
+}
+
+// namedProgression returns a map which maps the names of players who earned anything during the
+// game to what they earned.
+func (ship *Ship) namedProgression() map[string]map[string]interface{} {
+	m := make(map[string]map[string]interface{})
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		progress, ok := ship.progression[p]
+
+		if !ok {
+			return nil
+		}
+
+		level := p.Client.Profile.Level()
+
+		m[p.Name] = map[string]interface{}{
+			"xp_gained":    progress.xpGained,
+			"xp":           p.Client.Profile.XP,
+			"level":        level,
+			"levelled_up":  level > progress.startLevel,
+			"achievements": progress.unlocked,
+		}
+
+		return nil
+	})
+
+	return m
+}
+
+// ReportFeat tells the core that the player has done something special in the minigame, which may
+// unlock an achievement. Feats must be reported before the minigame ends.
+func (ctx *MinigameContext) ReportFeat(player *Player, feat string) error {
+	ctx.ensureValid()
+
+	event := achievementEvent{trigger: triggerFeat, minigame: ctx.proto.Name, feat: feat}
+
+	return ctx.host.progress(player, event)
+}
@@ -1,7 +1,10 @@
 package core
 
 import (
+	"cmp"
 	"go.uber.org/zap"
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -16,63 +19,180 @@ type Activity interface {
 
 // A MinigameResult is the result of a finished minigame.
 type MinigameResult struct {
-	// winningTeam is the team which won the minigame.
+	// ranking lists teams in finishing order, so the first team is the one which won the minigame.
+	// Teams that did not take part, or whose place was not decided, are left out.
 	//
-	// This will be nil if the result represents a loss (or disconnection) in a single-player core.
-	winningTeam *Team
+	// This will be empty if nobody won, such as in a draw or after a loss (or disconnection) in a
+	// single-player core.
+	ranking []*Team
 
 	// disconnected is a pointer to the player whose disconnection caused the minigame to end,
 	// if any.
 	//
 	// If the minigame did not end due to a disconnection, this will be nil.
 	disconnected *Player
+
+	// forfeit is true if and only if the disconnected player's team should lose the minigame
+	// because of the disconnection.
+	forfeit bool
+
+	// scores maps participants to the scores they finished the minigame with. Participants without
+	// an entry scored zero.
+	scores map[*Player]float64
+
+	// stats holds custom statistics about the minigame as a whole, keyed by name.
+	stats map[string]float64
+}
+
+// WithScore returns a copy of the result in which the given player finished with the given score.
+// Minigames should give every participant their score, since it is what gets recorded for them.
+func (r MinigameResult) WithScore(player *Player, score float64) MinigameResult {
+	r.scores = maps.Clone(r.scores)
+
+	if r.scores == nil {
+		r.scores = make(map[*Player]float64)
+	}
+
+	r.scores[player] = score
+
+	return r
+}
+
+// WithStat returns a copy of the result with the custom statistic of the given name set to the
+// given value.
+func (r MinigameResult) WithStat(name string, value float64) MinigameResult {
+	r.stats = maps.Clone(r.stats)
+
+	if r.stats == nil {
+		r.stats = make(map[string]float64)
+	}
+
+	r.stats[name] = value
+
+	return r
+}
+
+// Score returns the score that the given player finished the minigame with.
+func (r MinigameResult) Score(player *Player) float64 {
+	return r.scores[player]
+}
+
+// Winner returns the team which won the minigame, or nil if nobody won.
+func (r MinigameResult) Winner() *Team {
+	if len(r.ranking) == 0 {
+		return nil
+	}
+
+	return r.ranking[0]
+}
+
+// rankingIndices returns the indices of the ranked teams in finishing order. They are ints rather
+// than uint8s so that they are encoded as a JSON array rather than a string.
+func (r MinigameResult) rankingIndices() []int {
+	indices := make([]int, 0, len(r.ranking))
+
+	for _, team := range r.ranking {
+		indices = append(indices, int(team.Index()))
+	}
+
+	return indices
 }
 
 // SinglePlayerDisconnection returns the MinigameResult that should be used when a single-player
 // minigame finishes early because the given player disconnected.
 func SinglePlayerDisconnection(player *Player) MinigameResult {
 	return MinigameResult{
-		winningTeam:  nil,
+		ranking:      nil,
 		disconnected: player,
 	}
 }
 
 // MultiplayerDisconnection returns the MinigameResult that should be used when a multiplayer
 // minigame finishes early because the given player disconnected.
+//
+// The player's team forfeits. If only one other team took part, that team wins automatically;
+// otherwise nobody wins.
 func MultiplayerDisconnection(player *Player) MinigameResult {
 	return MinigameResult{
-		// The opposing team wins automatically when a player disconnects.
-		winningTeam:  player.Team.OpposingTeam(),
+		ranking:      nil,
 		disconnected: player,
+		forfeit:      true,
 	}
 }
 
 // SinglePlayerWin returns a MinigameResult which represents a win for the given player in a
 // single-player core.
 func SinglePlayerWin(player *Player) MinigameResult {
-	// TODO: Will add the power-ups here.
-	return MinigameResult{winningTeam: player.Team, disconnected: nil}
+	return MinigameResult{ranking: []*Team{player.Team}, disconnected: nil}
 }
 
 // SinglePlayerLoss returns a MinigameResult which represents a loss in a single-player core.
 func SinglePlayerLoss() MinigameResult {
-	return MinigameResult{winningTeam: nil, disconnected: nil}
+	return MinigameResult{ranking: nil, disconnected: nil}
 }
 
 // MultiplayerResult returns a MinigameResult which corresponds to a multiplayer win for the
-// given team.
+// given team, or a draw if the team is nil. Only the winner is ranked; use RankedResult to rank
+// every team.
 func MultiplayerResult(winner *Team) MinigameResult {
-	return MinigameResult{winningTeam: winner, disconnected: nil}
+	if winner == nil {
+		return MinigameResult{ranking: nil, disconnected: nil}
+	}
+
+	return MinigameResult{ranking: []*Team{winner}, disconnected: nil}
+}
+
+// RankedResult returns a MinigameResult in which the given teams finished in the given order. The
+// first team is the winner.
+func RankedResult(ranking []*Team) MinigameResult {
+	return MinigameResult{ranking: ranking, disconnected: nil}
 }
 
-// A MinigamePrototype describes a minigame.
+// ScoredResult returns a MinigameResult which ranks the given teams by the given scores, highest
+// first. If the highest score is shared by more than one team, the result is a draw.
+func ScoredResult(teams []*Team, score func(*Team) float64) MinigameResult {
+	ranking := slices.Clone(teams)
+
+	slices.SortStableFunc(ranking, func(a, b *Team) int {
+		return cmp.Compare(score(b), score(a))
+	})
+
+	if len(ranking) > 1 && score(ranking[0]) == score(ranking[1]) {
+		// Nobody finished ahead of everybody else.
+		return MultiplayerResult(nil)
+	}
+
+	return RankedResult(ranking)
+}
+
+// A MinigamePrototype describes a minigame. Minigame packages make their prototypes available by
+// passing them to RegisterMinigame.
 type MinigamePrototype struct {
-	// Name is the name of the minigame.
+	// Name is the name of the minigame. It is used to refer to the minigame in messages, settings
+	// and maps, so it must be unique.
 	Name string
 
-	// PlayerCount is the total number of players (across all teams) required to play this minigame.
+	// DisplayName is the human-readable name of the minigame.
+	DisplayName string
+
+	// Description is a one-sentence summary of the minigame for players choosing a pool.
+	Description string
+
+	// Rules explains how to play and win the minigame.
+	Rules string
+
+	// Tags are short labels that group similar minigames, such as "reflex" or "memory".
+	Tags []string
+
+	// PlayerCount is the total number of players (across both teams) required to play this
+	// minigame between two teams. When more teams play, each brings TeamSize players.
 	PlayerCount int
 
+	// TeamCounts contains the numbers of teams that can play this minigame against each other.
+	// If it is nil, multiplayer minigames are played between exactly two teams. It is ignored for
+	// single-player minigames.
+	TeamCounts []int
+
 	// Worth is the number of tokens this minigame is worth.
 	Worth int
 
@@ -87,7 +207,7 @@ type MinigamePrototype struct {
 	// Constructor is a function which creates
 	// (but does not start) the minigame that this prototype is for.
 	// It should return a pointer to a minigame context object.
-	Constructor func(proto *MinigamePrototype, store ScoreStore, ship *Ship) *MinigameContext
+	Constructor func(proto *MinigamePrototype, store ScoreStore, host MinigameHost) *MinigameContext
 }
 
 // TeamSize returns the number of players on a single team.
@@ -96,6 +216,42 @@ func (p *MinigamePrototype) TeamSize() int {
 	return p.PlayerCount / 2
 }
 
+// teamsPlaying returns the number of teams that take part in this minigame in a lobby with the
+// given number of teams. This is the largest supported team count that the lobby can fill, or zero
+// if the lobby cannot play the minigame at all. Single-player minigames are always played by one
+// team.
+func (p *MinigamePrototype) teamsPlaying(lobbyTeams int) int {
+	if p.PlayerCount == 1 {
+		return 1
+	}
+
+	counts := p.TeamCounts
+
+	if counts == nil {
+		counts = []int{2}
+	}
+
+	playing := 0
+
+	for _, n := range counts {
+		if n <= lobbyTeams && n > playing {
+			playing = n
+		}
+	}
+
+	return playing
+}
+
+// playersFor returns the number of players needed to play this minigame between the given number
+// of teams.
+func (p *MinigamePrototype) playersFor(teams int) int {
+	if p.PlayerCount == 1 {
+		return 1
+	}
+
+	return p.TeamSize() * teams
+}
+
 // IndividualWorth returns the fraction of the minigame's token worth that should be added to each
 // winning player's individual score.
 //
@@ -115,10 +271,28 @@ func (p *MinigamePrototype) IndividualWorth() float64 {
 // simply stores the score achieved by the last player.
 type ScoreStore interface{}
 
+// A MinigameHost runs minigames and deals with their results. Minigames are hosted by the ship
+// when they are played for a flag, and by a practice session when they are played for practice.
+type MinigameHost interface {
+	// scheduler returns the scheduler which the host's minigames should use to trigger events.
+	scheduler() Scheduler
+
+	// forAllPlayers calls fn for every player that the host is responsible for, whatever activity
+	// they are in.
+	forAllPlayers(fn func(*Player) error) error
+
+	// endMinigame deals with the result of the given minigame and moves its players out of it.
+	endMinigame(ctx *MinigameContext, result MinigameResult) error
+
+	// progress counts the given event towards the player's achievements, if the host lets players
+	// earn them.
+	progress(player *Player, event achievementEvent) error
+}
+
 // A MinigameContext wraps a minigame implementation to provide useful information.
 type MinigameContext struct {
-	// Ship is the main core activity.
-	Ship *Ship
+	// host is the activity which is running the minigame.
+	host MinigameHost
 
 	// Store is the score store from the flag that started this minigame.
 	Store ScoreStore
@@ -128,29 +302,53 @@ type MinigameContext struct {
 
 	// impl is the object which provides the minigame implementation.
 	impl MinigameImpl
+
+	// teams contains the teams that have players in this minigame, in index order. It is set by
+	// the ship just before the minigame starts.
+	teams []*Team
+
+	// playerCount is the number of players in this minigame. It is set along with teams.
+	playerCount int
+
+	// phase is the stage that the minigame has reached. The implementation is only started once
+	// the players are ready and the countdown has finished.
+	phase minigamePhase
+
+	// ready contains the players who are ready for the minigame to start.
+	ready map[*Player]struct{}
+
+	// startTimer is the timer for the get-ready phase, and then for the countdown.
+	startTimer FunctionTimer
+
+	// startedAt is the time at which the implementation was started, or zero if it hasn't been.
+	startedAt time.Time
 }
 
 // NewMinigameContext returns a pointer to a new minigame context created from the given
-// prototype and implementation in the given ship.
+// prototype and implementation for the given host.
 func NewMinigameContext(
 	proto *MinigamePrototype,
 	store ScoreStore,
-	ship *Ship,
+	host MinigameHost,
 	impl MinigameImpl,
 ) *MinigameContext {
 	return &MinigameContext{
-		Ship:  ship,
-		Store: store,
-		proto: proto,
-		impl:  impl,
+		host:       host,
+		Store:      store,
+		proto:      proto,
+		impl:       impl,
+		ready:      make(map[*Player]struct{}),
+		startTimer: ExpiredTimer(),
 	}
 }
 
-// Start begins the minigame.
+// Start begins the minigame. The players are given time to get ready and then a countdown, and the
+// implementation is only started once the countdown has finished. If none of the players' clients
+// take part in the get-ready handshake, the implementation is started straight away.
 func (ctx *MinigameContext) Start() error {
 	ctx.ensureValid()
 
-	return ctx.impl.Start(ctx)
+	return ctx.getReady()
 }
 
 // HandleMessage passes a message through to the minigame implementation for processing.
@@ -158,9 +356,17 @@ func (ctx *MinigameContext) HandleMessage(player *Player, message *Message) erro
 	ctx.ensureValid()
 
 	if message.Type == "lobby_bye" {
+		if ctx.phase != phaseRunning {
+			return ctx.abandonStart(player)
+		}
+
 		return ctx.impl.HandleDisconnection(ctx, player)
 	}
 
+	if message.Type == "minigame_ready" {
+		return ctx.handleReady(player)
+	}
+
 	if message.Type == "ship_flag_activate" || message.Type == "ship_mov_position_update" {
 		Logger.Warn(
 			"minigame received delayed ship message; ignoring",
@@ -171,6 +377,10 @@ func (ctx *MinigameContext) HandleMessage(player *Player, message *Message) erro
 		return nil
 	}
 
+	if ctx.phase != phaseRunning {
+		return player.Client.Send(NewMessage("minigame_not_started_error"))
+	}
+
 	err := ctx.impl.Handle(ctx, player, message)
 
 	if err != nil {
@@ -188,24 +398,60 @@ func (ctx *MinigameContext) HandleMessage(player *Player, message *Message) erro
 	return err
 }
 
-// End finishes the minigame and reports the result back to the main core.
+// End finishes the minigame and reports the result back to the host. In the ship, the result is
+// recorded along with how long the minigame lasted.
 //
 // After calling this method, ctx will be invalid and should not be used.
 func (ctx *MinigameContext) End(result MinigameResult) error {
 	ctx.ensureValid()
-	return ctx.Ship.endMinigame(ctx, result)
+	return ctx.host.endMinigame(ctx, ctx.resolveForfeit(result))
+}
+
+// Scheduler returns the scheduler which the minigame should use to trigger events.
+func (ctx *MinigameContext) Scheduler() Scheduler {
+	ctx.ensureValid()
+	return ctx.host.scheduler()
+}
+
+// resolveForfeit returns the given result with the ranking filled in if a team has forfeited the
+// minigame. When exactly one other team took part, it wins; otherwise nobody does.
+func (ctx *MinigameContext) resolveForfeit(result MinigameResult) MinigameResult {
+	if !result.forfeit {
+		return result
+	}
+
+	loser := result.disconnected.Team
+
+	var others []*Team
+
+	for _, team := range ctx.teams {
+		if team != loser {
+			others = append(others, team)
+		}
+	}
+
+	if len(others) == 1 {
+		result.ranking = []*Team{others[0], loser}
+	}
+
+	return result
 }
 
 // PlayerCount returns the number of players in the minigame.
 func (ctx *MinigameContext) PlayerCount() int {
-	return ctx.proto.PlayerCount
+	return ctx.playerCount
+}
+
+// Teams returns the teams that have players in the minigame, in index order.
+func (ctx *MinigameContext) Teams() []*Team {
+	return ctx.teams
 }
 
 // ForAllPlayers calls fn for every player in the minigame.
 func (ctx *MinigameContext) ForAllPlayers(fn func(*Player) error) error {
 	ctx.ensureValid()
 
-	return ctx.Ship.lobby.ForAllPlayers(func(p *Player) error {
+	return ctx.host.forAllPlayers(func(p *Player) error {
 		if p.Activity != ctx {
 			// Not in this minigame.
 			return nil
@@ -243,9 +489,27 @@ func (ctx *MinigameContext) ExactlyOnePlayer() *Player {
 	return found
 }
 
-// GetTeam returns a pointer to the team with the given index.
+// GetTeam returns a pointer to the team with the given index among the teams playing this
+// minigame. In a lobby with more than two teams, this is not necessarily the team's index in the
+// lobby.
 func (ctx *MinigameContext) GetTeam(index int) *Team {
-	return ctx.Ship.lobby.Teams[index]
+	return ctx.teams[index]
+}
+
+// hasRun returns true if and only if the minigame implementation was started, rather than the
+// minigame ending during the get-ready phase or countdown.
+func (ctx *MinigameContext) hasRun() bool {
+	return !ctx.startedAt.IsZero()
+}
+
+// playTime returns how long the minigame has been running, not counting the get-ready phase and
+// countdown. It is zero if the implementation was never started.
+func (ctx *MinigameContext) playTime() time.Duration {
+	if !ctx.hasRun() {
+		return 0
+	}
+
+	return time.Since(ctx.startedAt)
 }
 
 // invalidate clears the minigame context.
@@ -253,7 +517,8 @@ func (ctx *MinigameContext) invalidate() {
 	ctx.proto = nil
 	ctx.impl = nil
 	ctx.Store = nil
-	ctx.Ship = nil
+	ctx.host = nil
+	ctx.teams = nil
 }
 
 // ensureValid panics if ctx appears to have been invalidated.
@@ -266,7 +531,7 @@ func (ctx *MinigameContext) invalidate() {
 func (ctx *MinigameContext) ensureValid() {
 	// We could do && here (see MinigameContext.invalidate),
 	// but realistically if any of these are nil then something's gone wrong.
-	if ctx.proto == nil || ctx.impl == nil || ctx.Ship == nil {
+	if ctx.proto == nil || ctx.impl == nil || ctx.host == nil {
 		Logger.Panic("minigame context method called on invalid context - did you forget to" +
 			" cancel a" +
 			" scheduled event when the minigame ended?")
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"crypto/subtle"
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// adminFailureWindow is the period over which admin messages with the wrong key are counted.
+const adminFailureWindow = 15 * time.Minute
+
+// maxAdminFailuresPerIP is the number of admin messages with the wrong key that all connections
+// from one IP address may send within adminFailureWindow before they are refused.
+const maxAdminFailuresPerIP = 5
+
+// checkAdminKey returns true if the message carries the admin key. Otherwise, it sends the client
+// the appropriate error and returns false, along with any error from sending it.
+func (c *Client) checkAdminKey(message *Message) (bool, error) {
+	mgr := c.lobbyMgr
+
+	if mgr.adminKey == "" {
+		return false, c.Send(NewMessage("admin_unavailable_error"))
+	}
+
+	if wait := mgr.adminFailures.RetryAfter(clientIP(c)); wait > 0 {
+		return false, c.Send(NewMessage("admin_rate_limited").Add("retry_after", wait.Seconds()))
+	}
+
+	key, err := message.GetString("admin_key")
+
+	if err != nil || subtle.ConstantTimeCompare([]byte(key), []byte(mgr.adminKey)) != 1 {
+		mgr.adminFailures.Record(clientIP(c))
+
+		Logger.Warn("admin message with wrong key", zap.String("from", clientIP(c)))
+
+		return false, c.Send(NewMessage("admin_key_error"))
+	}
+
+	return true, nil
+}
+
+// doAdminMinigameToggle handles a message from an admin enabling or disabling a minigame.
+func (c *Client) doAdminMinigameToggle(message *Message) error {
+	if ok, err := c.checkAdminKey(message); !ok {
+		return err
+	}
+
+	name, nameErr := message.GetString("minigame")
+	enabledVal := message.TryGet("enabled")
+
+	if nameErr != nil || enabledVal == nil {
+		return c.Send(NewMessage("admin_minigame_toggle_format_error"))
+	}
+
+	enabled, ok := (*enabledVal).(bool)
+
+	if !ok {
+		return c.Send(NewMessage("admin_minigame_toggle_format_error"))
+	}
+
+	if _, exists := c.lobbyMgr.minigames[name]; !exists {
+		return c.Send(NewMessage("admin_no_such_minigame_error").Add("minigame", name))
+	}
+
+	toggleErr := c.lobbyMgr.setMinigameEnabled(name, enabled)
+
+	msg := NewMessage("admin_minigame_toggled")
+	_ = msg.Add("minigame", name)
+	_ = msg.Add("enabled", enabled)
+
+	return errors.Join(toggleErr, c.Send(msg))
+}
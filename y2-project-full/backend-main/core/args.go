@@ -0,0 +1,59 @@
+package core
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// argValue returns a pointer to the command-line argument that immediately follows the flag with
+// the given name, or nil if the flag was not given. The server exits if the flag is given without
+// a value.
+func argValue(name string) *string {
+	args := os.Args[1:]
+
+	flagNameIndex := slices.Index(args, name)
+
+	if flagNameIndex == -1 {
+		return nil
+	}
+
+	if flagNameIndex+1 >= len(args) {
+		Logger.Fatal(name + " must be followed by a value")
+		panic("unreachable")
+	}
+
+	return &args[flagNameIndex+1]
+}
+
+// intArg returns the integer given after the flag with the given name, or fallback if the flag was
+// not given. The server exits if the value is not a valid integer.
+func intArg(name string, fallback int) int {
+	str := argValue(name)
+
+	if str == nil {
+		return fallback
+	}
+
+	n, err := strconv.ParseInt(*str, 10, 32)
+
+	if err != nil {
+		Logger.Fatal(name+" was given an invalid integer", zap.String("given", *str))
+		panic("unreachable")
+	}
+
+	return int(n)
+}
+
+// stringArg returns the string given after the flag with the given name, or fallback if the flag
+// was not given.
+func stringArg(name string, fallback string) string {
+	str := argValue(name)
+
+	if str == nil {
+		return fallback
+	}
+
+	return *str
+}
@@ -0,0 +1,333 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxChatLength is the length of the longest chat message that can be sent, in characters.
+const maxChatLength = 200
+
+// chatWindow is the period over which chat messages are counted for rate limiting.
+const chatWindow = 10 * time.Second
+
+// maxChatsPerWindow is the number of chat messages a player may send within chatWindow.
+const maxChatsPerWindow = 5
+
+// chatHistoryLength is the number of recent chat messages that each lobby remembers, so that
+// reports can show what was said.
+const chatHistoryLength = 50
+
+// maxReportReasonLength is the length of the longest reason that can be given with a report.
+const maxReportReasonLength = 200
+
+// A ChatScope decides who receives a chat message.
+type ChatScope string
+
+const (
+	// chatScopeLobby sends the message to everybody in the lobby.
+	chatScopeLobby ChatScope = "lobby"
+
+	// chatScopeTeam sends the message to the sender's team.
+	chatScopeTeam ChatScope = "team"
+
+	// chatScopeShip sends the message to everybody who is in the ship, but not to players who are
+	// playing minigames.
+	chatScopeShip ChatScope = "ship"
+)
+
+// A ChatEntry is a chat message that has been delivered.
+type ChatEntry struct {
+	// Sender is the name of the player who sent the message.
+	Sender string
+
+	// Scope is the scope the message was sent to.
+	Scope ChatScope
+
+	// Text is the text that was delivered, after moderation.
+	Text string
+
+	// SentAt is the time at which the message was sent.
+	SentAt time.Time
+}
+
+// A Moderator checks chat messages before they are delivered and handles reports about players.
+//
+// Moderator methods are only called from the main thread.
+type Moderator interface {
+	// Moderate returns the text that should be delivered in place of the given message, or false
+	// if the message should not be delivered at all.
+	Moderate(sender *Player, text string) (string, bool)
+
+	// Report is called when one player reports another. The lobby's recent chat history is given
+	// so that the report can be checked.
+	Report(reporter *Player, reported *Player, reason string, history []ChatEntry)
+}
+
+// A blocklistModerator masks blocked words in chat messages and logs reports.
+type blocklistModerator struct {
+	// filter holds the blocked words.
+	filter *NameFilter
+}
+
+func (m *blocklistModerator) Moderate(sender *Player, text string) (string, bool) {
+	blocked := m.filter.blockedSpans(text)
+
+	if len(blocked) == 0 {
+		return text, true
+	}
+
+	var b strings.Builder
+
+	for i, r := range text {
+		// Spacing is kept so that the rest of the message reads as it was written.
+		if blocked.covers(i, i+1) && !unicode.IsSpace(r) {
+			r = '*'
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String(), true
+}
+
+func (m *blocklistModerator) Report(
+	reporter *Player,
+	reported *Player,
+	reason string,
+	history []ChatEntry,
+) {
+	var said []string
+
+	for _, entry := range history {
+		if entry.Sender == reported.Name {
+			said = append(said, entry.Text)
+		}
+	}
+
+	Logger.Warn(
+		"player reported",
+		zap.String("lobby", reported.Lobby().ID),
+		zap.String("reporter", reporter.Name),
+		zap.String("reported", reported.Name),
+		zap.String("reason", reason),
+		zap.Strings("recent_messages", said),
+	)
+}
+
+// SetModerator replaces the moderator used for chat in every lobby.
+func (hub *Hub) SetModerator(moderator Moderator) {
+	hub.lobbyMgr.moderator = moderator
+}
+
+// hasMuted returns true if and only if this player has muted the other player.
+func (player *Player) hasMuted(other *Player) bool {
+	_, ok := player.muted[other]
+	return ok
+}
+
+// rememberChat adds the given message to the lobby's chat history, forgetting the oldest message
+// if the history is full.
+func (lobby *Lobby) rememberChat(entry ChatEntry) {
+	lobby.chatHistory = append(lobby.chatHistory, entry)
+
+	if len(lobby.chatHistory) > chatHistoryLength {
+		lobby.chatHistory = lobby.chatHistory[len(lobby.chatHistory)-chatHistoryLength:]
+	}
+}
+
+// chatRecipients returns the players who should receive a message from the given player in the
+// given scope, including the sender. If the sender can't use the scope, nil is returned.
+func chatRecipients(sender *Player, scope ChatScope) []*Player {
+	var recipients []*Player
+
+	add := func(p *Player) error {
+		recipients = append(recipients, p)
+		return nil
+	}
+
+	switch scope {
+	case chatScopeLobby:
+		_ = sender.Lobby().ForAllPlayers(add)
+
+	case chatScopeTeam:
+		_ = sender.Team.ForAllMembers(add)
+
+	case chatScopeShip:
+		if !sender.InShipActivity() {
+			return nil
+		}
+
+		_ = add(sender)
+		_ = sender.ForAllShipPeers(add)
+	}
+
+	return recipients
+}
+
+// doChatSend handles a chat message from the client's player.
+func (c *Client) doChatSend(message *Message) error {
+	scopeStr, scopeErr := message.GetString("scope")
+	text, textErr := message.GetString("text")
+
+	text = strings.TrimSpace(text)
+
+	if scopeErr != nil || textErr != nil || text == "" ||
+		utf8.RuneCountInString(text) > maxChatLength {
+		return c.Send(NewMessage("chat_format_error"))
+	}
+
+	if !c.lobbyMgr.chatLimiter.Allow(c) {
+		return c.Send(NewMessage("chat_rate_limited").Add(
+			"retry_after",
+			c.lobbyMgr.chatLimiter.RetryAfter(c).Seconds(),
+		))
+	}
+
+	scope := ChatScope(scopeStr)
+	recipients := chatRecipients(c.Player, scope)
+
+	if recipients == nil {
+		// Either the scope doesn't exist or the player isn't somewhere it can be used.
+		return c.Send(NewMessage("chat_bad_scope_error").Add("scope", scopeStr))
+	}
+
+	text, ok := c.lobbyMgr.moderator.Moderate(c.Player, text)
+
+	if !ok {
+		return c.Send(NewMessage("chat_blocked"))
+	}
+
+	entry := ChatEntry{
+		Sender: c.Player.Name,
+		Scope:  scope,
+		Text:   text,
+		SentAt: time.Now(),
+	}
+
+	lobby := c.Player.Lobby()
+	lobby.rememberChat(entry)
+
+	if lobby.ship != nil && lobby.ship.Recorder != nil {
+		lobby.ship.Recorder.ChatRecord(lobby.ship, c.Player, entry)
+	}
+
+	msg := NewMessage("chat_message")
+	_ = msg.Add("their_name", entry.Sender)
+	_ = msg.Add("scope", entry.Scope)
+	_ = msg.Add("text", entry.Text)
+
+	errs := make([]error, 0, len(recipients))
+
+	for _, p := range recipients {
+		if p.hasMuted(c.Player) {
+			continue
+		}
+
+		errs = append(errs, p.Client.Send(msg))
+	}
+
+	return errors.Join(errs...)
+}
+
+// chatTarget finds the player named in the "their_name" field of a chat message. If the field is
+// missing or names nobody else in the lobby, an error message is sent and nil is returned.
+func (c *Client) chatTarget(message *Message) (*Player, error) {
+	name, err := message.GetString("their_name")
+
+	if err != nil {
+		return nil, c.Send(NewMessage("chat_format_error"))
+	}
+
+	target := c.Player.Lobby().PlayerByName(name)
+
+	if target == nil || target == c.Player {
+		return nil, c.Send(NewMessage("chat_no_such_peer_error").Add("their_name", name))
+	}
+
+	return target, nil
+}
+
+// doChatMute handles a message from the client's player muting or unmuting another player. Muted
+// players' chat messages are not delivered to the player who muted them.
+func (c *Client) doChatMute(message *Message, muted bool) error {
+	target, err := c.chatTarget(message)
+
+	if target == nil {
+		return err
+	}
+
+	if muted {
+		if c.Player.muted == nil {
+			c.Player.muted = make(map[*Player]struct{})
+		}
+
+		c.Player.muted[target] = struct{}{}
+	} else {
+		delete(c.Player.muted, target)
+	}
+
+	msg := NewMessage("chat_mute_changed")
+	_ = msg.Add("their_name", target.Name)
+	_ = msg.Add("muted", muted)
+
+	return c.Send(msg)
+}
+
+// doChatReport handles a message from the client's player reporting another player.
+func (c *Client) doChatReport(message *Message) error {
+	target, err := c.chatTarget(message)
+
+	if target == nil {
+		return err
+	}
+
+	// The reason is optional.
+	reason := ""
+
+	if message.TryGet("reason") != nil {
+		if reason, err = message.GetString("reason"); err != nil {
+			return c.Send(NewMessage("chat_format_error"))
+		}
+	}
+
+	if utf8.RuneCountInString(reason) > maxReportReasonLength {
+		return c.Send(NewMessage("chat_format_error"))
+	}
+
+	c.lobbyMgr.moderator.Report(c.Player, target, reason, target.Lobby().chatHistory)
+
+	return c.Send(NewMessage("chat_report_received").Add("their_name", target.Name))
+}
+
+// handleChat handles a chat message from the client. Chat works the same way in every activity, so
+// these messages don't go through the player's activity.
+func (c *Client) handleChat(message *Message) error {
+	if c.Player == nil || c.Player.Lobby().practice {
+		// There is nobody to chat to in practice.
+		return c.Send(NewMessage("chat_not_in_lobby_error"))
+	}
+
+	switch message.Type {
+	case "chat_send":
+		return c.doChatSend(message)
+
+	case "chat_mute":
+		return c.doChatMute(message, true)
+
+	case "chat_unmute":
+		return c.doChatMute(message, false)
+
+	case "chat_report":
+		return c.doChatReport(message)
+	}
+
+	Logger.Panic("unhandled chat message type", zap.String("type", message.Type))
+
+	// Unreachable
+	return nil
+}
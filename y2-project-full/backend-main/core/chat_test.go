@@ -0,0 +1,48 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestBlocklistModerator(t *testing.T) {
+	filter := newNameFilter(defaultNameBlocklist, defaultNameAllowlist)
+	moderator := &blocklistModerator{filter: filter}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"good game", "good game"},
+		{"good  game\n\nwell played", "good  game\n\nwell played"},
+		{"oh shit", "oh ****"},
+		{"shit!", "****!"},
+		{"oh  shit\nsorry", "oh  ****\nsorry"},
+		{"what the f.u.c.k", "what the *******"},
+		{"f u c k", "* * * *"},
+		{"oh sh it", "oh ** **"},
+		{"you fuckface", "you ****face"},
+		{"that was some crap", "that was some ****"},
+		{"Ｆｕｃｋ", "****"},
+		{"phuck you", "***** you"},
+
+		// Blocked words spelt across the ends of other words.
+		{"he was hit", "he was hit"},
+		{"this hit", "this hit"},
+
+		// Ordinary words which contain a blocked word.
+		{"scrap that", "scrap that"},
+		{"sparse map", "sparse map"},
+		{"swanky ship", "swanky ship"},
+		{"cocktail party", "cocktail party"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, ok := moderator.Moderate(nil, tt.text)
+
+			if !ok || got != tt.want {
+				t.Errorf("Moderate(%q) = %q, %v, want %q, true", tt.text, got, ok, tt.want)
+			}
+		})
+	}
+}
@@ -24,6 +24,28 @@ type Client struct {
 	// A client can only have a player once the user is in a lobby.
 	Player *Player
 
+	// Profile is the persistent identity that the client is playing as. This is nil until the
+	// client identifies itself or joins a lobby.
+	Profile *Profile
+
+	// Account is the account that the client has logged in to, or nil if the client is playing as
+	// a guest.
+	Account *Account
+
+	// authPending is true if and only if a password for this client is being hashed or checked.
+	authPending bool
+
+	// closed is true if and only if the client has been killed.
+	closed bool
+
+	// bot is true if and only if the client belongs to a bot. Bots have no connection, and
+	// messages sent to them are dropped.
+	bot bool
+
+	// readyHandshake is true if and only if the client has said that it sends minigame_ready once
+	// it has loaded a minigame. Minigames don't wait for clients that haven't.
+	readyHandshake bool
+
 	// out is the channel along which outgoing messages are sent.
 	out chan ClientMessageOut
 
@@ -33,6 +55,10 @@ type Client struct {
 
 // Send encodes and sends m to the client.
 func (c *Client) Send(m *Message) error {
+	if c.bot {
+		return nil
+	}
+
 	data, err := m.Encode()
 
 	if err != nil {
@@ -45,10 +71,44 @@ func (c *Client) Send(m *Message) error {
 	return nil
 }
 
+// requestedName returns the display name that the client has asked for in the given message, or
+// the empty string if it has not asked for one. If the name is not a string, an error message is
+// sent to the client and false is returned.
+func (c *Client) requestedName(message *Message) (string, bool, error) {
+	if message.TryGet("name") == nil {
+		return "", true, nil
+	}
+
+	name, err := message.GetString("name")
+
+	if err != nil {
+		return "", false, c.Send(NewMessage("lobby_name_format_error"))
+	}
+
+	return name, true, nil
+}
+
 // doLobbyCreate handles a lobby creation message from the client.
-func (c *Client) doLobbyCreate() error {
-	// All validation happens further down the call chain.
-	return c.lobbyMgr.HandleLobbyCreate(c)
+func (c *Client) doLobbyCreate(message *Message) error {
+	public := false
+
+	// The "public" field is optional, and lobbies are private unless it is given.
+	if publicVal := message.TryGet("public"); publicVal != nil {
+		var ok bool
+
+		if public, ok = (*publicVal).(bool); !ok {
+			return c.Send(NewMessage("lobby_create_format_error"))
+		}
+	}
+
+	name, ok, err := c.requestedName(message)
+
+	if !ok {
+		return err
+	}
+
+	// All other validation happens further down the call chain.
+	return c.lobbyMgr.HandleLobbyCreate(c, public, name)
 }
 
 // doLobbyJoin handles a lobby join message from the client.
@@ -59,24 +119,87 @@ func (c *Client) doLobbyJoin(message *Message) error {
 		return err
 	}
 
+	name, ok, err := c.requestedName(message)
+
+	if !ok {
+		return err
+	}
+
+	// Refuse to look anything up if this client has been guessing codes.
+	if wait := c.lobbyMgr.joinRetryAfter(c); wait > 0 {
+		return c.Send(NewMessage("lobby_join_rate_limited").Add("retry_after", wait.Seconds()))
+	}
+
 	// Get the lobby activity, which is responsible for lobby messaging.
-	act := c.lobbyMgr.GetActivity(id)
+	act := c.lobbyMgr.GetActivity(NormaliseLobbyCode(id))
 
 	if act == nil {
+		c.lobbyMgr.recordJoinFailure(c)
+
 		return c.Send(NewMessage("lobby_not_found"))
 	}
 
-	return act.HandleJoinRequest(c)
+	return act.HandleJoinRequest(c, name)
+}
+
+// doMatchmakingEnqueue handles a message from the client asking to be put into a matchmade lobby.
+func (c *Client) doMatchmakingEnqueue(message *Message) error {
+	name, ok, err := c.requestedName(message)
+
+	if !ok {
+		return err
+	}
+
+	return c.lobbyMgr.HandleMatchmakingEnqueue(c, name)
 }
 
 // Receive processes a message received from the client.
 func (c *Client) Receive(m *Message) error {
 	switch m.Type {
 	case "lobby_create":
-		return c.doLobbyCreate()
+		return c.doLobbyCreate(m)
 
 	case "lobby_join":
 		return c.doLobbyJoin(m)
+
+	case "lobby_list":
+		return c.lobbyMgr.HandleLobbyList(c)
+
+	case "matchmaking_enqueue":
+		return c.doMatchmakingEnqueue(m)
+
+	case "profile_identify":
+		return c.doProfileIdentify(m)
+
+	case "account_register":
+		return c.doAccountRegister(m)
+
+	case "account_login":
+		return c.doAccountLogin(m)
+
+	case "account_login_token":
+		return c.doAccountLoginToken(m)
+
+	case "account_logout":
+		return c.doAccountLogout()
+
+	case "chat_send", "chat_mute", "chat_unmute", "chat_report":
+		return c.handleChat(m)
+
+	case "minigame_list":
+		return c.lobbyMgr.HandleMinigameList(c)
+
+	case "practice_start":
+		return c.doPracticeStart(m)
+
+	case "clock_sync":
+		return c.doClockSync(m)
+
+	case "client_capabilities":
+		return c.doClientCapabilities(m)
+
+	case "admin_minigame_toggle":
+		return c.doAdminMinigameToggle(m)
 	}
 
 	// If the client has a player, forward the message to their current activity.
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"math"
+	"time"
+)
+
+// botName is the name given to bots, with a number added if more than one bot is in the lobby.
+const botName = "Bot"
+
+// A disconnectPolicy decides what happens to the ship when a player leaves during the game.
+type disconnectPolicy string
+
+const (
+	// disconnectEnd puts the ship into the endgame as soon as anybody leaves.
+	disconnectEnd disconnectPolicy = "end"
+
+	// disconnectHandicap carries on with the short-handed team, whose captures are worth more to
+	// make up for the missing players.
+	disconnectHandicap disconnectPolicy = "handicap"
+
+	// disconnectBot puts a bot in the place of the player who left.
+	disconnectBot disconnectPolicy = "bot"
+
+	// disconnectReplace carries on with a handicap until somebody joins the lobby to take the
+	// vacated place.
+	disconnectReplace disconnectPolicy = "replace"
+)
+
+// disconnectPolicies contains every disconnect policy.
+var disconnectPolicies = []disconnectPolicy{
+	disconnectEnd,
+	disconnectHandicap,
+	disconnectBot,
+	disconnectReplace,
+}
+
+// IsBot returns true if and only if the client belongs to a bot rather than a real connection.
+func (c *Client) IsBot() bool {
+	return c.bot
+}
+
+// IsBot returns true if and only if the player is a bot.
+func (player *Player) IsBot() bool {
+	return player.Client.IsBot()
+}
+
+// humanCount returns the number of players on the team who aren't bots.
+func (team *Team) humanCount() int {
+	count := 0
+
+	for p := range team.Players {
+		if !p.IsBot() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// recordStartingSizes remembers how many players each team started the game with, for working out
+// handicaps.
+func (ship *Ship) recordStartingSizes() {
+	for _, team := range ship.lobby.Teams {
+		ship.startingSizes[team] = len(team.Players)
+	}
+}
+
+// handicapBonus returns the number of extra tokens that the given team earns for capturing a flag
+// with the given base worth. Under the handicap and replace policies, a team that has lost players
+// earns extra tokens in proportion to how many it has lost.
+func (ship *Ship) handicapBonus(team *Team, worth int) int {
+	policy := ship.settings.DisconnectPolicy
+
+	if policy != disconnectHandicap && policy != disconnectReplace {
+		return 0
+	}
+
+	humans := team.humanCount()
+	started := ship.startingSizes[team]
+
+	if humans == 0 || humans >= started {
+		return 0
+	}
+
+	return int(math.Round(float64(worth) * (float64(started)/float64(humans) - 1)))
+}
+
+// newBot returns a new bot with the given rating and activity, named so that it is unique in the
+// given lobby. The bot is not put on a team.
+func newBot(lobby *Lobby, rating float64, activity Activity) *Player {
+	// Bots' profiles are never stored.
+	client := &Client{
+		lobbyMgr: lobby.manager,
+		Profile:  &Profile{Rating: rating},
+		bot:      true,
+	}
+
+	client.Player = &Player{
+		Client:   client,
+		Activity: activity,
+		Name:     lobby.uniquePlayerName(botName),
+		joinedAt: time.Now(),
+	}
+
+	return client.Player
+}
+
+// addBot puts a bot onto the given team in place of a player who has left. The bot stands where
+// the player was, and is locked to flags and sent into minigames like anybody else. Bots never do
+// anything, so they only fill places.
+func (ship *Ship) addBot(team *Team, replacing string, rating float64, pos Position) error {
+	// Bots play at the rating of the player they replace.
+	bot := newBot(ship.lobby, rating, ship)
+	team.AddPlayer(bot)
+
+	ship.pm.Map[bot] = pos
+	ship.individualScores[bot] = 0
+	ship.startStats(bot)
+
+	ship.logger().Info(
+		"bot replacing player",
+		zap.String("bot", bot.Name),
+		zap.String("replacing", replacing),
+	)
+
+	msg := NewMessage("ship_bot_joined")
+	_ = msg.Add("their_name", bot.Name)
+	_ = msg.Add("their_team", team.Index())
+	_ = msg.Add("replacing", replacing)
+	_ = msg.Add("pos", pos.ToMap())
+
+	sendErr := ship.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+
+	// The bot may be just what a waiting flag needs.
+	return errors.Join(sendErr, ship.addPlayersToFlags())
+}
+
+// removeBots takes every bot out of the lobby. This is done when the ship ends, since bots only
+// play in the ship.
+func (ship *Ship) removeBots() error {
+	var bots []*Player
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		if p.IsBot() {
+			bots = append(bots, p)
+		}
+
+		return nil
+	})
+
+	errs := make([]error, 0, len(bots))
+
+	for _, bot := range bots {
+		ship.pm.Forget(bot)
+		errs = append(errs, ship.lobby.RemovePlayer(bot))
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleDeparture decides what happens to the ship after a player has left the given team. The
+// ship only goes into the endgame if the disconnect policy says so or the whole team has gone.
+func (ship *Ship) handleDeparture(team *Team, name string, rating float64, pos Position) error {
+	policy := ship.settings.DisconnectPolicy
+
+	if policy == disconnectEnd || team.humanCount() == 0 || ship.isEndgame {
+		return ship.triggerEndgame(&name)
+	}
+
+	ship.logger().Info(
+		"continuing without player",
+		zap.String("name", name),
+		zap.String("policy", string(policy)),
+	)
+
+	leaveErr := ship.notifyPeerLeft(name)
+
+	switch policy {
+	case disconnectBot:
+		return errors.Join(leaveErr, ship.addBot(team, name, rating, pos))
+
+	case disconnectReplace:
+		ship.vacancies[team]++
+	}
+
+	return leaveErr
+}
+
+// vacantTeam returns a team with a place left by somebody who disconnected, or nil if there is no
+// such team or the ship can't take anybody new.
+func (ship *Ship) vacantTeam() *Team {
+	if ship.isEndgame || ship.settings.DisconnectPolicy != disconnectReplace {
+		return nil
+	}
+
+	for _, team := range ship.lobby.Teams {
+		if ship.vacancies[team] > 0 {
+			return team
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// activationTimeout is the length of time for which an activated multiplayer flag waits for enough
+// players before its locks are released.
+const activationTimeout = 60 * time.Second
+
+// A lockRelease explains why a player's lock on a flag was released.
+type lockRelease string
+
+const (
+	// lockReleaseUnlocked means that the player asked to be unlocked.
+	lockReleaseUnlocked lockRelease = "unlocked"
+
+	// lockReleaseTimeout means that the flag didn't get enough players in time.
+	lockReleaseTimeout lockRelease = "timeout"
+
+	// lockReleaseLeft means that the player left the game.
+	lockReleaseLeft lockRelease = "left"
+
+	// lockReleaseExpired means that the flag was a bonus flag and its event ended.
+	lockReleaseExpired lockRelease = "expired"
+
+	// lockReleaseDisabled means that the flag's minigame was disabled by an admin.
+	lockReleaseDisabled lockRelease = "disabled"
+)
+
+// clearActivation stops the flag's activation timeout, if there is one, and returns the flag to
+// the unactivated state.
+func (f *flag) clearActivation() {
+	if f.activation == nil {
+		return
+	}
+
+	f.activation.timeout.Stop()
+	f.activation = nil
+}
+
+// hasDeclined returns true if and only if the given player has unlocked themselves from the flag
+// since it was activated. Such players aren't locked to the flag again until it is next activated.
+func (f *flag) hasDeclined(p *Player) bool {
+	_, ok := f.activation.declined[p]
+	return ok
+}
+
+// notifyLockReleased tells everybody in the ship that the given player is no longer locked to the
+// flag with the given ID.
+func (ship *Ship) notifyLockReleased(p *Player, flagID string, reason lockRelease) error {
+	msg := NewMessage("ship_lock_released")
+	_ = msg.Add("their_name", p.Name)
+	_ = msg.Add("flag_id", flagID)
+	_ = msg.Add("reason", reason)
+
+	return ship.ForAllShipPlayers(func(shipPlayer *Player) error {
+		return shipPlayer.Client.Send(msg)
+	})
+}
+
+// startActivationTimeout starts the timer after which the flag's locks are released if its
+// minigame hasn't started.
+func (ship *Ship) startActivationTimeout(f *flag) {
+	act := f.activation
+
+	act.timeout = SingleTimer(ship.Scheduler, time.Now().Add(activationTimeout), func() error {
+		if f.activation != act {
+			// The minigame started or the flag was cleared in the meantime.
+			return nil
+		}
+
+		return ship.activationTimedOut(f)
+	})
+}
+
+// activationTimedOut releases the locks on a flag that didn't get enough players in time. If only
+// the team that activated the flag turned up, and the lobby allows it, that team captures the flag
+// by forfeit.
+func (ship *Ship) activationTimedOut(f *flag) error {
+	id := ship.fm.idForFlag(f)
+	act := f.activation
+
+	ship.logger().Info("flag activation timed out", zap.String("flag", id))
+
+	teams := make(map[*Team]struct{})
+	locked := make([]*Player, 0, len(act.lockedPlayers))
+
+	for p := range act.lockedPlayers {
+		teams[p.Team] = struct{}{}
+		locked = append(locked, p)
+	}
+
+	f.clearActivation()
+
+	errs := make([]error, 0, len(locked)+1)
+
+	for _, p := range locked {
+		errs = append(errs, ship.notifyLockReleased(p, id, lockReleaseTimeout))
+	}
+
+	_, onlyActivators := teams[act.activatingTeam]
+	onlyActivators = onlyActivators && len(teams) == 1
+
+	if ship.settings.ForfeitCaptures && onlyActivators && f != ship.suddenDeath &&
+		f.owner != act.activatingTeam {
+		errs = append(errs, ship.forfeitCapture(f, act.activatingTeam))
+	}
+
+	// The released players may be needed elsewhere.
+	errs = append(errs, ship.addPlayersToFlags())
+
+	return errors.Join(errs...)
+}
+
+// forfeitCapture gives the flag to the given team because no other team turned up to play its
+// minigame.
+func (ship *Ship) forfeitCapture(f *flag, team *Team) error {
+	id := ship.fm.idForFlag(f)
+
+	ship.logger().Info(
+		"awarding flag by forfeit",
+		zap.String("flag", id),
+		zap.Int("team", int(team.Index())),
+	)
+
+	ship.startCooldown(f)
+
+	// A shield stops a forfeit capture just as it stops any other.
+	if err := ship.captureFlag(f, team); f.owner != team {
+		return err
+	}
+
+	msg := NewMessage("ship_flag_forfeit_capture")
+	_ = msg.Add("flag_id", id)
+	_ = msg.Add("capture_team", team.Index())
+
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// releaseLeavingPlayer removes the lock, if there is one, of a player who is leaving the game.
+func (ship *Ship) releaseLeavingPlayer(player *Player) error {
+	f := ship.fm.flagForPlayer(player)
+
+	if f == nil {
+		return nil
+	}
+
+	delete(f.activation.lockedPlayers, player)
+
+	if len(f.activation.lockedPlayers) == 0 {
+		f.clearActivation()
+	}
+
+	msg := NewMessage("ship_lock_released")
+	_ = msg.Add("their_name", player.Name)
+	_ = msg.Add("flag_id", ship.fm.idForFlag(f))
+	_ = msg.Add("reason", lockReleaseLeft)
+
+	// The player is leaving, so they aren't sent this.
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		if p == player {
+			return nil
+		}
+
+		return p.Client.Send(msg)
+	})
+}
+
+// doFlagUnlock handles a message from a player who no longer wants to wait for the flag they are
+// locked to.
+func (ship *Ship) doFlagUnlock(player *Player) error {
+	f := ship.fm.flagForPlayer(player)
+
+	if f == nil {
+		return player.Client.Send(NewMessage("ship_flag_not_locked_error"))
+	}
+
+	id := ship.fm.idForFlag(f)
+
+	ship.logger().Info(
+		"unlocking player from flag",
+		zap.String("flag", id),
+		zap.String("player", player.Name),
+	)
+
+	delete(f.activation.lockedPlayers, player)
+	f.activation.declined[player] = struct{}{}
+
+	if len(f.activation.lockedPlayers) == 0 {
+		// Nobody is waiting for the minigame anymore.
+		f.clearActivation()
+	}
+
+	notifyErr := ship.notifyLockReleased(player, id, lockReleaseUnlocked)
+
+	// Other flags may need the player.
+	addErr := ship.addPlayersToFlags()
+
+	return errors.Join(notifyErr, addErr)
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// holdIncomeInterval is the length of time for which a flag must be held to earn hold income.
+const holdIncomeInterval = 15 * time.Second
+
+// flagRules are the optional flag mechanics that a ship map can turn on.
+type flagRules struct {
+	// decayAfter is the length of time for which a captured flag stays captured unless its owner
+	// defends it. Zero turns decay off.
+	decayAfter time.Duration
+
+	// defend is true if and only if teams may activate flags they own in order to defend them.
+	defend bool
+
+	// holdIncome is the number of tokens that a team earns for every holdIncomeInterval for which
+	// it holds a flag. Zero turns hold income off.
+	holdIncome int
+}
+
+// ToMap returns the rules in the form used in messages.
+func (rules flagRules) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"decay_after":          rules.decayAfter.Seconds(),
+		"defend":               rules.defend,
+		"hold_income":          rules.holdIncome,
+		"hold_income_interval": holdIncomeInterval.Seconds(),
+	}
+}
+
+// flagRules returns the flag mechanics used by the ship's map.
+func (ship *Ship) flagRules() flagRules {
+	return shipMaps[ship.settings.Map].rules
+}
+
+// teamScores returns the number of tokens each team has, in team index order. This is the worth of
+// the flags each team owns plus anything it has earned by holding flags.
+func (ship *Ship) teamScores() []int {
+	scores := ship.fm.teamScores(len(ship.lobby.Teams))
+
+	for i, earned := range ship.bankedTokens {
+		scores[i] += earned
+	}
+
+	return scores
+}
+
+// startDecay sets the time at which the flag returns to neutral if its owner doesn't defend it.
+// Nothing happens if the map doesn't use decay.
+func (ship *Ship) startDecay(f *flag) {
+	if after := ship.flagRules().decayAfter; after > 0 {
+		f.decaysAt = time.Now().Add(after)
+	}
+}
+
+// decaysIn returns how long is left before the flag returns to neutral, and false if the flag
+// isn't decaying.
+func (f *flag) decaysIn() (time.Duration, bool) {
+	if f.owner == nil || f.decaysAt.IsZero() {
+		return 0, false
+	}
+
+	return max(time.Until(f.decaysAt), 0), true
+}
+
+// defendFlag records that the flag's owner has won its minigame, which restarts its decay.
+func (ship *Ship) defendFlag(f *flag) error {
+	if ship.flagRules().decayAfter == 0 {
+		return nil
+	}
+
+	ship.startDecay(f)
+
+	msg := NewMessage("ship_flag_defended")
+	_ = msg.Add("flag_id", ship.fm.idForFlag(f))
+	_ = msg.Add("team", f.owner.Index())
+	_ = msg.Add("decays_in", ship.flagRules().decayAfter.Seconds())
+
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// decayFlag returns the flag to neutral because its owner didn't defend it in time.
+func (ship *Ship) decayFlag(f *flag) error {
+	id := ship.fm.idForFlag(f)
+	former := f.owner
+
+	ship.logger().Info(
+		"flag decayed",
+		zap.String("flag", id),
+		zap.Int("team", int(former.Index())),
+	)
+
+	f.owner = nil
+	f.decaysAt = time.Time{}
+
+	// Bonuses belong to the team that applied them.
+	f.shielded = false
+	f.doubled = false
+	f.handicapBonus = 0
+	f.eventBonus = 0
+
+	msg := NewMessage("ship_flag_decayed")
+	_ = msg.Add("flag_id", id)
+	_ = msg.Add("former_team", former.Index())
+
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// decayFlags returns every flag whose decay time has passed to neutral. Flags that are activated or
+// have a minigame going wait until that is settled, since the owner may be about to defend them.
+func (ship *Ship) decayFlags() error {
+	var errs []error
+
+	for _, f := range ship.fm.flags {
+		left, decaying := f.decaysIn()
+
+		if !decaying || left > 0 || f.isActivated() || f.minigame != nil {
+			continue
+		}
+
+		errs = append(errs, ship.decayFlag(f))
+	}
+
+	return errors.Join(errs...)
+}
+
+// payHoldIncome gives each team the tokens it has earned by holding flags since the last payment.
+func (ship *Ship) payHoldIncome() error {
+	income := ship.flagRules().holdIncome
+
+	if income == 0 || time.Since(ship.lastHoldIncome) < holdIncomeInterval {
+		return nil
+	}
+
+	earned := make([]int, len(ship.lobby.Teams))
+
+	for time.Since(ship.lastHoldIncome) >= holdIncomeInterval {
+		ship.lastHoldIncome = ship.lastHoldIncome.Add(holdIncomeInterval)
+
+		for _, f := range ship.fm.flags {
+			if f.owner != nil {
+				earned[f.owner.Index()] += income
+			}
+		}
+	}
+
+	for i, tokens := range earned {
+		ship.bankedTokens[i] += tokens
+	}
+
+	msg := NewMessage("ship_hold_income")
+	_ = msg.Add("earned", earned)
+	_ = msg.Add("team_scores", ship.teamScores())
+
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
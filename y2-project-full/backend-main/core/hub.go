@@ -190,8 +190,8 @@ type Hub struct {
 	kill chan *Client
 }
 
-// NewHub returns a new hub with no lobbies.
-func NewHub(minigames map[string]MinigamePrototype) *Hub {
+// NewHub returns a new hub with no lobbies. Player profiles are kept in the given storage.
+func NewHub(minigames map[string]MinigamePrototype, storage Storage) *Hub {
 	Logger.Info("creating hub")
 
 	event := make(chan func() error, 10)
@@ -203,6 +203,7 @@ func NewHub(minigames map[string]MinigamePrototype) *Hub {
 			},
 
 			minigames,
+			storage,
 		),
 
 		out:   make(chan ClientMessageOut, 10),
@@ -289,6 +290,10 @@ func killClient(client *Client) {
 		l.Warn("error closing client connection", zap.Error(err))
 	}
 
+	client.closed = true
+
+	client.lobbyMgr.forgetClient(client)
+
 	if client.Player == nil {
 		// logger.Infoln("client died outside lobby")
 
@@ -2,18 +2,136 @@ package core
 
 import (
 	_ "embed"
+	"errors"
+	"go.uber.org/zap"
 	"math/rand"
 	"strings"
+	"time"
 )
 
-// randomDigit returns a random ASCII digit (0-10) as a rune.
-func randomDigit() rune {
-	return rune('0' + (rand.Uint32() % 10))
+// defaultLobbyCodeAlphabet is the set of characters used for lobby codes unless overridden. It
+// leaves out characters that are easily mistaken for one another when read aloud or written down
+// (0/O, 1/I/L, 2/Z, 5/S and 8/B).
+const defaultLobbyCodeAlphabet = "34679ACDEFGHJKMNPQRTUVWXY"
+
+// defaultLobbyCodeLength is the number of characters in a lobby code unless overridden.
+const defaultLobbyCodeLength = 5
+
+// maxLobbyCodeLength is the longest lobby code that the database can store.
+const maxLobbyCodeLength = 8
+
+// lobbyCodeExpiry is the amount of time for which the code of a deleted lobby is kept out of
+// circulation. This stops players holding an old code from landing in a stranger's new lobby.
+const lobbyCodeExpiry = 10 * time.Minute
+
+// lobbyCodeAttempts is the number of random codes we try before giving up on finding a free one.
+const lobbyCodeAttempts = 100
+
+// errNoLobbyCodes is returned when no unused lobby code could be found.
+var errNoLobbyCodes = errors.New("no unused lobby codes available")
+
+// A LobbyCodeConfig describes the shape of the lobby codes handed out to players.
+type LobbyCodeConfig struct {
+	// Length is the number of characters in each code.
+	Length int
+
+	// Alphabet contains every character that may appear in a code.
+	Alphabet string
+}
+
+// LobbyCodeConfigFromArgs returns the lobby code configuration given by the `--lobby-code-length`
+// and `--lobby-code-alphabet` command-line flags, falling back to the defaults for any flag that
+// is missing. The server exits if the configuration is unusable.
+func LobbyCodeConfigFromArgs() LobbyCodeConfig {
+	config := LobbyCodeConfig{
+		Length:   intArg("--lobby-code-length", defaultLobbyCodeLength),
+		Alphabet: strings.ToUpper(stringArg("--lobby-code-alphabet", defaultLobbyCodeAlphabet)),
+	}
+
+	if config.Length < 1 || config.Length > maxLobbyCodeLength {
+		Logger.Fatal(
+			"--lobby-code-length must be between 1 and the maximum code length",
+			zap.Int("given", config.Length),
+			zap.Int("max", maxLobbyCodeLength),
+		)
+	}
+
+	if len([]rune(config.Alphabet)) < 2 {
+		Logger.Fatal("--lobby-code-alphabet must contain at least two characters")
+	}
+
+	return config
+}
+
+// NormaliseLobbyCode turns a code typed by a player into the form that we hand out, so that codes
+// are not case-sensitive and stray whitespace is ignored.
+func NormaliseLobbyCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// randomLobbyCode generates a random lobby code with the given configuration.
+func randomLobbyCode(config LobbyCodeConfig) string {
+	alphabet := []rune(config.Alphabet)
+	code := make([]rune, config.Length)
+
+	for i := range code {
+		code[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+
+	return string(code)
+}
+
+// A lobbyCodeAllocator hands out lobby codes which do not clash with any code in use or any code
+// which has recently been retired.
+type lobbyCodeAllocator struct {
+	// config determines what the generated codes look like.
+	config LobbyCodeConfig
+
+	// retired maps the codes of deleted lobbies to the time at which they may be handed out again.
+	retired map[string]time.Time
+}
+
+// newLobbyCodeAllocator returns a pointer to a new allocator with no retired codes.
+func newLobbyCodeAllocator(config LobbyCodeConfig) *lobbyCodeAllocator {
+	return &lobbyCodeAllocator{
+		config:  config,
+		retired: make(map[string]time.Time),
+	}
+}
+
+// pruneRetired forgets retired codes whose expiry time has passed.
+func (alloc *lobbyCodeAllocator) pruneRetired() {
+	now := time.Now()
+
+	for code, expiry := range alloc.retired {
+		if now.After(expiry) {
+			delete(alloc.retired, code)
+		}
+	}
+}
+
+// allocate returns a code for which inUse returns false and which has not been retired recently.
+// It returns errNoLobbyCodes if no such code could be found.
+func (alloc *lobbyCodeAllocator) allocate(inUse func(code string) bool) (string, error) {
+	alloc.pruneRetired()
+
+	for i := 0; i < lobbyCodeAttempts; i++ {
+		code := randomLobbyCode(alloc.config)
+
+		if _, isRetired := alloc.retired[code]; isRetired || inUse(code) {
+			// Try another.
+			continue
+		}
+
+		return code, nil
+	}
+
+	return "", errNoLobbyCodes
 }
 
-// randomLobbyCode generates a random four-digit number for use as a lobby ID.
-func randomLobbyCode() string {
-	return string([]rune{randomDigit(), randomDigit(), randomDigit(), randomDigit()})
+// retire takes the given code out of circulation until lobbyCodeExpiry has passed.
+func (alloc *lobbyCodeAllocator) retire(code string) {
+	alloc.retired[code] = time.Now().Add(lobbyCodeExpiry)
 }
 
 //go:embed usernames.txt
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+)
+
+// acceptsLateJoiners returns true if and only if players who join the lobby now should be brought
+// straight into the ship. Players who join during the endgame wait in the lobby for the next game.
+func (ship *Ship) acceptsLateJoiners() bool {
+	return !ship.isEndgame
+}
+
+// welcomeLateJoiner sends a player who has joined during the game the usual welcome message, along
+// with everything that has happened in the ship so far.
+func (ship *Ship) welcomeLateJoiner(p *Player) error {
+	ship.logger().Info("welcoming late joiner", zap.String("name", p.Name))
+
+	msg := ship.welcomeMessage(p)
+	_ = msg.Add("late_join", true)
+	_ = msg.Add("seconds_left", ship.timer.TimeLeft().Seconds())
+	_ = msg.Add("flag_states", ship.flagStates())
+	_ = msg.Add("team_scores", ship.teamScores())
+
+	if event := ship.eventState(); event != nil {
+		_ = msg.Add("event", event)
+	}
+
+	return p.Client.Send(msg)
+}
+
+// admitLateJoiner brings a player who has just joined the lobby into the running game on the team
+// they were given. If the team had a place left by somebody who disconnected, the player takes it.
+// The player starts at their team's spawn, and their individual score counts from now.
+func (ship *Ship) admitLateJoiner(player *Player) error {
+	team := player.Team
+
+	if ship.vacancies[team] > 0 {
+		ship.vacancies[team]--
+	}
+
+	ship.logger().Info(
+		"player joining game in progress",
+		zap.String("name", player.Name),
+		zap.Int("team", int(team.Index())),
+	)
+
+	player.Activity = ship
+	ship.individualScores[player] = 0
+	ship.startStats(player)
+
+	// The welcome message includes the player's spawn, so it has to be set first.
+	spawn := teamSpawns[team.Index()].centre
+	ship.pm.Map[player] = spawn
+
+	welcomeErr := ship.welcomeLateJoiner(player)
+	spawnErr := ship.pm.SpawnPlayer(player, spawn)
+
+	// The new player may be just what a waiting flag needs.
+	return errors.Join(welcomeErr, spawnErr, ship.addPlayersToFlags())
+}
@@ -3,9 +3,11 @@ package core
 import (
 	"errors"
 	"go.uber.org/zap"
+	"strconv"
+	"time"
 )
 
-// AllowSmallerLobbies disables the requirement of having six players to start a game.
+// AllowSmallerLobbies disables the requirement of having full teams to start a game.
 const AllowSmallerLobbies = true
 
 // A Lobby is a group of players who play together.
@@ -13,11 +15,56 @@ type Lobby struct {
 	// manager is a pointer to the manager which is responsible for this lobby.
 	manager *LobbyManager
 
-	// Teams is an array of two team pointers. The order of these pointers will not change.
-	Teams [2]*Team
+	// Teams is a slice of team pointers, one for each team that the lobby settings ask for. The
+	// order of these pointers only changes when the number of teams does.
+	Teams []*Team
 
 	// ID is the lobby's unique identifier.
 	ID string
+
+	// Host is the player who controls the lobby. This is the player who created the lobby unless
+	// they have left or handed the role to somebody else. Matchmade lobbies have no host.
+	Host *Player
+
+	// Settings holds the options for the lobby's next game.
+	Settings LobbySettings
+
+	// Locked is true if and only if the host has stopped any new players from joining.
+	Locked bool
+
+	// kickedProfiles contains the IDs of the profiles which have been kicked from this lobby and
+	// may not rejoin it.
+	kickedProfiles map[string]struct{}
+
+	// kickedAddrs contains the IP addresses from which players have been kicked from this lobby.
+	// Guests can always start again with a new profile, so no new connection from these addresses
+	// may join the lobby either.
+	kickedAddrs map[string]struct{}
+
+	// Public is true if and only if the lobby is listed in the lobby browser, allowing players to
+	// join it without being given the code.
+	Public bool
+
+	// matchmade is true if and only if the lobby was created by the matchmaking queue. Matchmade
+	// lobbies balance their own teams and start automatically once they have enough players.
+	matchmade bool
+
+	// SeriesScores is the number of games won by each team since the series began. A series runs
+	// across consecutive games with the same teams.
+	SeriesScores []int
+
+	// SeriesGames is the number of games played since the series began, including draws.
+	SeriesGames int
+
+	// ship is the ship activity for the game being played, or nil if there is no game running.
+	ship *Ship
+
+	// chatHistory holds the most recent chat messages sent in the lobby, oldest first.
+	chatHistory []ChatEntry
+
+	// practice is true if and only if the lobby only holds the teams for a practice session. See
+	// practiceSession.
+	practice bool
 }
 
 // buildPlayerNameSet returns a set containing the name of every player in the lobby.
@@ -47,10 +94,44 @@ func (lobby *Lobby) generatePlayerName() string {
 	}
 }
 
+// uniquePlayerName returns the given name if nobody in the lobby is using it. Otherwise, a number
+// is added to the end of the name to make it unique, cutting the name short if needed so that it
+// is no longer than maxDisplayNameLength.
+func (lobby *Lobby) uniquePlayerName(name string) string {
+	taken := lobby.buildPlayerNameSet()
+
+	if _, isTaken := taken[name]; !isTaken {
+		return name
+	}
+
+	for i := 2; ; i++ {
+		suffix := strconv.Itoa(i)
+		numbered := truncateRunes(name, maxDisplayNameLength-len(suffix)) + suffix
+
+		if _, isTaken := taken[numbered]; !isTaken {
+			return numbered
+		}
+	}
+}
+
+// truncateRunes returns the first n runes of the given string, or the whole string if it is no
+// longer than that.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+
+	if len(runes) <= n {
+		return s
+	}
+
+	return string(runes[:n])
+}
+
 // AddClient adds the given client to this lobby.
-// This will also give the client a player pointer.
+// This will also give the client a player pointer. The player is given the requested name if it is
+// allowed, or the empty string can be given to have a name chosen for them. If the requested name
+// is not allowed, the reason is returned.
 // This method panics if the client already has a player pointer.
-func (lobby *Lobby) AddClient(client *Client) {
+func (lobby *Lobby) AddClient(client *Client, requestedName string) string {
 	if client.Player != nil {
 		Logger.Panic(
 			"client already has a player",
@@ -58,25 +139,107 @@ func (lobby *Lobby) AddClient(client *Client) {
 		)
 	}
 
+	name, rejection := lobby.choosePlayerName(client, requestedName)
+
 	client.Player = &Player{
 		Team:     nil,
 		Client:   client,
 		Activity: nil,
-		Name:     lobby.generatePlayerName(),
+		Name:     name,
+		joinedAt: time.Now(),
+	}
+
+	lobby.joinTeam().AddPlayer(client.Player)
+
+	return rejection
+}
+
+// newTeam returns a pointer to a new empty team in this lobby. The team is not added to the team
+// list.
+func (lobby *Lobby) newTeam() *Team {
+	return &Team{Lobby: lobby, Players: make(map[*Player]struct{})}
+}
+
+// joinTeam returns the team that a player joining the lobby should be put on. This is a team with
+// a place in the ship left by a player who disconnected, if there is one, and otherwise the team
+// with the fewest players, preferring lower indices when teams are balanced. Players who join
+// during a game are put on this team in the ship too.
+func (lobby *Lobby) joinTeam() *Team {
+	if lobby.ship != nil {
+		if team := lobby.ship.vacantTeam(); team != nil {
+			return team
+		}
+	}
+
+	return lobby.smallestTeam()
+}
+
+// smallestTeam returns the team with the fewest players. Ties go to the team with the lowest
+// index.
+func (lobby *Lobby) smallestTeam() *Team {
+	smallest := lobby.Teams[0]
+
+	for _, team := range lobby.Teams[1:] {
+		if len(team.Players) < len(smallest.Players) {
+			smallest = team
+		}
+	}
+
+	return smallest
+}
+
+// largestTeam returns the team with the most players. Ties go to the team with the lowest index.
+func (lobby *Lobby) largestTeam() *Team {
+	largest := lobby.Teams[0]
+
+	for _, team := range lobby.Teams[1:] {
+		if len(team.Players) > len(largest.Players) {
+			largest = team
+		}
 	}
 
-	if len(lobby.Teams[0].Players) <= len(lobby.Teams[1].Players) {
-		// Add to T0 if teams are balanced or T0 has fewer players.
-		lobby.Teams[0].AddPlayer(client.Player)
-	} else {
-		// Add to T1 if T1 has fewer players.
-		lobby.Teams[1].AddPlayer(client.Player)
+	return largest
+}
+
+// smallestTeamSize returns the number of players on the smallest team.
+func (lobby *Lobby) smallestTeamSize() int {
+	return len(lobby.smallestTeam().Players)
+}
+
+// setTeamCount changes the number of teams in the lobby. Players on teams that are removed are
+// moved onto the smallest remaining teams, and are returned.
+//
+// This must only be called while every player is in the lobby activity.
+func (lobby *Lobby) setTeamCount(n int) []*Player {
+	for len(lobby.Teams) < n {
+		lobby.Teams = append(lobby.Teams, lobby.newTeam())
+	}
+
+	removed := lobby.Teams[n:]
+	lobby.Teams = lobby.Teams[:n]
+
+	var moved []*Player
+
+	for _, team := range removed {
+		for _, p := range team.randomisedMembers() {
+			team.RemovePlayer(p)
+			lobby.smallestTeam().AddPlayer(p)
+
+			moved = append(moved, p)
+		}
 	}
+
+	// Scores from before the change belong to a different set of teams.
+	lobby.resetSeries()
+
+	return moved
 }
 
 // RemovePlayer removes the given player from this lobby.
+// If the player was the host, the role passes to the player who has been in the lobby the longest,
+// and every remaining player is notified.
 // It panics if the player is not in this lobby.
-func (lobby *Lobby) RemovePlayer(player *Player) {
+func (lobby *Lobby) RemovePlayer(player *Player) error {
 	if player.Lobby() != lobby {
 		Logger.Panic("player was not in this lobby", zap.Any("player", player))
 	}
@@ -88,32 +251,221 @@ func (lobby *Lobby) RemovePlayer(player *Player) {
 	player.Client = nil
 
 	if lobby.PlayerCount() == 0 {
+		lobby.Host = nil
+
 		// Delete the lobby from the manager so that nobody else can join.
 		lobby.manager.forget(lobby)
+
+		return nil
 	}
+
+	if lobby.Host == player {
+		return lobby.migrateHost()
+	}
+
+	return nil
 }
 
-// IsReady returns true if and only if both teams are even and there are enough players to start a
-// game.
+// longestPresentPlayer returns a pointer to the player who joined the lobby earliest, or nil if
+// the lobby is empty.
+func (lobby *Lobby) longestPresentPlayer() *Player {
+	var earliest *Player
+
+	_ = lobby.ForAllPlayers(func(p *Player) error {
+		if p.IsBot() {
+			// Bots can't host.
+			return nil
+		}
+
+		if earliest == nil || p.joinedAt.Before(earliest.joinedAt) {
+			earliest = p
+		}
+
+		return nil
+	})
+
+	return earliest
+}
+
+// migrateHost hands the host role to the player who has been in the lobby the longest.
+func (lobby *Lobby) migrateHost() error {
+	next := lobby.longestPresentPlayer()
+
+	if next == nil {
+		// Only bots are left, and they will be removed when the ship ends.
+		lobby.Host = nil
+		return nil
+	}
+
+	return lobby.SetHost(next)
+}
+
+// SetHost makes the given player the host and notifies every player in the lobby.
+func (lobby *Lobby) SetHost(player *Player) error {
+	lobby.Host = player
+
+	Logger.Info(
+		"lobby has new host",
+		zap.String("lobby", lobby.ID),
+		zap.String("host", player.Name),
+	)
+
+	msg := NewMessage("lobby_host_change").Add("host", player.Name)
+
+	return lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// hostName returns the name of the lobby's host, or nil if the lobby has no host.
+func (lobby *Lobby) hostName() *string {
+	if lobby.Host == nil {
+		return nil
+	}
+
+	return &lobby.Host.Name
+}
+
+// PlayerByName returns a pointer to the player in this lobby with the given name, or nil if there
+// is no such player.
+func (lobby *Lobby) PlayerByName(name string) *Player {
+	var found *Player
+
+	_ = lobby.ForAllPlayers(func(p *Player) error {
+		if p.Name == name {
+			found = p
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// IsReady returns true if and only if the teams are set up as the lobby settings require, there
+// are enough players to start a game and at least one minigame in the pool can be played with
+// them.
 func (lobby *Lobby) IsReady() bool {
-	n0 := len(lobby.Teams[0].Players)
-	n1 := len(lobby.Teams[1].Players)
+	smallest := lobby.smallestTeamSize()
+
+	// There should never be zero players in the lobby, because empty lobbies are deleted.
+	// We still check just in case. Every team must have somebody on it.
+	if smallest == 0 {
+		return false
+	}
 
-	if AllowSmallerLobbies {
-		// There should never be zero players in the lobby, because empty lobbies are deleted.
-		// We still check just in case.
-		return n0 > 0 && n0 == n1
+	if smallest != len(lobby.largestTeam().Players) && !lobby.Settings.AllowUnevenTeams {
+		return false
 	}
 
-	return n0 == 3 && n1 == 3
+	if !AllowSmallerLobbies && lobby.PlayerCount() != lobby.Settings.MaxPlayers() {
+		return false
+	}
+
+	enabled := lobby.manager.enabledMinigames()
+	pool := lobby.Settings.playablePool(enabled, smallest, len(lobby.Teams))
+
+	return len(pool) > 0
 }
 
 // ForAllPlayers calls fn for every player in the lobby.
 func (lobby *Lobby) ForAllPlayers(fn func(*Player) error) error {
-	return errors.Join(lobby.Teams[0].ForAllMembers(fn), lobby.Teams[1].ForAllMembers(fn))
+	errs := make([]error, 0, len(lobby.Teams))
+
+	for _, team := range lobby.Teams {
+		errs = append(errs, team.ForAllMembers(fn))
+	}
+
+	return errors.Join(errs...)
 }
 
 // PlayerCount returns the number of players in the lobby.
 func (lobby *Lobby) PlayerCount() int {
-	return len(lobby.Teams[0].Players) + len(lobby.Teams[1].Players)
+	count := 0
+
+	for _, team := range lobby.Teams {
+		count += len(team.Players)
+	}
+
+	return count
+}
+
+// IsFull returns true if and only if no more players can join the lobby.
+func (lobby *Lobby) IsFull() bool {
+	return lobby.PlayerCount() >= lobby.Settings.MaxPlayers()
+}
+
+// banClient stops the given client's profile, and any connection from the same IP address, from
+// joining the lobby again.
+func (lobby *Lobby) banClient(client *Client) {
+	if client.Profile != nil {
+		lobby.kickedProfiles[client.Profile.ID] = struct{}{}
+	}
+
+	if client.conn != nil {
+		lobby.kickedAddrs[clientIP(client)] = struct{}{}
+	}
+}
+
+// isBanned returns true if and only if the given client's profile or IP address has been kicked
+// from the lobby.
+func (lobby *Lobby) isBanned(client *Client) bool {
+	if client.Profile != nil {
+		if _, ok := lobby.kickedProfiles[client.Profile.ID]; ok {
+			return true
+		}
+	}
+
+	_, ok := lobby.kickedAddrs[clientIP(client)]
+
+	return ok
+}
+
+// InGame returns true if and only if the lobby's players have left the lobby activity to play a
+// game.
+func (lobby *Lobby) InGame() bool {
+	inGame := false
+
+	_ = lobby.ForAllPlayers(func(p *Player) error {
+		if !p.InLobbyActivity() {
+			inGame = true
+		}
+
+		return nil
+	})
+
+	return inGame
+}
+
+// TeamSizes returns the number of players on each team, in team index order.
+func (lobby *Lobby) TeamSizes() []int {
+	sizes := make([]int, 0, len(lobby.Teams))
+
+	for _, team := range lobby.Teams {
+		sizes = append(sizes, len(team.Players))
+	}
+
+	return sizes
+}
+
+// balanceTeams moves players from the largest team to the smallest one until the team sizes differ
+// by at most one. It returns the players that were moved.
+//
+// This must only be called while every player is in the lobby activity.
+func (lobby *Lobby) balanceTeams() []*Player {
+	var moved []*Player
+
+	for {
+		big, small := lobby.largestTeam(), lobby.smallestTeam()
+
+		if len(big.Players)-len(small.Players) <= 1 {
+			return moved
+		}
+
+		// Move a random player so that nobody is always the one to be moved.
+		p := big.randomisedMembers()[0]
+		p.SwitchTeam(small)
+
+		moved = append(moved, p)
+	}
 }
@@ -19,6 +19,9 @@ type LobbyActivity struct {
 	// readyPlayers is the set of players who have asserted that they are ready for the core to
 	// begin.
 	readyPlayers map[*Player]struct{}
+
+	// postGame is the rematch vote in progress after a game, or nil if there is no vote running.
+	postGame *postGame
 }
 
 // NewLobbyActivity returns a pointer to a new lobby activity for the given lobby and scheduler.
@@ -50,10 +53,19 @@ func (act *LobbyActivity) notifyTeamChange(player *Player, teamInt int) error {
 func (act *LobbyActivity) doTeamChange(player *Player, message *Message) error {
 	teamInt, err := message.GetInt("team")
 
-	if err != nil || (teamInt != 0 && teamInt != 1) {
+	if err != nil || teamInt < 0 || teamInt >= len(act.lobby.Teams) {
 		return player.Client.Send(NewMessage("lobby_team_change_format_error"))
 	}
 
+	if act.lobby.matchmade {
+		// Matchmaking decides the teams.
+		return player.Client.Send(NewMessage("lobby_team_change_matchmade_error"))
+	}
+
+	if team := act.lobby.Teams[teamInt]; team != player.Team && team.IsFull() {
+		return player.Client.Send(NewMessage("lobby_team_change_full_error"))
+	}
+
 	act.playerLogger(player).Info("changing player team", zap.Int("team", teamInt))
 
 	player.SwitchTeam(act.lobby.Teams[teamInt])
@@ -80,13 +92,62 @@ func (act *LobbyActivity) notifyBye(player *Player) error {
 func (act *LobbyActivity) doBye(player *Player) error {
 	act.playerLogger(player).Info("removing player")
 
-	act.lobby.RemovePlayer(player)
+	removeErr := act.lobby.RemovePlayer(player)
 
 	// Unready the player.
 	delete(act.readyPlayers, player)
 
 	// Notify remaining players.
-	return act.notifyBye(player)
+	byeErr := errors.Join(removeErr, act.notifyBye(player), act.removeRematchVoter(player))
+
+	if !act.lobby.matchmade {
+		return byeErr
+	}
+
+	// Nobody picks their own team in a matchmade lobby, so fill the gap the player has left.
+	return errors.Join(byeErr, act.rebalanceMatchmadeTeams())
+}
+
+// rebalanceMatchmadeTeams evens out the teams of a matchmade lobby and notifies the players of any
+// moves.
+func (act *LobbyActivity) rebalanceMatchmadeTeams() error {
+	errs := make([]error, 0)
+
+	for _, moved := range act.lobby.balanceTeams() {
+		act.playerLogger(moved).Info("moved player to balance teams")
+
+		errs = append(errs, act.notifyTeamAssigned(moved))
+	}
+
+	return errors.Join(errs...)
+}
+
+// notifyTeamAssigned tells the given player and their peers that the player has been moved to
+// their current team by somebody else.
+func (act *LobbyActivity) notifyTeamAssigned(player *Player) error {
+	teamInt := int(player.Team.Index())
+
+	// Tell the player themselves as well as their peers, since they did not ask to move.
+	msg := NewMessage("lobby_team_assigned").Add("team", teamInt)
+
+	return errors.Join(player.Client.Send(msg), act.notifyTeamChange(player, teamInt))
+}
+
+// tryStartMatchmadeGame starts the game for a matchmade lobby once it has enough players and the
+// teams are ready. It does nothing for lobbies that were not created by matchmaking.
+func (act *LobbyActivity) tryStartMatchmadeGame() error {
+	lobby := act.lobby
+
+	if !lobby.matchmade || lobby.PlayerCount() < lobby.manager.matchmakingSize || !lobby.IsReady() {
+		return nil
+	}
+
+	if lobby.ship != nil {
+		// Players who arrive during a game join it rather than starting another.
+		return nil
+	}
+
+	return act.doStartGame()
 }
 
 // notifyReadyChange sends a message to all peers of the given player reporting that the player's
@@ -109,7 +170,12 @@ func (act *LobbyActivity) doStartGame() error {
 	// automatically ready.
 	clear(act.readyPlayers)
 
+	// Starting a game by readying up settles any rematch vote.
+	act.endPostGame()
+
 	ship := NewShip(act.lobby, act.scheduler)
+	act.lobby.ship = ship
+
 	return ship.Start()
 }
 
@@ -131,7 +197,8 @@ func (act *LobbyActivity) doReadyUp(player *Player) error {
 
 	readyCount := len(act.readyPlayers)
 
-	if readyCount == 6 || (AllowSmallerLobbies && readyCount == act.lobby.PlayerCount()) {
+	if readyCount == act.lobby.Settings.MaxPlayers() ||
+		(AllowSmallerLobbies && readyCount == act.lobby.PlayerCount()) {
 		// All players ready.
 		startErr := act.doStartGame()
 
@@ -180,6 +247,7 @@ func (act *LobbyActivity) notifyJoineePeers(player *Player) error {
 	msg := NewMessage("lobby_peer_joined")
 	_ = msg.Add("their_name", player.Name)
 	_ = msg.Add("their_team", player.Team.Index())
+	_ = msg.Add("their_rating", player.Rating())
 
 	return player.ForAllLobbyPeers(func(peer *Player) error {
 		return peer.Client.Send(msg)
@@ -191,17 +259,36 @@ func (act *LobbyActivity) notifyJoinee(player *Player) error {
 	msg := NewMessage("lobby_welcome")
 	_ = msg.Add("your_name", player.Name)
 	_ = msg.Add("your_team", player.Team.Index())
+	_ = msg.Add("your_rating", player.Rating())
 	_ = msg.Add("lobby_id", act.lobby.ID)
+	_ = msg.Add("public", act.lobby.Public)
+	_ = msg.Add("matchmade", act.lobby.matchmade)
+	_ = msg.Add("host", act.lobby.hostName())
+	_ = msg.Add("locked", act.lobby.Locked)
+	_ = msg.Add("settings", act.lobby.Settings.ToMap())
+	_ = msg.Add("series_scores", act.lobby.SeriesScores)
+	_ = msg.Add("series_games", act.lobby.SeriesGames)
+
+	if act.postGame != nil {
+		rematch := make(map[string]interface{})
+		rematch["seconds_left"] = act.postGame.timer.TimeLeft().Seconds()
+		rematch["tally"] = act.postGame.tally()
+
+		_ = msg.Add("rematch_vote", rematch)
+	}
 
 	peerTeamMap := make(map[string]uint8)
+	peerRatingMap := make(map[string]float64)
 
 	_ = player.ForAllLobbyPeers(func(peer *Player) error {
 		peerTeamMap[peer.Name] = peer.Team.Index()
+		peerRatingMap[peer.Name] = peer.Rating()
 
 		return nil
 	})
 
 	_ = msg.Add("peer_teams", peerTeamMap)
+	_ = msg.Add("peer_ratings", peerRatingMap)
 
 	return player.Client.Send(msg)
 }
@@ -212,8 +299,9 @@ func (act *LobbyActivity) notifyPlayerJoin(player *Player) error {
 	return errors.Join(act.notifyJoinee(player), act.notifyJoineePeers(player))
 }
 
-// HandleJoinRequest handles a join request from the given client.
-func (act *LobbyActivity) HandleJoinRequest(client *Client) error {
+// HandleJoinRequest handles a join request from the given client. The client's player is given the
+// requested name if it is allowed, or the empty string can be given to have a name chosen for them.
+func (act *LobbyActivity) HandleJoinRequest(client *Client, requestedName string) error {
 	l := act.logger().With(zap.Stringer("addr", client.conn.RemoteAddr()))
 
 	l.Info("handling join request")
@@ -225,24 +313,71 @@ func (act *LobbyActivity) HandleJoinRequest(client *Client) error {
 		return client.Send(NewMessage("client_already_in_lobby_error"))
 	}
 
-	if act.lobby.PlayerCount() > 5 {
+	if act.lobby.isBanned(client) {
+		l.Info("client was kicked from lobby")
+
+		return client.Send(NewMessage("lobby_kicked_error"))
+	}
+
+	if act.lobby.Locked {
+		l.Info("lobby is locked")
+
+		return client.Send(NewMessage("lobby_locked"))
+	}
+
+	if act.lobby.IsFull() {
 		// Info here because this is a response to user behaviour.
 		l.Info("lobby is full")
 
 		return client.Send(NewMessage("lobby_full"))
 	}
 
-	act.lobby.AddClient(client)
+	// Every player needs a rating, so guests who haven't identified themselves get a new profile.
+	if err := act.lobby.manager.ensureProfile(client); err != nil {
+		l.Error("failed to create profile", zap.Error(err))
+
+		if client.Profile == nil {
+			return client.Send(NewMessage("profile_unavailable_error"))
+		}
+	}
+
+	rejection := act.lobby.AddClient(client, requestedName)
 
 	act.playerLogger(client.Player).Info(
 		"added player",
 		zap.Stringer("addr", client.conn.RemoteAddr()),
 	)
 
+	var rejectedErr error
+
+	if rejection != "" {
+		msg := NewMessage("lobby_name_rejected")
+		_ = msg.Add("requested", requestedName)
+		_ = msg.Add("reason", rejection)
+
+		rejectedErr = client.Send(msg)
+	}
+
 	// Put the player into the lobby activity.
 	client.Player.Activity = act
 
-	return act.notifyPlayerJoin(client.Player)
+	// The first player into a lobby created by a player is its host. Matchmade lobbies belong to
+	// nobody.
+	if act.lobby.Host == nil && !act.lobby.matchmade {
+		act.lobby.Host = client.Player
+	}
+
+	joinErr := act.notifyPlayerJoin(client.Player)
+
+	// A player who joins during a game goes straight into the ship.
+	var lateErr error
+
+	if ship := act.lobby.ship; ship != nil && ship.acceptsLateJoiners() {
+		lateErr = ship.admitLateJoiner(client.Player)
+	}
+
+	// Matchmade lobbies start by themselves once the last player arrives.
+	return errors.Join(rejectedErr, joinErr, lateErr, act.tryStartMatchmadeGame())
 }
 
 func (act *LobbyActivity) HandleMessage(player *Player, message *Message) error {
@@ -255,6 +390,13 @@ func (act *LobbyActivity) HandleMessage(player *Player, message *Message) error
 
 	case "lobby_bye":
 		return act.doBye(player)
+
+	case "lobby_kick", "lobby_lock", "lobby_move_player", "lobby_host_transfer",
+		"lobby_settings_update", "lobby_auto_balance":
+		return act.doHostMessage(player, message)
+
+	case "lobby_rematch_vote":
+		return act.doRematchVote(player, message)
 	}
 
 	return player.Client.Send(NewMessage("lobby_unrecognised_message_type").Add(
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+)
+
+// doHostMessage checks that the given player is the lobby host and then handles a host-only
+// message from them.
+func (act *LobbyActivity) doHostMessage(player *Player, message *Message) error {
+	if !player.IsHost() {
+		// A well-formed client will not show host controls to other players.
+		return player.Client.Send(NewMessage("lobby_not_host_error").Add("bad_type", message.Type))
+	}
+
+	switch message.Type {
+	case "lobby_kick":
+		return act.doKick(player, message)
+
+	case "lobby_lock":
+		return act.doLock(player, message)
+
+	case "lobby_move_player":
+		return act.doMovePlayer(player, message)
+
+	case "lobby_host_transfer":
+		return act.doHostTransfer(player, message)
+
+	case "lobby_settings_update":
+		return act.doSettingsUpdate(player, message)
+
+	case "lobby_auto_balance":
+		return act.doAutoBalance(player)
+	}
+
+	Logger.Panic("unhandled host message type", zap.String("type", message.Type))
+
+	// Unreachable
+	return nil
+}
+
+// targetPlayer finds the player named in the "their_name" field of a host message. If the field
+// is missing or names nobody else in the lobby, an error message is sent to the host and nil is
+// returned.
+func (act *LobbyActivity) targetPlayer(host *Player, message *Message) (*Player, error) {
+	name, err := message.GetString("their_name")
+
+	if err != nil {
+		return nil, host.Client.Send(NewMessage("lobby_host_format_error"))
+	}
+
+	target := act.lobby.PlayerByName(name)
+
+	if target == nil || target == host {
+		return nil, host.Client.Send(NewMessage("lobby_host_no_such_peer_error").Add(
+			"their_name",
+			name,
+		))
+	}
+
+	return target, nil
+}
+
+// doKick handles a message from the host asking for a player to be removed from the lobby.
+func (act *LobbyActivity) doKick(host *Player, message *Message) error {
+	target, err := act.targetPlayer(host, message)
+
+	if target == nil {
+		return err
+	}
+
+	act.playerLogger(target).Info("kicking player")
+
+	client := target.Client
+
+	// Stop the player from coming straight back, whether or not they reconnect first.
+	act.lobby.banClient(client)
+
+	removeErr := act.lobby.RemovePlayer(target)
+
+	delete(act.readyPlayers, target)
+
+	kickedErr := client.Send(NewMessage("lobby_kicked"))
+
+	msg := NewMessage("lobby_peer_left")
+	_ = msg.Add("their_name", target.Name)
+	_ = msg.Add("kicked", true)
+
+	peerErr := act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+
+	return errors.Join(removeErr, kickedErr, peerErr, act.removeRematchVoter(target))
+}
+
+// doLock handles a message from the host locking or unlocking the lobby.
+func (act *LobbyActivity) doLock(host *Player, message *Message) error {
+	lockedVal := message.TryGet("locked")
+
+	if lockedVal == nil {
+		return host.Client.Send(NewMessage("lobby_host_format_error"))
+	}
+
+	locked, ok := (*lockedVal).(bool)
+
+	if !ok {
+		return host.Client.Send(NewMessage("lobby_host_format_error"))
+	}
+
+	act.logger().Info("changing lobby lock", zap.Bool("locked", locked))
+
+	act.lobby.Locked = locked
+
+	msg := NewMessage("lobby_lock_change").Add("locked", locked)
+
+	return act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// doMovePlayer handles a message from the host moving a player to another team.
+func (act *LobbyActivity) doMovePlayer(host *Player, message *Message) error {
+	teamInt, err := message.GetInt("team")
+
+	if err != nil || teamInt < 0 || teamInt >= len(act.lobby.Teams) {
+		return host.Client.Send(NewMessage("lobby_host_format_error"))
+	}
+
+	// The host can move themselves too, so we don't use targetPlayer here.
+	name, err := message.GetString("their_name")
+
+	if err != nil {
+		return host.Client.Send(NewMessage("lobby_host_format_error"))
+	}
+
+	target := act.lobby.PlayerByName(name)
+
+	if target == nil {
+		return host.Client.Send(NewMessage("lobby_host_no_such_peer_error").Add("their_name", name))
+	}
+
+	if target.Team == act.lobby.Teams[teamInt] {
+		// Nothing to do.
+		return nil
+	}
+
+	if act.lobby.Teams[teamInt].IsFull() {
+		return host.Client.Send(NewMessage("lobby_host_team_full_error").Add("team", teamInt))
+	}
+
+	act.playerLogger(target).Info("host is moving player", zap.Int("team", teamInt))
+
+	target.SwitchTeam(act.lobby.Teams[teamInt])
+
+	// A change to the teams forces all players to become unready.
+	clear(act.readyPlayers)
+
+	return act.notifyTeamAssigned(target)
+}
+
+// doHostTransfer handles a message from the host giving the host role to another player.
+func (act *LobbyActivity) doHostTransfer(host *Player, message *Message) error {
+	target, err := act.targetPlayer(host, message)
+
+	if target == nil {
+		return err
+	}
+
+	return act.lobby.SetHost(target)
+}
+
+// doAutoBalance handles a message from the host asking for the players to be split into teams with
+// ratings that are as even as possible.
+func (act *LobbyActivity) doAutoBalance(host *Player) error {
+	moved := act.lobby.balanceTeamsByRating()
+
+	act.logger().Info("balanced teams by rating", zap.Int("moved", len(moved)))
+
+	errs := make([]error, 0)
+
+	for _, p := range moved {
+		errs = append(errs, act.notifyTeamAssigned(p))
+	}
+
+	if len(moved) > 0 {
+		// A change to the teams forces all players to become unready.
+		clear(act.readyPlayers)
+	}
+
+	msg := NewMessage("lobby_teams_balanced").Add("team_ratings", act.lobby.TeamRatings())
+
+	errs = append(errs, act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	}))
+
+	return errors.Join(errs...)
+}
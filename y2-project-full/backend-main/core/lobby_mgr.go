@@ -2,8 +2,22 @@ package core
 
 import (
 	"go.uber.org/zap"
+	"net"
+	"time"
 )
 
+// joinFailureWindow is the period over which failed lobby join attempts are counted.
+const joinFailureWindow = 1 * time.Minute
+
+// maxJoinFailuresPerClient is the number of failed lobby join attempts a single connection may make
+// within joinFailureWindow before further attempts are refused.
+const maxJoinFailuresPerClient = 5
+
+// maxJoinFailuresPerIP is the number of failed lobby join attempts that all connections from one IP
+// address may make within joinFailureWindow before further attempts are refused. This is higher
+// than the per-client limit because several players can share an address.
+const maxJoinFailuresPerIP = 20
+
 // A LobbyManager is responsible for multiple lobby activities.
 type LobbyManager struct {
 	// scheduler is the scheduler which will be used by children of this lobby manager.
@@ -15,45 +29,127 @@ type LobbyManager struct {
 	// minigames contains the minigames that can be played by lobbies created by this manager. The
 	// keys are minigame IDs.
 	minigames map[string]MinigamePrototype
+
+	// matchmakingSize is the number of players that a matchmade lobby needs before its game starts.
+	matchmakingSize int
+
+	// codes allocates the IDs for new lobbies.
+	codes *lobbyCodeAllocator
+
+	// clientJoinFailures counts failed lobby join attempts for each client.
+	clientJoinFailures *rateLimiter[*Client]
+
+	// ipJoinFailures counts failed lobby join attempts for each remote IP address.
+	ipJoinFailures *rateLimiter[string]
+
+	// loginFailures counts failed logins for each remote IP address.
+	loginFailures *rateLimiter[string]
+
+	// passwordAttempts counts registrations and password logins for each remote IP address.
+	passwordAttempts *rateLimiter[string]
+
+	// hashSlots holds a value for each password being hashed, limiting how many run at once.
+	hashSlots chan struct{}
+
+	// storage keeps player profiles and accounts between connections.
+	storage Storage
+
+	// profileClients maps the IDs of profiles in use to the clients using them.
+	profileClients map[string]*Client
+
+	// names decides which display names players may use.
+	names *NameFilter
+
+	// moderator checks chat messages and handles reports.
+	moderator Moderator
+
+	// chatLimiter counts the chat messages sent by each client.
+	chatLimiter *rateLimiter[*Client]
+
+	// achievements contains the achievements that players can unlock.
+	achievements []Achievement
+
+	// disabledMinigames is the set of minigames that lobbies may not currently use.
+	disabledMinigames map[string]bool
+
+	// adminKey is the secret that lets a client use admin messages, or empty if admin messages are
+	// turned off.
+	adminKey string
+
+	// adminFailures counts admin messages with the wrong key for each remote IP address.
+	adminFailures *rateLimiter[string]
 }
 
 // NewLobbyManager returns a new lobby manager with no lobbies.
-func NewLobbyManager(scheduler Scheduler, minigames map[string]MinigamePrototype) *LobbyManager {
+func NewLobbyManager(
+	scheduler Scheduler,
+	minigames map[string]MinigamePrototype,
+	storage Storage,
+) *LobbyManager {
+	if err := validateMinigames(minigames); err != nil {
+		Logger.Fatal("invalid minigames", zap.Error(err))
+	}
+
+	if s, ok := storage.(scheduledStorage); ok {
+		s.setScheduler(scheduler)
+	}
+
+	names := NameFilterFromArgs()
+
 	return &LobbyManager{
-		scheduler:  scheduler,
-		activities: make(map[string]*LobbyActivity),
-		minigames:  minigames,
+		scheduler:          scheduler,
+		activities:         make(map[string]*LobbyActivity),
+		minigames:          minigames,
+		matchmakingSize:    matchmakingSizeFromArgs(),
+		codes:              newLobbyCodeAllocator(LobbyCodeConfigFromArgs()),
+		clientJoinFailures: newRateLimiter[*Client](maxJoinFailuresPerClient, joinFailureWindow),
+		ipJoinFailures:     newRateLimiter[string](maxJoinFailuresPerIP, joinFailureWindow),
+		loginFailures:      newRateLimiter[string](maxLoginFailuresPerIP, loginFailureWindow),
+		passwordAttempts:   newRateLimiter[string](maxPasswordAttemptsPerIP, passwordAttemptWindow),
+		hashSlots:          make(chan struct{}, maxConcurrentHashes),
+		storage:            storage,
+		profileClients:     make(map[string]*Client),
+		names:              names,
+		moderator:          &blocklistModerator{filter: names},
+		chatLimiter:        newRateLimiter[*Client](maxChatsPerWindow, chatWindow),
+		achievements:       AchievementsFromArgs(minigames),
+		disabledMinigames:  disabledMinigamesFromArgs(minigames),
+		adminKey:           stringArg("--admin-key", ""),
+		adminFailures:      newRateLimiter[string](maxAdminFailuresPerIP, adminFailureWindow),
 	}
 }
 
-// generateLobbyID returns a lobby ID that is guaranteed to be unique within this manager.
-func (mgr *LobbyManager) generateLobbyID() string {
-	return randomLobbyCode()
+// generateLobbyID returns a lobby ID that is guaranteed to be unique within this manager. It
+// returns an error if every possible ID is in use.
+func (mgr *LobbyManager) generateLobbyID() (string, error) {
+	return mgr.codes.allocate(func(code string) bool {
+		_, exists := mgr.activities[code]
+		return exists
+	})
 }
 
 // createLobby creates a new empty lobby under this manager and returns a pointer to the activity
 // for it.
-func (mgr *LobbyManager) createLobby() *LobbyActivity {
+func (mgr *LobbyManager) createLobby() (*LobbyActivity, error) {
+	id, err := mgr.generateLobbyID()
+
+	if err != nil {
+		return nil, err
+	}
+
 	lobby := &Lobby{
 		manager: mgr,
 
-		Teams: [2]*Team{
-			{
-				Lobby:   nil,
-				Players: map[*Player]struct{}{},
-			},
+		ID: id,
 
-			{
-				Lobby:   nil,
-				Players: map[*Player]struct{}{},
-			},
-		},
+		Settings: defaultLobbySettings(),
 
-		ID: mgr.generateLobbyID(),
+		kickedProfiles: make(map[string]struct{}),
+		kickedAddrs:    make(map[string]struct{}),
 	}
 
-	lobby.Teams[0].Lobby = lobby
-	lobby.Teams[1].Lobby = lobby
+	// Create the empty teams.
+	lobby.setTeamCount(lobby.Settings.TeamCount)
 
 	act := NewLobbyActivity(lobby, mgr.scheduler)
 
@@ -61,19 +157,37 @@ func (mgr *LobbyManager) createLobby() *LobbyActivity {
 
 	act.logger().Info("created new lobby")
 
-	return act
+	return act, nil
 }
 
-// forget deletes the activity for the given lobby, stopping any new players joining.
+// forget deletes the activity for the given lobby, stopping any new players joining. The lobby's
+// code will not be handed out again until it expires.
 func (mgr *LobbyManager) forget(lobby *Lobby) {
 	Logger.Info("deleting lobby", zap.String("id", lobby.ID))
 
 	delete(mgr.activities, lobby.ID)
+
+	mgr.codes.retire(lobby.ID)
 }
 
-// HandleLobbyCreate handles a lobby creation message.
-func (mgr *LobbyManager) HandleLobbyCreate(client *Client) error {
-	return mgr.createLobby().HandleJoinRequest(client)
+// HandleLobbyCreate handles a lobby creation message. If public is true, the new lobby will be
+// listed in the lobby browser. The creator is given the requested name if it is allowed.
+func (mgr *LobbyManager) HandleLobbyCreate(
+	client *Client,
+	public bool,
+	requestedName string,
+) error {
+	act, err := mgr.createLobby()
+
+	if err != nil {
+		Logger.Error("failed to create lobby", zap.Error(err))
+
+		return client.Send(NewMessage("lobby_create_unavailable_error"))
+	}
+
+	act.lobby.Public = public
+
+	return act.HandleJoinRequest(client, requestedName)
 }
 
 // GetActivity returns a pointer to the lobby activity associated with the given ID,
@@ -85,3 +199,43 @@ func (mgr *LobbyManager) GetActivity(id string) *LobbyActivity {
 
 	return nil
 }
+
+// clientIP returns the IP address that the given client is connecting from.
+func clientIP(client *Client) string {
+	addr := client.conn.RemoteAddr().String()
+
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		// Not a host:port pair, so treat the whole address as the host.
+		return addr
+	}
+
+	return host
+}
+
+// joinRetryAfter returns how long the given client must wait before it may make another lobby join
+// attempt, or zero if it may make one now.
+func (mgr *LobbyManager) joinRetryAfter(client *Client) time.Duration {
+	return max(
+		mgr.clientJoinFailures.RetryAfter(client),
+		mgr.ipJoinFailures.RetryAfter(clientIP(client)),
+	)
+}
+
+// recordJoinFailure counts a failed lobby join attempt against the given client and its IP address.
+func (mgr *LobbyManager) recordJoinFailure(client *Client) {
+	mgr.clientJoinFailures.Record(client)
+	mgr.ipJoinFailures.Record(clientIP(client))
+}
+
+// forgetClient discards any state held for the given client. It should be called once the client
+// has disconnected.
+func (mgr *LobbyManager) forgetClient(client *Client) {
+	mgr.clientJoinFailures.Forget(client)
+	mgr.chatLimiter.Forget(client)
+
+	if client.Profile != nil {
+		delete(mgr.profileClients, client.Profile.ID)
+	}
+}
@@ -0,0 +1,465 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"slices"
+	"time"
+)
+
+const (
+	// minShipDuration is the shortest ship stage that a host can choose.
+	minShipDuration = 1 * time.Minute
+
+	// maxShipDuration is the longest ship stage that a host can choose.
+	maxShipDuration = 30 * time.Minute
+
+	// minCooldownMultiplier is the smallest factor by which a host can scale flag cooldowns.
+	minCooldownMultiplier = 0.25
+
+	// maxCooldownMultiplier is the largest factor by which a host can scale flag cooldowns.
+	maxCooldownMultiplier = 4.0
+
+	// defaultTeamSize is the number of players allowed on each team in a new lobby.
+	defaultTeamSize = 3
+
+	// maxTeamSize is the largest number of players per team that a host can choose.
+	maxTeamSize = 5
+
+	// defaultTeamCount is the number of teams in a new lobby.
+	defaultTeamCount = 2
+
+	// maxTeamCount is the largest number of teams that a host can choose.
+	maxTeamCount = 4
+)
+
+// LobbySettings holds the game options that a lobby's host can change before the game starts.
+type LobbySettings struct {
+	// ShipDuration is the length of the ship stage.
+	ShipDuration time.Duration
+
+	// Minigames contains the names of the minigame prototypes that flags may use.
+	Minigames []string
+
+	// Map is the name of the ship map.
+	Map string
+
+	// CooldownMultiplier scales the cooldown of every flag.
+	CooldownMultiplier float64
+
+	// AllowUnevenTeams is true if and only if the game may start with teams of different sizes.
+	AllowUnevenTeams bool
+
+	// TeamSize is the largest number of players allowed on each team.
+	TeamSize int
+
+	// TeamCount is the number of teams in the lobby. With more than two teams, every team plays
+	// against every other.
+	TeamCount int
+
+	// TieBreaks contains the rules used, in order, to choose a winner from teams with equal scores.
+	TieBreaks []tieBreak
+
+	// SuddenDeath is true if and only if a game that is still drawn after the tie-breaks goes to
+	// sudden death.
+	SuddenDeath bool
+
+	// ForfeitCaptures is true if and only if a team captures a flag it activated when no other team
+	// sends players before the activation times out.
+	ForfeitCaptures bool
+
+	// DisconnectPolicy decides what happens when a player leaves during the game.
+	DisconnectPolicy disconnectPolicy
+
+	// ShipEvents is true if and only if timed events shake up the ship during the game.
+	ShipEvents bool
+}
+
+// defaultLobbySettings returns the settings that new lobbies start with.
+func defaultLobbySettings() LobbySettings {
+	m := shipMaps[defaultShipMap]
+
+	return LobbySettings{
+		ShipDuration:       gameDuration(),
+		Minigames:          m.minigameNames(),
+		Map:                defaultShipMap,
+		CooldownMultiplier: 1,
+		AllowUnevenTeams:   false,
+		TeamSize:           defaultTeamSize,
+		TeamCount:          defaultTeamCount,
+		TieBreaks:          slices.Clone(tieBreaks),
+		SuddenDeath:        false,
+		ForfeitCaptures:    true,
+		DisconnectPolicy:   disconnectHandicap,
+		ShipEvents:         true,
+	}
+}
+
+// clone returns a deep copy of the settings.
+func (s LobbySettings) clone() LobbySettings {
+	s.Minigames = slices.Clone(s.Minigames)
+	s.TieBreaks = slices.Clone(s.TieBreaks)
+
+	return s
+}
+
+// ToMap returns a map describing the settings, suitable for adding to a message.
+func (s LobbySettings) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"ship_duration":       s.ShipDuration.Seconds(),
+		"minigames":           s.Minigames,
+		"map":                 s.Map,
+		"cooldown_multiplier": s.CooldownMultiplier,
+		"allow_uneven_teams":  s.AllowUnevenTeams,
+		"team_size":           s.TeamSize,
+		"team_count":          s.TeamCount,
+		"tie_breaks":          s.TieBreaks,
+		"sudden_death":        s.SuddenDeath,
+		"forfeit_captures":    s.ForfeitCaptures,
+		"disconnect_policy":   s.DisconnectPolicy,
+		"ship_events":         s.ShipEvents,
+	}
+}
+
+// MaxPlayers returns the largest number of players that a lobby can hold under these settings.
+func (s LobbySettings) MaxPlayers() int {
+	return s.TeamCount * s.TeamSize
+}
+
+// Cooldown returns the cooldown that a flag for the given prototype has under these settings.
+func (s LobbySettings) Cooldown(proto *MinigamePrototype) time.Duration {
+	return time.Duration(float64(proto.Cooldown) * s.CooldownMultiplier)
+}
+
+// playablePool returns the names of the minigames in the pool which can be played when the
+// smallest team has the given number of players and there are the given number of teams.
+func (s LobbySettings) playablePool(
+	minigames map[string]MinigamePrototype,
+	smallestTeam int,
+	teamCount int,
+) []string {
+	var playable []string
+
+	for _, name := range s.Minigames {
+		proto, ok := minigames[name]
+
+		if ok && proto.TeamSize() <= smallestTeam && proto.teamsPlaying(teamCount) > 0 {
+			playable = append(playable, name)
+		}
+	}
+
+	return playable
+}
+
+// A settingsError describes why a settings update was refused.
+type settingsError struct {
+	// field is the name of the message field that was invalid.
+	field string
+
+	// reason is a short explanation for the client.
+	reason string
+}
+
+// applySettingsUpdate returns a copy of current with the changes from the given
+// `lobby_settings_update` message applied. Fields which are not present in the message are left
+// unchanged. If any field is invalid, nothing is applied and a description of the problem is
+// returned.
+func applySettingsUpdate(
+	current LobbySettings,
+	message *Message,
+	minigames map[string]MinigamePrototype,
+) (LobbySettings, *settingsError) {
+	updated := current.clone()
+
+	if message.TryGet("ship_duration") != nil {
+		secs, err := message.GetNumber("ship_duration")
+		duration := time.Duration(secs * float64(time.Second))
+
+		if err != nil || duration < minShipDuration || duration > maxShipDuration {
+			return current, &settingsError{
+				field: "ship_duration",
+				reason: fmt.Sprintf(
+					"must be between %v and %v seconds",
+					minShipDuration.Seconds(),
+					maxShipDuration.Seconds(),
+				),
+			}
+		}
+
+		updated.ShipDuration = duration
+	}
+
+	if message.TryGet("map") != nil {
+		name, err := message.GetString("map")
+
+		if _, exists := shipMaps[name]; err != nil || !exists {
+			return current, &settingsError{field: "map", reason: "no such map"}
+		}
+
+		updated.Map = name
+	}
+
+	if message.TryGet("cooldown_multiplier") != nil {
+		mult, err := message.GetNumber("cooldown_multiplier")
+
+		if err != nil || mult < minCooldownMultiplier || mult > maxCooldownMultiplier {
+			return current, &settingsError{
+				field: "cooldown_multiplier",
+				reason: fmt.Sprintf(
+					"must be between %v and %v",
+					minCooldownMultiplier,
+					maxCooldownMultiplier,
+				),
+			}
+		}
+
+		updated.CooldownMultiplier = mult
+	}
+
+	if unevenVal := message.TryGet("allow_uneven_teams"); unevenVal != nil {
+		uneven, ok := (*unevenVal).(bool)
+
+		if !ok {
+			return current, &settingsError{field: "allow_uneven_teams", reason: "must be a boolean"}
+		}
+
+		updated.AllowUnevenTeams = uneven
+	}
+
+	if message.TryGet("team_size") != nil {
+		size, err := message.GetInt("team_size")
+
+		if err != nil || size < 1 || size > maxTeamSize {
+			return current, &settingsError{
+				field:  "team_size",
+				reason: fmt.Sprintf("must be between 1 and %v", maxTeamSize),
+			}
+		}
+
+		updated.TeamSize = size
+	}
+
+	if message.TryGet("team_count") != nil {
+		count, err := message.GetInt("team_count")
+
+		if err != nil || count < 2 || count > maxTeamCount {
+			return current, &settingsError{
+				field:  "team_count",
+				reason: fmt.Sprintf("must be between 2 and %v", maxTeamCount),
+			}
+		}
+
+		updated.TeamCount = count
+	}
+
+	if rulesVal := message.TryGet("tie_breaks"); rulesVal != nil {
+		rules, err := parseTieBreaks(*rulesVal)
+
+		if err != nil {
+			return current, err
+		}
+
+		updated.TieBreaks = rules
+	}
+
+	if suddenDeathVal := message.TryGet("sudden_death"); suddenDeathVal != nil {
+		suddenDeath, ok := (*suddenDeathVal).(bool)
+
+		if !ok {
+			return current, &settingsError{field: "sudden_death", reason: "must be a boolean"}
+		}
+
+		updated.SuddenDeath = suddenDeath
+	}
+
+	if forfeitVal := message.TryGet("forfeit_captures"); forfeitVal != nil {
+		forfeit, ok := (*forfeitVal).(bool)
+
+		if !ok {
+			return current, &settingsError{field: "forfeit_captures", reason: "must be a boolean"}
+		}
+
+		updated.ForfeitCaptures = forfeit
+	}
+
+	if message.TryGet("disconnect_policy") != nil {
+		name, err := message.GetString("disconnect_policy")
+		policy := disconnectPolicy(name)
+
+		if err != nil || !slices.Contains(disconnectPolicies, policy) {
+			return current, &settingsError{field: "disconnect_policy", reason: "no such policy"}
+		}
+
+		updated.DisconnectPolicy = policy
+	}
+
+	if eventsVal := message.TryGet("ship_events"); eventsVal != nil {
+		events, ok := (*eventsVal).(bool)
+
+		if !ok {
+			return current, &settingsError{field: "ship_events", reason: "must be a boolean"}
+		}
+
+		updated.ShipEvents = events
+	}
+
+	if poolVal := message.TryGet("minigames"); poolVal != nil {
+		pool, err := parseMinigamePool(*poolVal, minigames)
+
+		if err != nil {
+			return current, err
+		}
+
+		updated.Minigames = pool
+	}
+
+	return updated, nil
+}
+
+// parseMinigamePool turns the JSON-derived value of a "minigames" field into a slice of prototype
+// names, checking that every name refers to a minigame that a lobby can hold enough players for.
+func parseMinigamePool(
+	obj interface{},
+	minigames map[string]MinigamePrototype,
+) ([]string, *settingsError) {
+	arr, ok := obj.([]interface{})
+
+	if !ok || len(arr) == 0 {
+		return nil, &settingsError{field: "minigames", reason: "must be a non-empty array"}
+	}
+
+	pool := make([]string, 0, len(arr))
+
+	for _, elem := range arr {
+		name, ok := elem.(string)
+
+		if !ok {
+			return nil, &settingsError{field: "minigames", reason: "must only contain strings"}
+		}
+
+		proto, exists := minigames[name]
+
+		if !exists {
+			return nil, &settingsError{field: "minigames", reason: "no such minigame: " + name}
+		}
+
+		if proto.PlayerCount > 2*maxTeamSize {
+			return nil, &settingsError{field: "minigames", reason: "too many players: " + name}
+		}
+
+		if !slices.Contains(pool, name) {
+			pool = append(pool, name)
+		}
+	}
+
+	return pool, nil
+}
+
+// parseTieBreaks turns the JSON-derived value of a "tie_breaks" field into a slice of tie-break
+// rules. The slice may be empty, in which case equal scores are always a draw.
+func parseTieBreaks(obj interface{}) ([]tieBreak, *settingsError) {
+	arr, ok := obj.([]interface{})
+
+	if !ok {
+		return nil, &settingsError{field: "tie_breaks", reason: "must be an array"}
+	}
+
+	rules := make([]tieBreak, 0, len(arr))
+
+	for _, elem := range arr {
+		name, ok := elem.(string)
+
+		if !ok {
+			return nil, &settingsError{field: "tie_breaks", reason: "must only contain strings"}
+		}
+
+		rule := tieBreak(name)
+
+		if !slices.Contains(tieBreaks, rule) {
+			return nil, &settingsError{field: "tie_breaks", reason: "no such rule: " + name}
+		}
+
+		if slices.Contains(rules, rule) {
+			return nil, &settingsError{field: "tie_breaks", reason: "repeated rule: " + name}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// checkCapacity returns a description of the problem if the lobby's current players would not fit
+// into the teams described by the given settings, or nil if they would.
+func (lobby *Lobby) checkCapacity(settings LobbySettings) *settingsError {
+	for i, team := range lobby.Teams {
+		// Teams that are about to be removed are redistributed, so their size doesn't matter.
+		if i < settings.TeamCount && len(team.Players) > settings.TeamSize {
+			return &settingsError{
+				field:  "team_size",
+				reason: "a team already has more players than this",
+			}
+		}
+	}
+
+	if lobby.PlayerCount() > settings.MaxPlayers() {
+		return &settingsError{
+			field:  "team_count",
+			reason: "the lobby has too many players for this many teams",
+		}
+	}
+
+	return nil
+}
+
+// notifySettings sends the lobby's current settings to every player in the lobby.
+func (act *LobbyActivity) notifySettings() error {
+	msg := NewMessage("lobby_settings_changed").Add("settings", act.lobby.Settings.ToMap())
+
+	return act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// doSettingsUpdate handles a message from the host changing the lobby settings.
+func (act *LobbyActivity) doSettingsUpdate(host *Player, message *Message) error {
+	updated, invalid := applySettingsUpdate(
+		act.lobby.Settings,
+		message,
+		act.lobby.manager.enabledMinigames(),
+	)
+
+	if invalid == nil {
+		// Nobody gets removed from the lobby by a settings change.
+		invalid = act.lobby.checkCapacity(updated)
+	}
+
+	if invalid != nil {
+		msg := NewMessage("lobby_settings_invalid_error")
+		_ = msg.Add("field", invalid.field)
+		_ = msg.Add("reason", invalid.reason)
+
+		return host.Client.Send(msg)
+	}
+
+	act.logger().Info("updating lobby settings", zap.Any("settings", updated.ToMap()))
+
+	act.lobby.Settings = updated
+
+	// Players agreed to start with the old settings, not these ones.
+	clear(act.readyPlayers)
+
+	// Tell everyone about the new settings first so that they know how many teams there are.
+	errs := []error{act.notifySettings()}
+
+	if updated.TeamCount != len(act.lobby.Teams) {
+		for _, moved := range act.lobby.setTeamCount(updated.TeamCount) {
+			act.playerLogger(moved).Info("moved player from removed team")
+
+			errs = append(errs, act.notifyTeamAssigned(moved))
+		}
+	}
+
+	return errors.Join(errs...)
+}
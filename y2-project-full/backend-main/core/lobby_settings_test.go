@@ -0,0 +1,165 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplySettingsUpdate(t *testing.T) {
+	minigames := map[string]MinigamePrototype{
+		"rps_1v1": {Name: "rps_1v1", PlayerCount: 2},
+		"fb_sp":   {Name: "fb_sp", PlayerCount: 1},
+		"huge":    {Name: "huge", PlayerCount: 2*maxTeamSize + 1},
+	}
+
+	current := defaultLobbySettings()
+
+	tests := []struct {
+		name    string
+		message string
+
+		// change applies the expected changes to a copy of the current settings.
+		change func(s *LobbySettings)
+
+		// wantField is the field that should be refused, or empty if the update should succeed.
+		wantField string
+	}{
+		{
+			name:    "no changes",
+			message: `{"type": "lobby_settings_update"}`,
+			change:  func(s *LobbySettings) {},
+		},
+		{
+			name: "several changes",
+			message: `{"type": "lobby_settings_update", "ship_duration": 120, "team_size": 4,
+				"sudden_death": true, "tie_breaks": ["flags_held"], "minigames": ["fb_sp"]}`,
+			change: func(s *LobbySettings) {
+				s.ShipDuration = 2 * time.Minute
+				s.TeamSize = 4
+				s.SuddenDeath = true
+				s.TieBreaks = []tieBreak{tieBreakFlagsHeld}
+				s.Minigames = []string{"fb_sp"}
+			},
+		},
+		{
+			name:    "empty tie-break list",
+			message: `{"type": "lobby_settings_update", "tie_breaks": []}`,
+			change:  func(s *LobbySettings) { s.TieBreaks = []tieBreak{} },
+		},
+		{
+			name: "repeated minigames",
+			message: `{"type": "lobby_settings_update",
+				"minigames": ["fb_sp", "rps_1v1", "fb_sp"]}`,
+			change: func(s *LobbySettings) { s.Minigames = []string{"fb_sp", "rps_1v1"} },
+		},
+		{
+			name:      "ship too short",
+			message:   `{"type": "lobby_settings_update", "ship_duration": 30}`,
+			wantField: "ship_duration",
+		},
+		{
+			name:      "no such map",
+			message:   `{"type": "lobby_settings_update", "map": "nowhere"}`,
+			wantField: "map",
+		},
+		{
+			name:      "cooldown too long",
+			message:   `{"type": "lobby_settings_update", "cooldown_multiplier": 5}`,
+			wantField: "cooldown_multiplier",
+		},
+		{
+			name:      "uneven teams not a boolean",
+			message:   `{"type": "lobby_settings_update", "allow_uneven_teams": "yes"}`,
+			wantField: "allow_uneven_teams",
+		},
+		{
+			name:      "team too large",
+			message:   `{"type": "lobby_settings_update", "team_size": 6}`,
+			wantField: "team_size",
+		},
+		{
+			name:      "one team",
+			message:   `{"type": "lobby_settings_update", "team_count": 1}`,
+			wantField: "team_count",
+		},
+		{
+			name:      "repeated tie-break",
+			message:   `{"type": "lobby_settings_update", "tie_breaks": ["mvp", "mvp"]}`,
+			wantField: "tie_breaks",
+		},
+		{
+			name:      "no such policy",
+			message:   `{"type": "lobby_settings_update", "disconnect_policy": "ignore"}`,
+			wantField: "disconnect_policy",
+		},
+		{
+			name:      "empty pool",
+			message:   `{"type": "lobby_settings_update", "minigames": []}`,
+			wantField: "minigames",
+		},
+		{
+			name:      "unknown minigame",
+			message:   `{"type": "lobby_settings_update", "minigames": ["chess"]}`,
+			wantField: "minigames",
+		},
+		{
+			name:      "minigame too large",
+			message:   `{"type": "lobby_settings_update", "minigames": ["huge"]}`,
+			wantField: "minigames",
+		},
+		{
+			name: "one bad field refuses everything",
+			message: `{"type": "lobby_settings_update", "team_size": 4,
+				"ship_events": "maybe"}`,
+			wantField: "ship_events",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, ok := ParseMessage([]byte(tt.message))
+
+			if !ok {
+				t.Fatalf("invalid test message %s", tt.message)
+			}
+
+			got, err := applySettingsUpdate(current, message, minigames)
+
+			if tt.wantField != "" {
+				if err == nil || err.field != tt.wantField {
+					t.Fatalf("error = %+v, want one for %q", err, tt.wantField)
+				}
+
+				if !reflect.DeepEqual(got, current) {
+					t.Errorf("settings were changed by a refused update")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("update refused: %+v", err)
+			}
+
+			want := current.clone()
+			tt.change(&want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("settings = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplySettingsUpdateCopies(t *testing.T) {
+	current := defaultLobbySettings()
+	message, _ := ParseMessage([]byte(`{"type": "lobby_settings_update", "team_size": 4}`))
+
+	updated, _ := applySettingsUpdate(current, message, nil)
+	updated.Minigames[0] = "changed"
+
+	if current.Minigames[0] == "changed" {
+		t.Error("the update shares its minigame pool with the current settings")
+	}
+}
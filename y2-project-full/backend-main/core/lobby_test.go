@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+// newTestLobby returns a lobby with one team holding players with the given names.
+func newTestLobby(names ...string) *Lobby {
+	lobby := &Lobby{}
+	lobby.Teams = []*Team{lobby.newTeam()}
+
+	for _, name := range names {
+		lobby.Teams[0].AddPlayer(&Player{Name: name})
+	}
+
+	return lobby
+}
+
+func TestUniquePlayerName(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken []string
+		given string
+		want  string
+	}{
+		{"free", nil, "Alice", "Alice"},
+		{"taken", []string{"Alice"}, "Alice", "Alice2"},
+		{"taken twice", []string{"Alice", "Alice2"}, "Alice", "Alice3"},
+		{"longest name", []string{"Playerabcd"}, "Playerabcd", "Playerabc2"},
+		{"two-digit suffix", []string{"Playerabcd", "Playerabc2", "Playerabc3", "Playerabc4",
+			"Playerabc5", "Playerabc6", "Playerabc7", "Playerabc8", "Playerabc9"},
+			"Playerabcd", "Playerab10"},
+		{"multi-byte runes", []string{"ÄÖÜäöüßéèê"}, "ÄÖÜäöüßéèê", "ÄÖÜäöüßéè2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newTestLobby(tt.taken...).uniquePlayerName(tt.given)
+
+			if got != tt.want {
+				t.Errorf("uniquePlayerName(%q) = %q, want %q", tt.given, got, tt.want)
+			}
+
+			if n := utf8.RuneCountInString(got); n > maxDisplayNameLength {
+				t.Errorf("%q is %d runes long, want at most %d", got, n, maxDisplayNameLength)
+			}
+		})
+	}
+}
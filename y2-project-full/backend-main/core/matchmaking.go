@@ -0,0 +1,107 @@
+package core
+
+import (
+	"go.uber.org/zap"
+)
+
+// matchmakingSizeFromArgs returns the number of players that a matchmade lobby waits for before
+// starting, as given by the `--matchmaking-size` command-line flag. The server exits if the value
+// is unusable.
+func matchmakingSizeFromArgs() int {
+	size := intArg("--matchmaking-size", 2*defaultTeamSize)
+
+	if size < 2 || size > 2*maxTeamSize || size%2 != 0 {
+		Logger.Fatal(
+			"--matchmaking-size must be an even number between 2 and the largest lobby size",
+			zap.Int("given", size),
+			zap.Int("max", 2*maxTeamSize),
+		)
+	}
+
+	return size
+}
+
+// isOpen returns true if and only if the lobby can be found in the lobby browser.
+func (lobby *Lobby) isOpen() bool {
+	return lobby.Public && !lobby.Locked && !lobby.IsFull() && !lobby.InGame()
+}
+
+// openLobbies returns the activities for all lobbies that are listed in the lobby browser.
+func (mgr *LobbyManager) openLobbies() []*LobbyActivity {
+	var open []*LobbyActivity
+
+	for _, act := range mgr.activities {
+		if act.lobby.isOpen() {
+			open = append(open, act)
+		}
+	}
+
+	return open
+}
+
+// HandleLobbyList sends the given client a description of every open public lobby.
+func (mgr *LobbyManager) HandleLobbyList(client *Client) error {
+	lobbies := make([]map[string]interface{}, 0)
+
+	for _, act := range mgr.openLobbies() {
+		lobbies = append(lobbies, map[string]interface{}{
+			"lobby_id":     act.lobby.ID,
+			"player_count": act.lobby.PlayerCount(),
+			"max_players":  act.lobby.Settings.MaxPlayers(),
+			"team_sizes":   act.lobby.TeamSizes(),
+			"matchmade":    act.lobby.matchmade,
+		})
+	}
+
+	return client.Send(NewMessage("lobby_list_result").Add("lobbies", lobbies))
+}
+
+// findMatchmadeLobby returns the activity for the matchmade lobby that a queued player should be
+// placed into, or nil if there is no suitable lobby. The lobby that is closest to starting is
+// preferred so that games fill up quickly.
+func (mgr *LobbyManager) findMatchmadeLobby() *LobbyActivity {
+	var best *LobbyActivity
+
+	for _, act := range mgr.openLobbies() {
+		if !act.lobby.matchmade || act.lobby.PlayerCount() >= mgr.matchmakingSize {
+			continue
+		}
+
+		if best == nil || act.lobby.PlayerCount() > best.lobby.PlayerCount() {
+			best = act
+		}
+	}
+
+	return best
+}
+
+// HandleMatchmakingEnqueue places the given client into a matchmade lobby, creating one if there
+// are none with space. The client is given the requested name if it is allowed.
+func (mgr *LobbyManager) HandleMatchmakingEnqueue(client *Client, requestedName string) error {
+	if client.Player != nil {
+		// HandleJoinRequest would refuse this anyway, but we don't want to create a lobby first.
+		return client.Send(NewMessage("client_already_in_lobby_error"))
+	}
+
+	act := mgr.findMatchmadeLobby()
+
+	if act == nil {
+		var err error
+
+		act, err = mgr.createLobby()
+
+		if err != nil {
+			Logger.Error("failed to create matchmade lobby", zap.Error(err))
+
+			return client.Send(NewMessage("lobby_create_unavailable_error"))
+		}
+
+		act.lobby.Public = true
+		act.lobby.matchmade = true
+
+		// Split the matchmade players evenly between the two teams.
+		act.lobby.Settings.TeamSize = mgr.matchmakingSize / 2
+	}
+
+	return act.HandleJoinRequest(client, requestedName)
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// minigameReadyTimeout is the longest that a minigame waits for its players to say that they are
+// ready before it starts the countdown anyway.
+const minigameReadyTimeout = 10 * time.Second
+
+// minigameCountdown is the time between the countdown being sent and the minigame starting.
+const minigameCountdown = 3 * time.Second
+
+// A minigamePhase is a stage in the life of a minigame.
+type minigamePhase int
+
+const (
+	// phaseGettingReady is the phase in which the minigame waits for its players to be ready.
+	phaseGettingReady minigamePhase = iota
+
+	// phaseCountdown is the phase in which the minigame counts down to its start.
+	phaseCountdown
+
+	// phaseRunning is the phase in which the minigame implementation is being played.
+	phaseRunning
+)
+
+// getReady begins the get-ready phase, asking every player in the minigame to send minigame_ready
+// once they have loaded the minigame. Bots, and players whose clients don't take part in the
+// handshake, are always ready. If none of the players' clients take part, the minigame starts
+// straight away without a countdown, since nobody would be shown it.
+func (ctx *MinigameContext) getReady() error {
+	ctx.phase = phaseGettingReady
+
+	handshake := false
+
+	_ = ctx.ForAllPlayers(func(p *Player) error {
+		if p.IsBot() || !p.Client.readyHandshake {
+			ctx.ready[p] = struct{}{}
+		} else {
+			handshake = true
+		}
+
+		return nil
+	})
+
+	if !handshake {
+		return ctx.start()
+	}
+
+	msg := NewMessage("minigame_get_ready")
+	_ = msg.Add("minigame", ctx.proto.Name)
+	_ = msg.Add("ready_timeout", minigameReadyTimeout.Seconds())
+
+	sendErr := ctx.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+
+	if len(ctx.ready) == ctx.playerCount {
+		return errors.Join(sendErr, ctx.beginCountdown())
+	}
+
+	timeout := time.Now().Add(minigameReadyTimeout)
+
+	ctx.startTimer = SingleTimer(ctx.host.scheduler(), timeout, func() error {
+		if ctx.impl == nil || ctx.phase != phaseGettingReady {
+			// The minigame ended, or everybody became ready, while this was on its way.
+			return nil
+		}
+
+		Logger.Info(
+			"starting minigame without every player ready",
+			zap.String("minigame", ctx.proto.Name),
+			zap.Int("ready", len(ctx.ready)),
+			zap.Int("players", ctx.playerCount),
+		)
+
+		return ctx.beginCountdown()
+	})
+
+	return sendErr
+}
+
+// handleReady handles a minigame_ready message from the given player, starting the countdown if
+// they were the last player that the minigame was waiting for. Players who say that they are ready
+// after the get-ready phase are ignored, since the minigame has stopped waiting for them.
+func (ctx *MinigameContext) handleReady(player *Player) error {
+	if ctx.phase != phaseGettingReady {
+		return nil
+	}
+
+	ctx.ready[player] = struct{}{}
+
+	if len(ctx.ready) < ctx.playerCount {
+		return nil
+	}
+
+	ctx.stopStartTimer()
+
+	return ctx.beginCountdown()
+}
+
+// beginCountdown tells every player in the minigame when it will start, and starts it then.
+func (ctx *MinigameContext) beginCountdown() error {
+	ctx.phase = phaseCountdown
+
+	now := time.Now()
+	startAt := now.Add(minigameCountdown)
+
+	notReady := make([]string, 0)
+
+	_ = ctx.ForAllPlayers(func(p *Player) error {
+		if _, ok := ctx.ready[p]; !ok {
+			notReady = append(notReady, p.Name)
+		}
+
+		return nil
+	})
+
+	msg := NewMessage("minigame_countdown")
+	_ = msg.Add("minigame", ctx.proto.Name)
+	_ = msg.Add("countdown", minigameCountdown.Seconds())
+	_ = msg.Add("start_at_ms", startAt.UnixMilli())
+	_ = msg.Add("server_time_ms", now.UnixMilli())
+	_ = msg.Add("not_ready", notReady)
+
+	ctx.startTimer = SingleTimer(ctx.host.scheduler(), startAt, func() error {
+		if ctx.impl == nil || ctx.phase != phaseCountdown {
+			// The minigame ended during the countdown.
+			return nil
+		}
+
+		ctx.startTimer = ExpiredTimer()
+
+		return ctx.start()
+	})
+
+	return ctx.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// start starts the minigame implementation, from which point the minigame is timed.
+func (ctx *MinigameContext) start() error {
+	ctx.phase = phaseRunning
+	ctx.startedAt = time.Now()
+
+	return ctx.impl.Start(ctx)
+}
+
+// stopStartTimer stops the get-ready or countdown timer if it is running.
+func (ctx *MinigameContext) stopStartTimer() {
+	ctx.startTimer.Stop()
+
+	// Stopping a timer twice blocks, so make sure that it can't happen.
+	ctx.startTimer = ExpiredTimer()
+}
+
+// abandonStart ends a minigame that hasn't started yet because the given player disconnected. The
+// minigame implementation is never started, so it isn't told about the disconnection.
+func (ctx *MinigameContext) abandonStart(player *Player) error {
+	ctx.stopStartTimer()
+
+	if ctx.playerCount == 1 {
+		return ctx.End(SinglePlayerDisconnection(player))
+	}
+
+	return ctx.End(MultiplayerDisconnection(player))
+}
+
+// doClientCapabilities handles a message from a client saying which optional parts of the protocol
+// it supports. At the moment, the only one is the minigame get-ready handshake.
+func (c *Client) doClientCapabilities(message *Message) error {
+	readyVal := message.TryGet("minigame_ready")
+
+	if readyVal == nil {
+		return c.Send(NewMessage("client_capabilities_format_error"))
+	}
+
+	ready, ok := (*readyVal).(bool)
+
+	if !ok {
+		return c.Send(NewMessage("client_capabilities_format_error"))
+	}
+
+	c.readyHandshake = ready
+
+	return nil
+}
+
+// doClockSync handles a message from a client which is working out the difference between its
+// clock and the server's, so that it can show minigame countdowns at the right time. The client's
+// own time is sent back along with the server's, so that it can allow for the round trip.
+func (c *Client) doClockSync(message *Message) error {
+	clientTime, err := message.GetNumber("client_time_ms")
+
+	if err != nil {
+		return c.Send(NewMessage("clock_sync_format_error"))
+	}
+
+	msg := NewMessage("clock_sync")
+	_ = msg.Add("client_time_ms", clientTime)
+	_ = msg.Add("server_time_ms", time.Now().UnixMilli())
+
+	return c.Send(msg)
+}
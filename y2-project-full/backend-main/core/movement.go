@@ -5,8 +5,21 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"time"
 )
 
+// maxMoveInterval is the longest gap between position updates that is used when checking how far
+// a player may have moved. Players who stand still for longer than this don't build up distance
+// that they can later cover instantly.
+const maxMoveInterval = 1 * time.Second
+
+// moveSpeedTolerance is the factor by which a player may exceed their speed limit before a
+// position update is rejected. This allows for network jitter.
+const moveSpeedTolerance = 1.5
+
+// moveSlack is the distance a player may always move in one update, whatever their speed limit.
+const moveSlack = 32.0
+
 // A Position is a 2D position value.
 type Position struct {
 	// X is the horizontal component of the position.
@@ -98,6 +111,20 @@ type PositionManager struct {
 	// msgPrefix is the prefix that will be taken off message type strings before checking them.
 	// For example, the ship activity uses "ship_mov_" here.
 	msgPrefix string
+
+	// SpeedLimit returns the fastest that the given player may move, in units per second. If it is
+	// nil, position updates are accepted without checking how far the player moved.
+	SpeedLimit func(*Player) float64
+
+	// Visible returns true if and only if the first player may be told where the second player is.
+	// If it is nil, every player may see every other.
+	Visible func(viewer *Player, subject *Player) bool
+
+	// Distance maps player pointers to the total distance they have moved.
+	Distance map[*Player]float64
+
+	// lastMove maps player pointers to the time of their last accepted position update.
+	lastMove map[*Player]time.Time
 }
 
 // NewPositionManager returns an empty position manager that uses the given message type prefix.
@@ -105,6 +132,8 @@ func NewPositionManager(prefix string) PositionManager {
 	return PositionManager{
 		Map:       make(map[*Player]Position),
 		msgPrefix: prefix,
+		Distance:  make(map[*Player]float64),
+		lastMove:  make(map[*Player]time.Time),
 	}
 }
 
@@ -124,6 +153,10 @@ func (pm *PositionManager) SpawnPlayer(player *Player, pos Position) error {
 	_ = peerMsg.Add("y", pos.Y)
 
 	peerErr := player.ForAllActivityPeers(func(peer *Player) error {
+		if !pm.canSee(peer, player) {
+			return nil
+		}
+
 		return peer.Client.Send(peerMsg)
 	})
 
@@ -142,14 +175,61 @@ func (pm *PositionManager) notifyNewPosition(player *Player) error {
 
 	// Send the update to all other players in the activity.
 	return player.ForAllActivityPeers(func(peer *Player) error {
+		if !pm.canSee(peer, player) {
+			return nil
+		}
+
 		return peer.Client.Send(msg)
 	})
 }
 
-// doSetPosition updates the position for the given player and notifies their peers.
+// canSee returns true if and only if the viewer may be told where the subject is.
+func (pm *PositionManager) canSee(viewer *Player, subject *Player) bool {
+	return pm.Visible == nil || pm.Visible(viewer, subject)
+}
+
+// isReachable returns true if and only if the player could have moved to pos since their last
+// accepted position update without going faster than their speed limit.
+func (pm *PositionManager) isReachable(player *Player, pos Position) bool {
+	if pm.SpeedLimit == nil {
+		return true
+	}
+
+	elapsed := maxMoveInterval
+
+	if last, ok := pm.lastMove[player]; ok {
+		elapsed = min(time.Since(last), maxMoveInterval)
+	}
+
+	allowed := pm.SpeedLimit(player)*elapsed.Seconds()*moveSpeedTolerance + moveSlack
+
+	return pm.Map[player].DistSq(pos) <= allowed*allowed
+}
+
+// Forget removes all of the position manager's information about the given player.
+func (pm *PositionManager) Forget(player *Player) {
+	delete(pm.Map, player)
+	delete(pm.lastMove, player)
+	delete(pm.Distance, player)
+}
+
+// doSetPosition updates the position for the given player and notifies their peers. If the player
+// could not have reached the new position, the player is told where the server thinks they are
+// instead.
 func (pm *PositionManager) doSetPosition(player *Player, pos Position) error {
-	// todo: Validate position change instead of blindly accepting it.
+	if !pm.isReachable(player, pos) {
+		current := pm.Map[player]
+
+		msg := NewMessage(pm.msgPrefix + "position_rejected")
+		_ = msg.Add("x", current.X)
+		_ = msg.Add("y", current.Y)
+
+		return player.Client.Send(msg)
+	}
+
+	pm.Distance[player] += pm.Map[player].Dist(pos)
 	pm.Map[player] = pos
+	pm.lastMove[player] = time.Now()
 
 	return pm.notifyNewPosition(player)
 }
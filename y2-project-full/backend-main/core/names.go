@@ -0,0 +1,364 @@
+package core
+
+import (
+	_ "embed"
+	"go.uber.org/zap"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minDisplayNameLength is the length of the shortest display name a player can choose.
+const minDisplayNameLength = 3
+
+// defaultNameBlocklist is the list of words that may not appear in display names unless another
+// list is given with `--name-blocklist`. Each line holds one word.
+//
+//go:embed name_blocklist.txt
+var defaultNameBlocklist string
+
+// defaultNameAllowlist is the list of innocent words containing blocked words (such as "Hancock")
+// which may appear in display names unless another list is given with `--name-allowlist`. Each
+// line holds one word.
+//
+//go:embed name_allowlist.txt
+var defaultNameAllowlist string
+
+// leetspeak maps characters that are commonly used in place of letters to the letters they stand
+// for.
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'6': 'g',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+}
+
+// A NameFilter decides which display names players may use.
+type NameFilter struct {
+	// blocked holds the skeletons of the words that may not appear in names.
+	blocked [][]skeletonRun
+
+	// allowed holds the skeletons of the words that may appear in names even though they contain
+	// a blocked word.
+	allowed [][]skeletonRun
+}
+
+// A skeletonRun is a run of one letter in the skeleton of some text.
+type skeletonRun struct {
+	// letter is the lower-case letter.
+	letter rune
+
+	// count is the number of times that the letter is repeated.
+	count int
+
+	// from is the byte offset in the text of the character that the run starts with.
+	from int
+
+	// lastFrom is the byte offset in the text of the character that the run ends with.
+	lastFrom int
+
+	// to is the byte offset in the text just after the run.
+	to int
+}
+
+// NameFilterFromArgs returns a name filter using the blocklist and allowlist files given by the
+// `--name-blocklist` and `--name-allowlist` command-line flags, or the built-in lists for flags
+// that are missing. The server exits if a file can't be read.
+func NameFilterFromArgs() *NameFilter {
+	return newNameFilter(
+		wordListFromArgs("--name-blocklist", defaultNameBlocklist),
+		wordListFromArgs("--name-allowlist", defaultNameAllowlist),
+	)
+}
+
+// wordListFromArgs returns the contents of the word list file given by the command-line flag with
+// the given name, or the given default list if the flag is missing. The server exits if the file
+// can't be read.
+func wordListFromArgs(flag string, defaultList string) string {
+	path := argValue(flag)
+
+	if path == nil {
+		return defaultList
+	}
+
+	data, err := os.ReadFile(*path)
+
+	if err != nil {
+		Logger.Fatal(
+			"failed to read word list",
+			zap.String("flag", flag),
+			zap.String("path", *path),
+			zap.Error(err),
+		)
+	}
+
+	return string(data)
+}
+
+// newNameFilter returns a name filter blocking the words in the given blocklist, except where they
+// are part of a word in the given allowlist. Each list holds one word on each line.
+func newNameFilter(blocklist string, allowlist string) *NameFilter {
+	filter := &NameFilter{}
+
+	for _, word := range strings.Split(blocklist, "\n") {
+		if skeleton := wordSkeleton(word); len(skeleton) > 0 {
+			filter.blocked = append(filter.blocked, skeleton)
+		}
+	}
+
+	for _, word := range strings.Split(allowlist, "\n") {
+		if skeleton := wordSkeleton(word); len(skeleton) > 0 {
+			filter.allowed = append(filter.allowed, skeleton)
+		}
+	}
+
+	return filter
+}
+
+// normaliseDisplayName returns the form of the given name that other players will see.
+// Compatibility characters (such as full-width letters) are replaced by their usual forms and
+// surrounding space is removed.
+func normaliseDisplayName(name string) string {
+	return strings.TrimSpace(width.Fold.String(norm.NFKC.String(name)))
+}
+
+// nameSkeleton reduces text to the letters that a reader would see in it, ignoring accents,
+// capitalisation, leetspeak, spaces and punctuation. Repeated letters are grouped into runs so that
+// they can be matched however many times they are repeated.
+func nameSkeleton(text string) []skeletonRun {
+	var runs []skeletonRun
+
+	for from := 0; from < len(text); {
+		c, size := utf8.DecodeRuneInString(text[from:])
+		to := from + size
+
+		// Each character is decomposed on its own so that the runs can be traced back to the
+		// text. Decomposing splits accented letters into a base letter followed by combining marks.
+		for _, r := range norm.NFKD.String(width.Fold.String(string(c))) {
+			if unicode.Is(unicode.Mn, r) {
+				// Drop accents.
+				continue
+			}
+
+			if l, ok := leetspeak[r]; ok {
+				r = l
+			}
+
+			if !unicode.IsLetter(r) {
+				// Separators are often used to sneak words past filters.
+				continue
+			}
+
+			runs = appendLetter(runs, unicode.ToLower(r), from, to)
+		}
+
+		from = to
+	}
+
+	return runs
+}
+
+// wordSkeleton returns the skeleton of a word from a blocklist or allowlist. Unlike the text being
+// checked, the word is taken as written, so its letters are only lower-cased and grouped into runs.
+func wordSkeleton(word string) []skeletonRun {
+	var runs []skeletonRun
+
+	word = strings.ToLower(strings.TrimSpace(word))
+
+	for from, r := range word {
+		runs = appendLetter(runs, r, from, from+utf8.RuneLen(r))
+	}
+
+	return runs
+}
+
+// appendLetter adds a letter, which was written between the given byte offsets, to the end of a
+// skeleton. An "h" following a "p" turns it into an "f", since the two sound the same ("phuck").
+func appendLetter(runs []skeletonRun, letter rune, from int, to int) []skeletonRun {
+	if n := len(runs); letter == 'h' && n > 0 && runs[n-1].letter == 'p' {
+		p := &runs[n-1]
+		from = p.lastFrom
+
+		if p.count--; p.count == 0 {
+			runs = runs[:n-1]
+		} else {
+			p.to = from
+		}
+
+		letter = 'f'
+	}
+
+	if n := len(runs); n > 0 && runs[n-1].letter == letter {
+		runs[n-1].count++
+		runs[n-1].lastFrom = from
+		runs[n-1].to = to
+
+		return runs
+	}
+
+	return append(runs, skeletonRun{letter: letter, count: 1, from: from, lastFrom: from, to: to})
+}
+
+// CheckDisplayName returns a description of what is wrong with the given display name, or an empty
+// string if the name is allowed. The name should already have been normalised.
+func (filter *NameFilter) CheckDisplayName(name string) string {
+	if n := utf8.RuneCountInString(name); n < minDisplayNameLength || n > maxDisplayNameLength {
+		return "wrong length"
+	}
+
+	for _, r := range name {
+		// Only allowing Latin letters stops names that look like other names but are written in
+		// another script.
+		if !unicode.In(r, unicode.Latin) && !('0' <= r && r <= '9') && r != '_' {
+			return "may only contain letters, digits and underscores"
+		}
+	}
+
+	if filter.isBlocked(name) {
+		return "not allowed"
+	}
+
+	return ""
+}
+
+// isBlocked returns true if and only if the given text contains a blocked word anywhere, other than
+// inside an allowed word (so that "Hancock" is allowed but "bigcock" is not).
+func (filter *NameFilter) isBlocked(text string) bool {
+	return len(filter.blockedSpans(text)) > 0
+}
+
+// blockedSpans returns the byte ranges of the given text in which blocked words are written, other
+// than inside allowed words. A blocked word may be spread over several words of the text (as in
+// "f u c k"), but then it must start and end with them, so that "was hit" doesn't spell "shit".
+func (filter *NameFilter) blockedSpans(text string) spanList {
+	skeleton := nameSkeleton(text)
+	allowed := filter.allowedSpans(skeleton)
+
+	var spans spanList
+
+	for start := range skeleton {
+		for _, word := range filter.blocked {
+			end := start + len(word)
+
+			if !spells(skeleton[start:], word) || allowed.covers(start, end) {
+				continue
+			}
+
+			found := span{start: skeleton[start].from, end: skeleton[end-1].to}
+
+			if found.withinWords(text) {
+				spans = append(spans, found)
+			}
+		}
+	}
+
+	return spans
+}
+
+// A span is a range of positions, from start up to but not including end.
+type span struct {
+	// start is the first position in the span.
+	start int
+
+	// end is the position after the last one in the span.
+	end int
+}
+
+// withinWords returns true if and only if the span of the given text either lies within one word
+// or starts and ends on word boundaries, where words are separated by spaces.
+func (s span) withinWords(text string) bool {
+	if !strings.ContainsFunc(text[s.start:s.end], unicode.IsSpace) {
+		return true
+	}
+
+	before, _ := utf8.DecodeLastRuneInString(text[:s.start])
+	after, _ := utf8.DecodeRuneInString(text[s.end:])
+
+	return !isWordCharacter(before) && !isWordCharacter(after)
+}
+
+// isWordCharacter returns true if and only if the given character can be part of a word.
+func isWordCharacter(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// spanList is a list of spans.
+type spanList []span
+
+// allowedSpans returns the ranges of runs in the skeleton where allowed words are spelt.
+func (filter *NameFilter) allowedSpans(skeleton []skeletonRun) spanList {
+	var spans spanList
+
+	for start := range skeleton {
+		for _, word := range filter.allowed {
+			if spells(skeleton[start:], word) {
+				spans = append(spans, span{start: start, end: start + len(word)})
+			}
+		}
+	}
+
+	return spans
+}
+
+// covers returns true if and only if one of the spans contains every position from start up to
+// but not including end.
+func (spans spanList) covers(start int, end int) bool {
+	for _, s := range spans {
+		if s.start <= start && end <= s.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// spells returns true if and only if the runs at the start of the skeleton spell the given word.
+// Each run must repeat its letter at least as many times as the word does.
+func spells(skeleton []skeletonRun, word []skeletonRun) bool {
+	if len(skeleton) < len(word) {
+		return false
+	}
+
+	for i, run := range word {
+		if skeleton[i].letter != run.letter || skeleton[i].count < run.count {
+			return false
+		}
+	}
+
+	return true
+}
+
+// choosePlayerName returns the name that the given client should have in this lobby. A requested
+// name is used if the filter allows it, and otherwise a logged-in player's display name is used.
+// Anybody else gets a generated name. If the requested name was rejected, the reason is returned
+// too.
+func (lobby *Lobby) choosePlayerName(
+	client *Client,
+	requested string,
+) (name string, rejection string) {
+	if requested != "" {
+		requested = normaliseDisplayName(requested)
+
+		if rejection = lobby.manager.names.CheckDisplayName(requested); rejection == "" {
+			return lobby.uniquePlayerName(requested), ""
+		}
+	}
+
+	if client.Account != nil {
+		return lobby.uniquePlayerName(client.Account.DisplayName), rejection
+	}
+
+	return lobby.generatePlayerName(), rejection
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// skeletonString returns a readable form of the given skeleton, in which each run is written out
+// in full.
+func skeletonString(skeleton []skeletonRun) string {
+	var b strings.Builder
+
+	for _, run := range skeleton {
+		b.WriteString(strings.Repeat(string(run.letter), run.count))
+	}
+
+	return b.String()
+}
+
+func TestNameSkeleton(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Alice", "alice"},
+		{"ÀLÏCÉ", "alice"},
+		{"Ａｌｉｃｅ", "alice"},
+		{"5h1!t", "shiit"},
+		{"s_h.i-t", "shit"},
+		{"Big Boss", "bigboss"},
+		{"Player2", "player"},
+		{"Phil", "fil"},
+		{"pphuck", "pfuck"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := skeletonString(nameSkeleton(tt.text)); got != tt.want {
+				t.Errorf("nameSkeleton(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDisplayName(t *testing.T) {
+	filter := newNameFilter(defaultNameBlocklist, defaultNameAllowlist)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Alice", ""},
+		{"Al", "wrong length"},
+		{"Alexandrina", "wrong length"},
+		{"Ålice", ""},
+		{"Алиса", "may only contain letters, digits and underscores"},
+		{"Ali ce", "may only contain letters, digits and underscores"},
+
+		// Evasions.
+		{"shit", "not allowed"},
+		{"SHIT", "not allowed"},
+		{"5h1t", "not allowed"},
+		{"shiiiiit", "not allowed"},
+		{"s_h_i_t", "not allowed"},
+		{"Big_Shit", "not allowed"},
+		{"BigShit", "not allowed"},
+		{"shits", "not allowed"},
+		{"Wanker", "not allowed"},
+		{"Pissed", "not allowed"},
+		{"Admin", "not allowed"},
+		{"TheAdmin", "not allowed"},
+		{"Admin123", "not allowed"},
+		{"Fùck", "not allowed"},
+		{"phuck", "not allowed"},
+
+		// Blocked words inside other words.
+		{"fuckface", "not allowed"},
+		{"shithead", "not allowed"},
+		{"dickhead", "not allowed"},
+		{"fuckyou", "not allowed"},
+		{"bigdick", "not allowed"},
+		{"xfuck", "not allowed"},
+		{"assfuck", "not allowed"},
+		{"xXshitXx", "not allowed"},
+		{"Big5hit", "not allowed"},
+		{"Hancocks", ""},
+		{"bigcock", "not allowed"},
+		{"scrapcunt", "not allowed"},
+
+		// Ordinary names which contain a blocked word, allowed by the allowlist or because a letter
+		// of the blocked word is repeated in it.
+		{"Pistol", ""},
+		{"Badminton", ""},
+		{"Hancock", ""},
+		{"Dickens", ""},
+		{"Parsec", ""},
+		{"Scrap", ""},
+		{"Cocktail", ""},
+		{"Arsenal", ""},
+		{"Pissarro", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.CheckDisplayName(tt.name); got != tt.want {
+				t.Errorf("CheckDisplayName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"golang.org/x/crypto/pbkdf2"
+	"strings"
+)
+
+// passwordIterations is the number of PBKDF2 iterations used for new password hashes. Hashing
+// takes long enough that it is done away from the main thread.
+const passwordIterations = 100_000
+
+// passwordSaltBytes is the number of random bytes in a password salt.
+const passwordSaltBytes = 16
+
+// passwordKeyBytes is the length of a password hash in bytes.
+const passwordKeyBytes = 32
+
+// deviceTokenBytes is the number of random bytes in a device token.
+const deviceTokenBytes = 32
+
+// A PasswordHash is a salted PBKDF2-HMAC-SHA256 hash of a password.
+type PasswordHash struct {
+	// Salt is the hex-encoded random salt.
+	Salt string `json:"salt"`
+
+	// Iterations is the number of PBKDF2 iterations used. Keeping this with the hash lets the
+	// count be raised for new passwords without breaking old ones.
+	Iterations int `json:"iterations"`
+
+	// Key is the hex-encoded derived key.
+	Key string `json:"key"`
+}
+
+// dummyPasswordHash is checked against the password given for a username that has no account, so
+// that logging in takes as long whether or not the account exists. No password matches it.
+var dummyPasswordHash = PasswordHash{
+	Salt:       strings.Repeat("00", passwordSaltBytes),
+	Iterations: passwordIterations,
+	Key:        strings.Repeat("00", passwordKeyBytes),
+}
+
+// deriveKey derives a key of the given length from the password and salt using PBKDF2 with
+// HMAC-SHA256, as described in RFC 8018.
+func deriveKey(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	return pbkdf2.Key(password, salt, iterations, keyLen, sha256.New)
+}
+
+// hashPassword returns a new salted hash of the given password.
+func hashPassword(password string) (PasswordHash, error) {
+	salt := make([]byte, passwordSaltBytes)
+
+	if _, err := rand.Read(salt); err != nil {
+		return PasswordHash{}, err
+	}
+
+	key := deriveKey([]byte(password), salt, passwordIterations, passwordKeyBytes)
+
+	return PasswordHash{
+		Salt:       hex.EncodeToString(salt),
+		Iterations: passwordIterations,
+		Key:        hex.EncodeToString(key),
+	}, nil
+}
+
+// Matches returns true if and only if the given password is the one that was hashed.
+func (h PasswordHash) Matches(password string) bool {
+	salt, saltErr := hex.DecodeString(h.Salt)
+	want, keyErr := hex.DecodeString(h.Key)
+
+	if saltErr != nil || keyErr != nil || h.Iterations < 1 {
+		return false
+	}
+
+	got := deriveKey([]byte(password), salt, h.Iterations, len(want))
+
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// newDeviceToken returns a new random device token along with the hash of it that should be
+// stored. Device tokens are long and random, so a single unsalted hash is enough to stop a leaked
+// storage file being used to log in.
+func newDeviceToken() (token string, hash string, err error) {
+	raw := make([]byte, deviceTokenBytes)
+
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token = hex.EncodeToString(raw)
+
+	return token, hashDeviceToken(token), nil
+}
+
+// hashDeviceToken returns the hash of the given device token.
+func hashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors, as published alongside RFC 7914.
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		keyLen     int
+		want       string
+	}{
+		{
+			"passwd", "salt", 1, 64,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+				"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			"Password", "NaCl", 80000, 64,
+			"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+				"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			key := deriveKey([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen)
+
+			if got := hex.EncodeToString(key); got != tt.want {
+				t.Errorf("deriveKey() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordHashMatches(t *testing.T) {
+	const password = "correct horse"
+
+	hash, err := hashPassword(password)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		hash     PasswordHash
+		password string
+		want     bool
+	}{
+		{"right password", hash, password, true},
+		{"wrong password", hash, "correct horsf", false},
+		{"empty password", hash, "", false},
+		{"bad salt", PasswordHash{Salt: "zz", Iterations: 1, Key: hash.Key}, password, false},
+		{"no iterations", PasswordHash{Salt: hash.Salt, Key: hash.Key}, password, false},
+		{"dummy hash", dummyPasswordHash, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hash.Matches(tt.password); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"slices"
+	"time"
+)
+
+// pingLifetime is how long a ping stays on teammates' screens.
+const pingLifetime = 5 * time.Second
+
+// pingWindow is the period over which pings are counted for rate limiting.
+const pingWindow = 5 * time.Second
+
+// maxPingsPerWindow is the number of pings a player may send within pingWindow.
+const maxPingsPerWindow = 3
+
+// A callout is the kind of message that a ping carries.
+type callout string
+
+const (
+	// calloutHelpHere asks teammates to come to the pinged place.
+	calloutHelpHere callout = "help_here"
+
+	// calloutGoingToFlag tells teammates that the sender is heading for the pinged place.
+	calloutGoingToFlag callout = "going_to_flag"
+
+	// calloutEnemy warns teammates about enemies at the pinged place.
+	calloutEnemy callout = "enemy"
+)
+
+// callouts contains every valid callout.
+var callouts = []callout{calloutHelpHere, calloutGoingToFlag, calloutEnemy}
+
+// A ping marks a place in the ship for a player's teammates.
+type ping struct {
+	// sender is the name of the player who sent the ping.
+	sender string
+
+	// callout is what the sender wants to say about the place.
+	callout callout
+
+	// flagID is the ID of the pinged flag, or empty if a position was pinged instead.
+	flagID string
+
+	// pos is the pinged position. If a flag was pinged, this is the flag's position.
+	pos Position
+
+	// expiresAt is the time at which the ping disappears.
+	expiresAt time.Time
+}
+
+// toMap returns the ping in the form sent to clients.
+func (p ping) toMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"their_name": p.sender,
+		"callout":    p.callout,
+		"pos":        p.pos.ToMap(),
+		"expires_in": time.Until(p.expiresAt).Seconds(),
+	}
+
+	if p.flagID != "" {
+		m["flag_id"] = p.flagID
+	}
+
+	return m
+}
+
+// doPing handles a message from a player pinging a flag or position for their teammates.
+func (ship *Ship) doPing(player *Player, message *Message) error {
+	calloutStr, err := message.GetString("callout")
+
+	if err != nil || !slices.Contains(callouts, callout(calloutStr)) {
+		return player.Client.Send(NewMessage("ship_ping_format_error"))
+	}
+
+	p := ping{
+		sender:    player.Name,
+		callout:   callout(calloutStr),
+		expiresAt: time.Now().Add(pingLifetime),
+	}
+
+	// A ping is either for a flag or for a position.
+	if message.TryGet("flag_id") != nil {
+		if p.flagID, err = message.GetString("flag_id"); err != nil {
+			return player.Client.Send(NewMessage("ship_ping_format_error"))
+		}
+
+		f, ok := ship.fm.flags[p.flagID]
+
+		if !ok {
+			return player.Client.Send(NewMessage("ship_ping_no_such_flag_error"))
+		}
+
+		p.pos = f.pos
+	} else {
+		posVal := message.TryGet("pos")
+
+		if posVal == nil {
+			return player.Client.Send(NewMessage("ship_ping_format_error"))
+		}
+
+		pos := PositionFromObj(*posVal)
+
+		if pos == nil {
+			return player.Client.Send(NewMessage("ship_ping_format_error"))
+		}
+
+		p.pos = *pos
+	}
+
+	if !ship.pingLimiter.Allow(player) {
+		return player.Client.Send(NewMessage("ship_ping_rate_limited").Add(
+			"retry_after",
+			ship.pingLimiter.RetryAfter(player).Seconds(),
+		))
+	}
+
+	msg := NewMessage("ship_teammate_ping")
+
+	for k, v := range p.toMap() {
+		_ = msg.Add(k, v)
+	}
+
+	// Only teammates in the ship can see the ping straight away.
+	sendErr := player.ForAllShipPeers(func(peer *Player) error {
+		if peer.Team != player.Team {
+			return nil
+		}
+
+		return peer.Client.Send(msg)
+	})
+
+	// Teammates in minigames will see the ping when they come back, if it hasn't expired.
+	_ = player.Team.ForAllMembers(func(teammate *Player) error {
+		if teammate != player && !teammate.InShipActivity() {
+			ship.missedPings[teammate] = append(ship.missedPings[teammate], p)
+		}
+
+		return nil
+	})
+
+	return sendErr
+}
+
+// takeMissedPings returns the unexpired pings that the given player has missed while they were
+// away from the ship, and forgets all of the player's missed pings.
+func (ship *Ship) takeMissedPings(player *Player) []map[string]interface{} {
+	now := time.Now()
+	pings := make([]map[string]interface{}, 0)
+
+	for _, p := range ship.missedPings[player] {
+		if p.expiresAt.After(now) {
+			pings = append(pings, p.toMap())
+		}
+	}
+
+	delete(ship.missedPings, player)
+
+	return pings
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"go.uber.org/zap"
+	"time"
 )
 
 // A Player is the in-core representation of a human user.
@@ -17,6 +18,17 @@ type Player struct {
 
 	// Name is the unique server-generated name for the player.
 	Name string
+
+	// joinedAt is the time at which the player joined their lobby.
+	joinedAt time.Time
+
+	// muted contains the players whose chat messages this player does not want to receive.
+	muted map[*Player]struct{}
+}
+
+// IsHost returns true if and only if the player is the host of their lobby.
+func (player *Player) IsHost() bool {
+	return player.Lobby().Host == player
 }
 
 // Lobby returns a pointer to the lobby that the player is in.
@@ -36,7 +48,8 @@ func (player *Player) InShipActivity() bool {
 	return ok
 }
 
-// SwitchTeam removes the player from its current team and adds it to the given team.
+// SwitchTeam removes the player from its current team and adds it to the given team. Since the
+// teams are no longer the ones that the series was played between, the series is reset.
 // It panics if the player is not in the lobby activity.
 func (player *Player) SwitchTeam(team *Team) {
 	if !player.InLobbyActivity() {
@@ -52,6 +65,8 @@ func (player *Player) SwitchTeam(team *Team) {
 
 	// Add to the new team.
 	team.AddPlayer(player)
+
+	player.Lobby().resetSeries()
 }
 
 // ForAllLobbyPeers calls fn for every other player in the lobby.
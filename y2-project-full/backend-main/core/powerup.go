@@ -0,0 +1,289 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// shipMoveSpeed is the speed at which players walk around the ship, in units per second. This must
+// match the frontend.
+const shipMoveSpeed = 100.0
+
+// speedBoostMultiplier is the factor by which a speed boost multiplies a player's speed.
+const speedBoostMultiplier = 1.5
+
+// speedBoostDuration is the length of time for which a speed boost lasts.
+const speedBoostDuration = 10 * time.Second
+
+// maxPowerUps is the number of power-ups that a player can hold at once. Players who win a minigame
+// while holding this many are not given another.
+const maxPowerUps = 3
+
+// A powerUp is an item that a player can win and later use in the ship.
+type powerUp string
+
+const (
+	// powerUpSpeedBoost makes the player move faster for a while.
+	powerUpSpeedBoost powerUp = "speed_boost"
+
+	// powerUpCooldownReset ends the cooldown of a flag straight away.
+	powerUpCooldownReset powerUp = "cooldown_reset"
+
+	// powerUpShield stops the next capture of a flag owned by the player's team.
+	powerUpShield powerUp = "shield"
+
+	// powerUpDoubleWorth doubles the worth of a flag owned by the player's team until it is lost.
+	powerUpDoubleWorth powerUp = "double_worth"
+)
+
+// powerUps contains every kind of power-up.
+var powerUps = []powerUp{
+	powerUpSpeedBoost,
+	powerUpCooldownReset,
+	powerUpShield,
+	powerUpDoubleWorth,
+}
+
+// needsFlag returns true if and only if the power-up is used on a flag.
+func (pu powerUp) needsFlag() bool {
+	return pu != powerUpSpeedBoost
+}
+
+// worth returns the number of tokens that the flag is currently worth to the team that owns it.
+func (f *flag) worth() int {
+	bonuses := f.handicapBonus + f.eventBonus
+
+	if f.doubled {
+		return 2*f.minigameProto.Worth + bonuses
+	}
+
+	return f.minigameProto.Worth + bonuses
+}
+
+// isSpeedBoosted returns true if and only if the given player has a speed boost running.
+func (ship *Ship) isSpeedBoosted(player *Player) bool {
+	timer, ok := ship.speedBoosts[player]
+	return ok && !timer.HasEnded()
+}
+
+// moveSpeed returns the fastest that the given player can currently move around the ship.
+func (ship *Ship) moveSpeed(player *Player) float64 {
+	if ship.isSpeedBoosted(player) {
+		return shipMoveSpeed * speedBoostMultiplier
+	}
+
+	return shipMoveSpeed
+}
+
+// inventory returns the power-ups held by the given player.
+func (ship *Ship) inventory(player *Player) []powerUp {
+	if held, ok := ship.inventories[player]; ok {
+		return held
+	}
+
+	return make([]powerUp, 0)
+}
+
+// grantPowerUp gives the player a random power-up if they have room for it. It returns the
+// power-up, or an empty string if the player's inventory is full.
+func (ship *Ship) grantPowerUp(player *Player) powerUp {
+	if len(ship.inventories[player]) >= maxPowerUps {
+		return ""
+	}
+
+	pu := powerUps[rand.Intn(len(powerUps))]
+	ship.inventories[player] = append(ship.inventories[player], pu)
+
+	return pu
+}
+
+// notifyPowerUpGranted tells a player that they have been given a power-up.
+func (ship *Ship) notifyPowerUpGranted(player *Player, pu powerUp) error {
+	msg := NewMessage("ship_powerup_granted")
+	_ = msg.Add("powerup", pu)
+	_ = msg.Add("inventory", ship.inventory(player))
+
+	return player.Client.Send(msg)
+}
+
+// notifyPowerUpExpired tells everybody in the ship that a player's speed boost has run out.
+func (ship *Ship) notifyPowerUpExpired(player *Player, pu powerUp) error {
+	msg := NewMessage("ship_powerup_expired")
+	_ = msg.Add("their_name", player.Name)
+	_ = msg.Add("powerup", pu)
+
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// powerUpTargetError sends the player an error explaining why the given flag can't be the target
+// of their power-up.
+func powerUpTargetError(player *Player, reason string) error {
+	return player.Client.Send(NewMessage("ship_powerup_bad_target_error").Add("reason", reason))
+}
+
+// applyPowerUp puts the effect of the power-up into place. If the target is not suitable, an error
+// message is sent to the player and false is returned.
+func (ship *Ship) applyPowerUp(player *Player, pu powerUp, target *flag) (bool, error) {
+	switch pu {
+	case powerUpSpeedBoost:
+		if ship.isSpeedBoosted(player) {
+			return false, powerUpTargetError(player, "already_active")
+		}
+
+		ship.speedBoosts[player] = SingleTimer(
+			ship.Scheduler,
+			time.Now().Add(speedBoostDuration),
+			func() error {
+				return ship.notifyPowerUpExpired(player, pu)
+			},
+		)
+
+	case powerUpCooldownReset:
+		if target.cooldown.HasEnded() {
+			return false, powerUpTargetError(player, "not_cooling_down")
+		}
+
+		target.cooldown.StopWith(func() error {
+			return ship.notifyCooldownEnd(target)
+		})
+
+		// Stopping the timer doesn't change our copy of it, so replace it to make the flag usable
+		// straight away.
+		target.cooldown = ExpiredTimer()
+
+	case powerUpShield:
+		if target.owner != player.Team {
+			return false, powerUpTargetError(player, "not_owned")
+		}
+
+		if target.shielded {
+			return false, powerUpTargetError(player, "already_active")
+		}
+
+		target.shielded = true
+
+	case powerUpDoubleWorth:
+		if target.owner != player.Team {
+			return false, powerUpTargetError(player, "not_owned")
+		}
+
+		if target.doubled {
+			return false, powerUpTargetError(player, "already_active")
+		}
+
+		target.doubled = true
+	}
+
+	return true, nil
+}
+
+// doPowerUpUse handles a message from a player using one of their power-ups.
+func (ship *Ship) doPowerUpUse(player *Player, message *Message) error {
+	if ship.isEndgame {
+		return player.Client.Send(NewMessage("ship_powerup_endgame_error"))
+	}
+
+	puStr, err := message.GetString("powerup")
+
+	if err != nil || !slices.Contains(powerUps, powerUp(puStr)) {
+		return player.Client.Send(NewMessage("ship_powerup_format_error"))
+	}
+
+	pu := powerUp(puStr)
+	index := slices.Index(ship.inventories[player], pu)
+
+	if index == -1 {
+		return player.Client.Send(NewMessage("ship_powerup_not_held_error").Add("powerup", pu))
+	}
+
+	var target *flag
+	var targetID string
+
+	if pu.needsFlag() {
+		if targetID, err = message.GetString("flag_id"); err != nil {
+			return player.Client.Send(NewMessage("ship_powerup_format_error"))
+		}
+
+		var ok bool
+
+		if target, ok = ship.fm.flags[targetID]; !ok {
+			return powerUpTargetError(player, "no_such_flag")
+		}
+	}
+
+	if applied, err := ship.applyPowerUp(player, pu, target); !applied {
+		return err
+	}
+
+	ship.logger().Info(
+		"power-up used",
+		zap.String("player", player.Name),
+		zap.String("powerup", puStr),
+		zap.String("flag", targetID),
+	)
+
+	ship.inventories[player] = slices.Delete(ship.inventories[player], index, index+1)
+
+	msg := NewMessage("ship_powerup_used")
+	_ = msg.Add("their_name", player.Name)
+	_ = msg.Add("their_team", player.Team.Index())
+	_ = msg.Add("powerup", pu)
+
+	if target != nil {
+		_ = msg.Add("flag_id", targetID)
+	}
+
+	if pu == powerUpSpeedBoost {
+		_ = msg.Add("seconds", speedBoostDuration.Seconds())
+	}
+
+	inventoryMsg := NewMessage("ship_powerup_inventory").Add("inventory", ship.inventory(player))
+
+	return errors.Join(
+		player.Client.Send(inventoryMsg),
+		ship.ForAllShipPlayers(func(p *Player) error {
+			return p.Client.Send(msg)
+		}),
+	)
+}
+
+// captureFlag gives the flag to the team that has just won its minigame, unless the flag is
+// shielded. A shield stops one capture and is then used up.
+func (ship *Ship) captureFlag(f *flag, winner *Team) error {
+	if f.owner == winner {
+		// Defended; the flag stays with its owner.
+		return ship.defendFlag(f)
+	}
+
+	if f.shielded && f.owner != nil {
+		f.shielded = false
+
+		msg := NewMessage("ship_flag_shield_broken")
+		_ = msg.Add("flag_id", ship.fm.idForFlag(f))
+		_ = msg.Add("attacking_team", winner.Index())
+
+		return ship.ForAllShipPlayers(func(p *Player) error {
+			return p.Client.Send(msg)
+		})
+	}
+
+	f.owner = winner
+	ship.teamStats[winner.Index()].flagsCaptured++
+
+	// Bonuses belong to the team that applied them.
+	f.shielded = false
+	f.doubled = false
+	f.handicapBonus = ship.handicapBonus(winner, f.minigameProto.Worth)
+	f.eventBonus = ship.eventBonusFor(f.minigameProto.Worth)
+
+	// A new owner has to defend the flag to keep it.
+	f.decaysAt = time.Time{}
+	ship.startDecay(f)
+
+	return nil
+}
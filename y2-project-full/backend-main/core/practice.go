@@ -0,0 +1,217 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"slices"
+)
+
+// practiceLobbyID is the ID given to the lobbies that hold the teams for practice sessions. These
+// lobbies are never added to the lobby manager, so the ID can't be used to join one.
+const practiceLobbyID = "practice"
+
+// A practiceSession hosts a minigame that a player is playing for practice, outside of any game.
+// Bots take the other places in the minigame. The result is shown to the player, but it is never
+// recorded, rated or counted towards achievements.
+type practiceSession struct {
+	// lobby holds the session's teams.
+	lobby *Lobby
+
+	// player is the player who is practising.
+	player *Player
+
+	// sched is the scheduler which the minigame uses to trigger events.
+	sched Scheduler
+
+	// minigame is the minigame being practised.
+	minigame *MinigameContext
+}
+
+// logger returns a logger with information about the session.
+func (ps *practiceSession) logger() *zap.Logger {
+	return Logger.With(
+		zap.String("practice", ps.player.Name),
+		zap.String("minigame", ps.minigame.proto.Name),
+	)
+}
+
+// scheduler returns the scheduler which the practice minigame uses to trigger events.
+func (ps *practiceSession) scheduler() Scheduler {
+	return ps.sched
+}
+
+// forAllPlayers calls fn for the player who is practising and every bot in the session.
+func (ps *practiceSession) forAllPlayers(fn func(*Player) error) error {
+	return ps.lobby.ForAllPlayers(fn)
+}
+
+// progress does nothing, since achievements can't be earned in practice.
+func (ps *practiceSession) progress(*Player, achievementEvent) error {
+	return nil
+}
+
+// endMinigame shows the player the result of the practice minigame and sends them back to the
+// main menu.
+func (ps *practiceSession) endMinigame(ctx *MinigameContext, result MinigameResult) error {
+	ps.logger().Info("practice minigame ended", zap.Any("result", result))
+
+	client := ps.player.Client
+
+	var sendErr error
+
+	if result.disconnected != ps.player {
+		msg := NewMessage("practice_finished")
+		_ = msg.Add("minigame", ctx.proto.Name)
+		_ = msg.Add("your_team", ps.player.Team.Index())
+
+		if winner := result.Winner(); winner != nil {
+			_ = msg.Add("winning_team", winner.Index())
+		}
+
+		_ = msg.Add("ranking", result.rankingIndices())
+		_ = msg.Add("score", result.Score(ps.player))
+		_ = msg.Add("duration", ctx.playTime().Seconds())
+
+		sendErr = client.Send(msg)
+	}
+
+	ctx.invalidate()
+
+	// The session's lobby is thrown away along with the bots, so the player only needs to be
+	// detached from the client.
+	ps.player.Activity = nil
+	client.Player = nil
+
+	return sendErr
+}
+
+// practiceTeamCount returns the number of teams that should play the given minigame in practice
+// when the given number is requested, or zero if the minigame can't be played by that many teams.
+// When zero teams are requested, the smallest number that the minigame supports is used.
+func practiceTeamCount(proto *MinigamePrototype, requested int) int {
+	if proto.PlayerCount == 1 {
+		return 1
+	}
+
+	if requested == 0 {
+		requested = 2
+
+		if proto.TeamCounts != nil {
+			requested = slices.Min(proto.TeamCounts)
+		}
+	}
+
+	if proto.teamsPlaying(requested) != requested {
+		return 0
+	}
+
+	return requested
+}
+
+// startPractice starts a practice session for the given client, in which it plays the minigame of
+// the given prototype between the given number of teams. The client's player is put on the first
+// team and bots fill every other place.
+func (mgr *LobbyManager) startPractice(client *Client, proto MinigamePrototype, teams int) error {
+	lobby := &Lobby{
+		manager: mgr,
+
+		ID: practiceLobbyID,
+
+		Settings: defaultLobbySettings(),
+
+		kickedProfiles: make(map[string]struct{}),
+		kickedAddrs:    make(map[string]struct{}),
+
+		practice: true,
+	}
+
+	lobby.Settings.TeamCount = teams
+	lobby.Settings.TeamSize = max(proto.TeamSize(), 1)
+	lobby.setTeamCount(teams)
+
+	ps := &practiceSession{
+		lobby: lobby,
+		sched: mgr.scheduler,
+	}
+
+	var store ScoreStore
+
+	if proto.StoreCtor != nil {
+		// Practice never touches the stores kept by flags.
+		store = proto.StoreCtor()
+	}
+
+	ps.minigame = proto.Constructor(&proto, store, ps)
+
+	// The rejection can be ignored because no name was requested.
+	_ = lobby.AddClient(client, "")
+	ps.player = client.Player
+	ps.player.Activity = ps.minigame
+
+	for _, team := range lobby.Teams {
+		for len(team.Players) < lobby.Settings.TeamSize {
+			team.AddPlayer(newBot(lobby, initialRating, ps.minigame))
+		}
+	}
+
+	ps.minigame.teams = slices.Clone(lobby.Teams)
+	ps.minigame.playerCount = lobby.PlayerCount()
+
+	ps.logger().Info("starting practice", zap.Int("teams", teams))
+
+	peers := make([]string, 0, ps.minigame.playerCount-1)
+
+	_ = ps.player.ForAllLobbyPeers(func(p *Player) error {
+		peers = append(peers, p.Name)
+		return nil
+	})
+
+	msg := NewMessage("practice_started")
+	_ = msg.Add("minigame", proto.Name)
+	_ = msg.Add("your_name", ps.player.Name)
+	_ = msg.Add("your_team", ps.player.Team.Index())
+	_ = msg.Add("peers", peers)
+
+	sendErr := client.Send(msg)
+
+	return errors.Join(sendErr, ps.minigame.Start())
+}
+
+// doPracticeStart handles a message from a client asking to practise a minigame.
+func (c *Client) doPracticeStart(message *Message) error {
+	if c.Player != nil {
+		return c.Send(NewMessage("client_already_in_lobby_error"))
+	}
+
+	name, err := message.GetString("minigame")
+
+	if err != nil {
+		return c.Send(NewMessage("practice_start_format_error"))
+	}
+
+	requested := 0
+
+	if message.TryGet("team_count") != nil {
+		if requested, err = message.GetInt("team_count"); err != nil || requested < 1 {
+			return c.Send(NewMessage("practice_start_format_error"))
+		}
+	}
+
+	proto, ok := c.lobbyMgr.enabledMinigames()[name]
+
+	if !ok {
+		return c.Send(NewMessage("practice_no_such_minigame_error").Add("minigame", name))
+	}
+
+	teams := practiceTeamCount(&proto, requested)
+
+	if teams == 0 {
+		msg := NewMessage("practice_team_count_error")
+		_ = msg.Add("minigame", name)
+		_ = msg.Add("team_count", requested)
+
+		return c.Send(msg)
+	}
+
+	return c.lobbyMgr.startPractice(c, proto, teams)
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+)
+
+// Rating returns the player's skill rating.
+func (player *Player) Rating() float64 {
+	return player.Client.Profile.Rating
+}
+
+// notifyProfile tells the client which profile it is using.
+func (c *Client) notifyProfile(created bool) error {
+	msg := NewMessage("profile_welcome")
+	_ = msg.Add("profile_id", c.Profile.ID)
+	_ = msg.Add("rating", c.Profile.Rating)
+	_ = msg.Add("games_played", c.Profile.GamesPlayed)
+	_ = msg.Add("xp", c.Profile.XP)
+	_ = msg.Add("level", c.Profile.Level())
+	_ = msg.Add("achievements", c.Profile.achievementList(c.lobbyMgr.achievements))
+	_ = msg.Add("created", created)
+
+	return c.Send(msg)
+}
+
+// useProfile makes the given profile the client's profile.
+func (mgr *LobbyManager) useProfile(client *Client, profile *Profile) {
+	if client.Profile != nil {
+		delete(mgr.profileClients, client.Profile.ID)
+	}
+
+	client.Profile = profile
+	mgr.profileClients[profile.ID] = client
+}
+
+// createProfile gives the client a new profile and tells the client about it. The profile isn't
+// stored until it is worth keeping.
+func (mgr *LobbyManager) createProfile(client *Client) error {
+	profile, err := newProfile()
+
+	if err != nil {
+		return err
+	}
+
+	mgr.useProfile(client, profile)
+
+	return client.notifyProfile(true)
+}
+
+// ensureProfile gives the client a new profile if it has not identified itself with an existing
+// one.
+func (mgr *LobbyManager) ensureProfile(client *Client) error {
+	if client.Profile != nil {
+		return nil
+	}
+
+	return mgr.createProfile(client)
+}
+
+// saveProfiles stores the profiles of the given players. Bots' profiles are not stored, and nor are
+// guests' profiles that aren't worth keeping yet.
+func (mgr *LobbyManager) saveProfiles(players []*Player) error {
+	errs := make([]error, 0)
+
+	for _, p := range players {
+		if p.IsBot() || !p.Client.Profile.worthKeeping() {
+			continue
+		}
+
+		errs = append(errs, mgr.storage.SaveProfile(p.Client.Profile))
+	}
+
+	return errors.Join(errs...)
+}
+
+// doProfileIdentify handles a message from a client asking to use an existing profile. The profile
+// must be chosen before the client joins a lobby, since ratings decide how teams are balanced.
+func (c *Client) doProfileIdentify(message *Message) error {
+	if c.Player != nil {
+		return c.Send(NewMessage("profile_identify_in_lobby_error"))
+	}
+
+	if c.Account != nil || c.authPending {
+		// Logged-in clients use their account's profile.
+		return c.Send(NewMessage("profile_identify_logged_in_error"))
+	}
+
+	// Without an ID, the client is asking for a new profile.
+	if message.TryGet("profile_id") == nil {
+		return c.lobbyMgr.createProfile(c)
+	}
+
+	id, err := message.GetString("profile_id")
+
+	if err != nil {
+		return c.Send(NewMessage("profile_identify_format_error"))
+	}
+
+	if other, ok := c.lobbyMgr.profileClients[id]; ok && other != c {
+		// Two connections sharing a profile would overwrite each other's rating changes.
+		return c.Send(NewMessage("profile_in_use_error"))
+	}
+
+	profile, err := c.lobbyMgr.storage.LoadProfile(id)
+
+	if err != nil {
+		Logger.Error("failed to load profile", zap.Error(err))
+
+		return c.Send(NewMessage("profile_unavailable_error"))
+	}
+
+	if profile == nil {
+		// The profile may have been lost along with a server that only kept profiles in memory.
+		// Start again with a new one.
+		return c.lobbyMgr.createProfile(c)
+	}
+
+	if profile.Account != "" {
+		// Knowing the ID isn't enough to play as somebody with an account.
+		return c.Send(NewMessage("profile_requires_login_error"))
+	}
+
+	c.lobbyMgr.useProfile(c, profile)
+
+	return c.notifyProfile(false)
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"time"
+)
+
+// A rateLimiter counts events per key over a sliding window and reports when a key has had too
+// many of them.
+type rateLimiter[K comparable] struct {
+	// window is the length of time over which events are counted.
+	window time.Duration
+
+	// max is the number of events a key may have within the window.
+	max int
+
+	// events maps each key to the times of its events within the window, oldest first.
+	events map[K][]time.Time
+}
+
+// newRateLimiter returns a pointer to a rate limiter which allows max events per key in any
+// period of the given length.
+func newRateLimiter[K comparable](max int, window time.Duration) *rateLimiter[K] {
+	return &rateLimiter[K]{
+		window: window,
+		max:    max,
+		events: make(map[K][]time.Time),
+	}
+}
+
+// prune removes events that have fallen out of the window for the given key, deleting the key
+// entirely if it has no events left.
+func (rl *rateLimiter[K]) prune(key K, now time.Time) {
+	times := rl.events[key]
+
+	// Find the first event that is still inside the window.
+	i := 0
+
+	for i < len(times) && now.Sub(times[i]) >= rl.window {
+		i++
+	}
+
+	if i == len(times) {
+		delete(rl.events, key)
+		return
+	}
+
+	rl.events[key] = times[i:]
+}
+
+// RetryAfter returns how long the given key must wait before another event would be allowed, or
+// zero if an event is allowed now.
+func (rl *rateLimiter[K]) RetryAfter(key K) time.Duration {
+	now := time.Now()
+
+	rl.prune(key, now)
+
+	times := rl.events[key]
+
+	if len(times) < rl.max {
+		return 0
+	}
+
+	// The key becomes usable again once the oldest event that takes it over the limit expires.
+	return times[len(times)-rl.max].Add(rl.window).Sub(now)
+}
+
+// Record notes an event for the given key.
+func (rl *rateLimiter[K]) Record(key K) {
+	now := time.Now()
+
+	rl.prune(key, now)
+
+	rl.events[key] = append(rl.events[key], now)
+}
+
+// Allow records an event for the given key and returns true if the key is within its limit.
+// If the key is over its limit, no event is recorded and false is returned.
+func (rl *rateLimiter[K]) Allow(key K) bool {
+	if rl.RetryAfter(key) > 0 {
+		return false
+	}
+
+	rl.Record(key)
+
+	return true
+}
+
+// Forget removes all events for the given key.
+func (rl *rateLimiter[K]) Forget(key K) {
+	delete(rl.events, key)
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+
+		// events is the number of events recorded for the key before checking.
+		events int
+
+		wantAllowed bool
+	}{
+		{"no events", 3, 0, true},
+		{"under the limit", 3, 2, true},
+		{"at the limit", 3, 3, false},
+		{"over the limit", 3, 5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newRateLimiter[string](tt.max, time.Minute)
+
+			for i := 0; i < tt.events; i++ {
+				rl.Record("a")
+			}
+
+			wait := rl.RetryAfter("a")
+
+			if allowed := wait == 0; allowed != tt.wantAllowed {
+				t.Errorf("RetryAfter() = %v, want allowed = %v", wait, tt.wantAllowed)
+			}
+
+			if wait < 0 || wait > time.Minute {
+				t.Errorf("RetryAfter() = %v, want at most the window", wait)
+			}
+
+			if rl.RetryAfter("b") != 0 {
+				t.Error("another key was limited")
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	rl := newRateLimiter[int](2, time.Minute)
+
+	for i, want := range []bool{true, true, false, false} {
+		if got := rl.Allow(1); got != want {
+			t.Errorf("call %d: Allow() = %v, want %v", i, got, want)
+		}
+	}
+
+	// Refused events aren't recorded, so the key only has the two allowed ones.
+	if n := len(rl.events[1]); n != 2 {
+		t.Errorf("%d events recorded, want 2", n)
+	}
+
+	rl.Forget(1)
+
+	if !rl.Allow(1) {
+		t.Error("Allow() = false after Forget, want true")
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	window := 50 * time.Millisecond
+	rl := newRateLimiter[string](1, window)
+
+	rl.Record("a")
+
+	if rl.Allow("a") {
+		t.Fatal("Allow() = true straight after an event, want false")
+	}
+
+	time.Sleep(window)
+
+	if !rl.Allow("a") {
+		t.Error("Allow() = false after the window passed, want true")
+	}
+
+	time.Sleep(window)
+	rl.prune("a", time.Now())
+
+	if _, ok := rl.events["a"]; ok {
+		t.Error("key was kept after all of its events expired")
+	}
+}
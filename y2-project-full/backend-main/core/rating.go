@@ -0,0 +1,378 @@
+package core
+
+import (
+	"cmp"
+	"go.uber.org/zap"
+	"math"
+	"slices"
+)
+
+// initialRating is the rating given to new profiles.
+const initialRating = 1500.0
+
+// ratingScale is the rating difference at which the higher-rated side is expected to win ten times
+// as often as the lower-rated side.
+const ratingScale = 400.0
+
+// gameRatingK is the most that a player's rating can move after a ship game against one other
+// team.
+const gameRatingK = 32.0
+
+// minigameRatingK is the most that a player's rating can move after a minigame against one other
+// team. Minigames are short, so each one counts for much less than a whole game.
+const minigameRatingK = 8.0
+
+// maxBalanceSwaps limits the number of improving swaps tried when balancing teams by rating, so
+// that balancing always finishes quickly.
+const maxBalanceSwaps = 100
+
+// expectedScore returns the score that a side with the given rating is expected to get against a
+// side with the opponent's rating, where a win scores 1, a draw 0.5 and a loss 0.
+func expectedScore(rating float64, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/ratingScale))
+}
+
+// averageRating returns the mean rating of the given players, or the initial rating if there are
+// none.
+func averageRating(players []*Player) float64 {
+	if len(players) == 0 {
+		return initialRating
+	}
+
+	total := 0.0
+
+	for _, p := range players {
+		total += p.Rating()
+	}
+
+	return total / float64(len(players))
+}
+
+// rateTeams updates the ratings of the given players after a contest between their teams. Each
+// team plays every other team using its average rating, and a team beats another if its place is
+// lower. Teams with equal places draw. Every member of a team has their rating moved by the same
+// amount, which is at most k for each opposing team.
+//
+// The change for each player is returned.
+func rateTeams(members map[*Team][]*Player, place func(*Team) int, k float64) map[*Player]float64 {
+	changes := make(map[*Player]float64)
+
+	if len(members) < 2 {
+		// There is nobody to compare against.
+		return changes
+	}
+
+	ratings := make(map[*Team]float64)
+
+	for team, players := range members {
+		ratings[team] = averageRating(players)
+	}
+
+	// Spread the change over all opponents so that bigger lobbies don't swing ratings further.
+	perOpponent := k / float64(len(members)-1)
+
+	for team, players := range members {
+		delta := 0.0
+
+		for other := range members {
+			if other == team {
+				continue
+			}
+
+			actual := 0.5
+
+			switch cmp.Compare(place(team), place(other)) {
+			case -1:
+				actual = 1
+			case 1:
+				actual = 0
+			}
+
+			delta += perOpponent * (actual - expectedScore(ratings[team], ratings[other]))
+		}
+
+		for _, p := range players {
+			changes[p] = delta
+		}
+	}
+
+	// Only apply the changes once every team's average has been used.
+	for p, delta := range changes {
+		p.Client.Profile.Rating += delta
+	}
+
+	return changes
+}
+
+// rankingPlace returns a function giving the place of a team in the given ranking. Teams left out
+// of the ranking share last place.
+func rankingPlace(ranking []*Team) func(*Team) int {
+	return func(team *Team) int {
+		if i := slices.Index(ranking, team); i != -1 {
+			return i
+		}
+
+		return len(ranking)
+	}
+}
+
+// scorePlace returns a function giving the place of a team when teams are ordered by the given
+// scores, highest first. Teams with equal scores share a place.
+func scorePlace(teams []*Team, score func(*Team) float64) func(*Team) int {
+	return func(team *Team) int {
+		place := 0
+
+		for _, other := range teams {
+			if score(other) > score(team) {
+				place++
+			}
+		}
+
+		return place
+	}
+}
+
+// ratingBalancedTeams splits the given players into the given number of teams such that the
+// average ratings of the teams are as close as possible. Team sizes differ by at most one.
+//
+// Finding the best split is expensive, so players are first dealt out in a snake draft from the
+// highest rating down, and then pairs of players on different teams are swapped while doing so
+// narrows the gap between the strongest and weakest teams.
+func ratingBalancedTeams(players []*Player, teamCount int) [][]*Player {
+	sorted := slices.Clone(players)
+
+	slices.SortStableFunc(sorted, func(a, b *Player) int {
+		return cmp.Compare(b.Rating(), a.Rating())
+	})
+
+	teams := make([][]*Player, teamCount)
+
+	for i, p := range sorted {
+		round, pick := i/teamCount, i%teamCount
+
+		// Every other round picks in reverse order so that the first team doesn't always get the
+		// better player.
+		if round%2 == 1 {
+			pick = teamCount - 1 - pick
+		}
+
+		teams[pick] = append(teams[pick], p)
+	}
+
+	// spread returns the difference between the highest and lowest average team ratings.
+	spread := func() float64 {
+		lowest, highest := math.Inf(1), math.Inf(-1)
+
+		for _, team := range teams {
+			avg := averageRating(team)
+			lowest, highest = min(lowest, avg), max(highest, avg)
+		}
+
+		return highest - lowest
+	}
+
+	best := spread()
+
+	for swaps := 0; swaps < maxBalanceSwaps; swaps++ {
+		improved := false
+
+		for a := 0; a < teamCount && !improved; a++ {
+			for b := a + 1; b < teamCount && !improved; b++ {
+				for i := range teams[a] {
+					for j := range teams[b] {
+						teams[a][i], teams[b][j] = teams[b][j], teams[a][i]
+
+						if s := spread(); s < best {
+							best = s
+							improved = true
+
+							break
+						}
+
+						// No better; swap back.
+						teams[a][i], teams[b][j] = teams[b][j], teams[a][i]
+					}
+
+					if improved {
+						break
+					}
+				}
+			}
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	return teams
+}
+
+// balanceTeamsByRating moves players between teams so that the teams' average ratings are as close
+// as possible. It returns the players that were moved.
+//
+// This must only be called while every player is in the lobby activity.
+func (lobby *Lobby) balanceTeamsByRating() []*Player {
+	players := make([]*Player, 0, lobby.PlayerCount())
+
+	_ = lobby.ForAllPlayers(func(p *Player) error {
+		players = append(players, p)
+		return nil
+	})
+
+	var moved []*Player
+
+	for i, members := range ratingBalancedTeams(players, len(lobby.Teams)) {
+		for _, p := range members {
+			if p.Team == lobby.Teams[i] {
+				continue
+			}
+
+			p.SwitchTeam(lobby.Teams[i])
+
+			moved = append(moved, p)
+		}
+	}
+
+	return moved
+}
+
+// TeamRatings returns the average rating of each team, in team index order.
+func (lobby *Lobby) TeamRatings() []float64 {
+	ratings := make([]float64, 0, len(lobby.Teams))
+
+	for _, team := range lobby.Teams {
+		ratings = append(ratings, averageRating(team.randomisedMembers()))
+	}
+
+	return ratings
+}
+
+// addRatingChanges adds the given rating changes to the ship's running totals and stores the new
+// ratings.
+func (ship *Ship) addRatingChanges(changes map[*Player]float64) error {
+	players := make([]*Player, 0, len(changes))
+
+	for p, delta := range changes {
+		ship.ratingChanges[p] += delta
+
+		players = append(players, p)
+	}
+
+	return ship.lobby.manager.saveProfiles(players)
+}
+
+// rateMinigame updates the ratings of the players who took part in a minigame with the given
+// result. Single-player minigames have no opponent and so don't affect ratings.
+func (ship *Ship) rateMinigame(ctx *MinigameContext, result MinigameResult) error {
+	if len(ctx.Teams()) < 2 || (result.disconnected != nil && result.Winner() == nil) {
+		// Either there was nobody to play against, or nobody can be said to have done better.
+		return nil
+	}
+
+	members := make(map[*Team][]*Player)
+
+	_ = ctx.ForAllPlayers(func(p *Player) error {
+		members[p.Team] = append(members[p.Team], p)
+		return nil
+	})
+
+	return ship.addRatingChanges(rateTeams(members, rankingPlace(result.ranking), minigameRatingK))
+}
+
+// rateGame updates the ratings of every player still in the lobby from the final team scores and
+// the given winner, and counts the game on their profiles.
+func (ship *Ship) rateGame(winner *Team) error {
+	members := make(map[*Team][]*Player)
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		if p.InLobbyActivity() {
+			// The player joined during the endgame, so they didn't play.
+			return nil
+		}
+
+		members[p.Team] = append(members[p.Team], p)
+		p.Client.Profile.GamesPlayed++
+
+		return nil
+	})
+
+	changes := rateTeams(members, ship.finalPlace(winner), gameRatingK)
+
+	for p, delta := range changes {
+		ship.ratingChanges[p] += delta
+	}
+
+	// Everybody's games played count has changed, even if their rating hasn't.
+	players := make([]*Player, 0, ship.lobby.PlayerCount())
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		players = append(players, p)
+		return nil
+	})
+
+	return ship.lobby.manager.saveProfiles(players)
+}
+
+// rateLeaver updates the rating of a player who is leaving the ship before the game has ended, as
+// if their team had come last against every other team still playing, and counts the game on their
+// profile. Without this, leaving a game that was being lost would avoid the loss. The rest of the
+// player's team is still rated normally when the game ends.
+func (ship *Ship) rateLeaver(leaver *Player) error {
+	if leaver.IsBot() {
+		return nil
+	}
+
+	members := make(map[*Team][]*Player)
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		// Bots aren't rated at the end of the game, so they don't count here either.
+		if p.InLobbyActivity() || p.IsBot() {
+			return nil
+		}
+
+		members[p.Team] = append(members[p.Team], p)
+		return nil
+	})
+
+	leaver.Client.Profile.GamesPlayed++
+
+	if len(members) >= 2 {
+		rating := averageRating(members[leaver.Team])
+		perOpponent := gameRatingK / float64(len(members)-1)
+		delta := 0.0
+
+		for team, players := range members {
+			if team != leaver.Team {
+				delta -= perOpponent * expectedScore(rating, averageRating(players))
+			}
+		}
+
+		leaver.Client.Profile.Rating += delta
+
+		ship.logger().Info(
+			"rated leaving player",
+			zap.String("name", leaver.Name),
+			zap.Float64("change", delta),
+		)
+	}
+
+	return ship.lobby.manager.saveProfiles([]*Player{leaver})
+}
+
+// namedRatingChanges returns a map which maps players' names to their new rating and the total
+// change in their rating over the game.
+func (ship *Ship) namedRatingChanges() map[string]map[string]float64 {
+	m := make(map[string]map[string]float64)
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		m[p.Name] = map[string]float64{
+			"rating": p.Rating(),
+			"change": ship.ratingChanges[p],
+		}
+
+		return nil
+	})
+
+	return m
+}
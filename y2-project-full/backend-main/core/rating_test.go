@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+// newRatedPlayer returns a player whose profile has the given rating.
+func newRatedPlayer(rating float64) *Player {
+	return &Player{Client: &Client{Profile: &Profile{Rating: rating}}}
+}
+
+func TestRatingBalancedTeams(t *testing.T) {
+	tests := []struct {
+		name      string
+		ratings   []float64
+		teamCount int
+
+		// maxSpread is the largest allowed gap between the highest and lowest average ratings.
+		maxSpread float64
+	}{
+		{"pairs", []float64{1000, 2000, 1000, 2000}, 2, 0},
+		{"snake draft needs a swap", []float64{1800, 1600, 1500, 1400, 1300, 1200}, 2, 0},
+		{"three teams", []float64{2100, 1900, 1700, 1500, 1300, 1100}, 3, 0},
+		{"equal ratings", []float64{1500, 1500, 1500, 1500, 1500, 1500}, 3, 0},
+		{"uneven teams", []float64{1700, 1600, 1500, 1400, 1300}, 2, 100},
+		{"one player each", []float64{1000, 2000}, 2, 1000},
+		{"fewer players than teams", []float64{1500}, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			players := make([]*Player, 0, len(tt.ratings))
+
+			for _, r := range tt.ratings {
+				players = append(players, newRatedPlayer(r))
+			}
+
+			teams := ratingBalancedTeams(players, tt.teamCount)
+
+			if len(teams) != tt.teamCount {
+				t.Fatalf("got %d teams, want %d", len(teams), tt.teamCount)
+			}
+
+			seen := make(map[*Player]bool)
+			smallest, largest := math.MaxInt, 0
+			lowest, highest := math.Inf(1), math.Inf(-1)
+
+			for _, team := range teams {
+				for _, p := range team {
+					if seen[p] {
+						t.Fatal("a player was put on two teams")
+					}
+
+					seen[p] = true
+				}
+
+				smallest, largest = min(smallest, len(team)), max(largest, len(team))
+
+				if len(team) > 0 {
+					avg := averageRating(team)
+					lowest, highest = min(lowest, avg), max(highest, avg)
+				}
+			}
+
+			if len(seen) != len(players) {
+				t.Errorf("%d players were put on teams, want %d", len(seen), len(players))
+			}
+
+			if largest-smallest > 1 {
+				t.Errorf("team sizes range from %d to %d, want them within one", smallest, largest)
+			}
+
+			if spread := highest - lowest; spread > tt.maxSpread {
+				t.Errorf("average ratings are %v apart, want at most %v", spread, tt.maxSpread)
+			}
+		})
+	}
+}
+
+func TestRateLeaver(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// ratings holds the ratings of each team's members. The leaver is the first member of the
+		// first team.
+		ratings [][]float64
+
+		// inLobby holds the indices of the teams whose members joined during the endgame.
+		inLobby []int
+
+		// bots holds the indices of the teams made up of bots.
+		bots []int
+
+		want float64
+	}{
+		{"one even opponent", [][]float64{{1500}, {1500}}, nil, nil, -16},
+		{"two even opponents", [][]float64{{1500}, {1500}, {1500}}, nil, nil, -16},
+		{"team average", [][]float64{{1300, 1700}, {1500, 1500}}, nil, nil, -16},
+		{"stronger opponent", [][]float64{{1500}, {1900}}, nil, nil, -32.0 / 11},
+		{"weaker opponent", [][]float64{{1900}, {1500}}, nil, nil, -320.0 / 11},
+		{"opponents in the lobby", [][]float64{{1500}, {1500}}, []int{1}, nil, 0},
+		{"bot opponents", [][]float64{{1500}, {1500}, {1500}}, nil, []int{1}, -16},
+		{"only bot opponents", [][]float64{{1500}, {1500}}, nil, []int{1}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newMemoryStorage()
+			lobby := &Lobby{manager: &LobbyManager{storage: storage}}
+			ship := &Ship{lobby: lobby}
+
+			var leaver *Player
+
+			for i, ratings := range tt.ratings {
+				team := lobby.newTeam()
+				lobby.Teams = append(lobby.Teams, team)
+
+				for j, r := range ratings {
+					p := newRatedPlayer(r)
+					p.Name = fmt.Sprint("Player", i, j)
+					p.Client.Profile.ID = p.Name
+					p.Client.bot = slices.Contains(tt.bots, i)
+					p.Activity = ship
+
+					if slices.Contains(tt.inLobby, i) {
+						p.Activity = &LobbyActivity{}
+					}
+
+					team.AddPlayer(p)
+
+					if leaver == nil {
+						leaver = p
+					}
+				}
+			}
+
+			rating := leaver.Rating()
+
+			if err := ship.rateLeaver(leaver); err != nil {
+				t.Fatalf("rateLeaver() = %v", err)
+			}
+
+			if got := leaver.Rating() - rating; math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("rating changed by %v, want %v", got, tt.want)
+			}
+
+			stored, _ := storage.LoadProfile(leaver.Client.Profile.ID)
+
+			if stored == nil || stored.GamesPlayed != 1 || stored.Rating != leaver.Rating() {
+				t.Errorf("stored profile = %+v, want the leaver's with one game played", stored)
+			}
+
+			_ = lobby.ForAllPlayers(func(p *Player) error {
+				if p != leaver && p.Client.Profile.GamesPlayed != 0 {
+					t.Errorf("%s was rated as well as the leaver", p.Name)
+				}
+
+				return nil
+			})
+		})
+	}
+}
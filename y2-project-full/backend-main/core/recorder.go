@@ -5,6 +5,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"go.uber.org/zap"
@@ -27,6 +28,47 @@ type RecordedData struct {
 	Heatmap   []PlayerHeatmap
 	Minigames []MinigameSession
 	GameEnd   GameEnd
+	Chat      []ChatLine
+	Events    []EventLine
+	Stats     []PlayerStatsLine
+	Awards    []AwardLine
+}
+
+// A PlayerStatsLine holds a player's statistics for the whole game. Times are in seconds.
+type PlayerStatsLine struct {
+	Username        string
+	Team            uint8
+	Tokens          float64
+	Distance        float64
+	MinigameTime    float64
+	ShipTime        float64
+	FlagsCaptured   int
+	MinigamesPlayed int
+	MinigamesWon    int
+}
+
+// An AwardLine is an award given at the end of the game.
+type AwardLine struct {
+	Award    string
+	Username string
+	Value    float64
+}
+
+// An EventLine is a ship event that happened during the game, along with the time into the game it
+// started.
+type EventLine struct {
+	Event    string
+	T        float64
+	Duration float64
+}
+
+// A ChatLine is a chat message sent during the game, along with the time into the game it was sent.
+type ChatLine struct {
+	Username string
+	Team     uint8
+	Scope    ChatScope
+	Text     string
+	T        float64
 }
 
 // A MinigameSession is one minigame which has be played by a player/multiple players.
@@ -35,6 +77,7 @@ type MinigameSession struct {
 	Duration         float64
 	SessionTimestamp time.Time
 	PlayerResults    []PlayerResult
+	Stats            map[string]float64 // Custom statistics reported by the minigame.
 }
 type PlayerResult struct {
 	PlayerName string
@@ -69,7 +112,7 @@ func NewRecorder(shipTarget *Ship) *Recorder {
 		Data:       RecordedData{},
 		ShipTarget: shipTarget,
 		Timer: TickingTimer(shipTarget.Scheduler,
-			time.Now().Add(fullGameDuration),
+			time.Now().Add(shipTarget.settings.ShipDuration),
 			recordInterval, func() error { return Tick(shipTarget) },
 			func() error { return Tick(shipTarget) }),
 	}
@@ -96,11 +139,37 @@ func (r *Recorder) HeatMapRecord(s *Ship, data Position, p *Player) {
 		p.Name,
 		p.Team.Index(),
 		Heatmap{data.X, data.Y,
-			fullGameDuration.Seconds() - s.timer.TimeLeft().Seconds()},
+			s.settings.ShipDuration.Seconds() - s.timer.TimeLeft().Seconds()},
 	}
 	r.Data.Heatmap = append(r.Data.Heatmap, ph)
 }
 
+// ChatRecord records a chat message sent by a Player on a Ship.
+func (r *Recorder) ChatRecord(s *Ship, p *Player, entry ChatEntry) {
+	r.Data.Chat = append(r.Data.Chat, ChatLine{
+		p.Name,
+		p.Team.Index(),
+		entry.Scope,
+		entry.Text,
+		s.settings.ShipDuration.Seconds() - s.timer.TimeLeft().Seconds(),
+	})
+}
+
+// EventRecord records a ship event starting on a Ship.
+func (r *Recorder) EventRecord(s *Ship, event string, duration float64) {
+	r.Data.Events = append(r.Data.Events, EventLine{
+		event,
+		s.settings.ShipDuration.Seconds() - s.timer.TimeLeft().Seconds(),
+		duration,
+	})
+}
+
+// StatsRecord records the end-of-game statistics and awards.
+func (r *Recorder) StatsRecord(stats []PlayerStatsLine, awards []AwardLine) {
+	r.Data.Stats = stats
+	r.Data.Awards = awards
+}
+
 // WriteToDB gets the Data field from Recorder and writes that data to the MySQL database.
 func (r *Recorder) WriteToDB() {
 	Logger.Info("writing lobby data to database...")
@@ -124,7 +193,7 @@ func (r *Recorder) WriteToDB() {
 		Logger.Error("Pointer to ship doesn't exist. Data will not be inserted into the database.")
 		return
 	}
-	teamScores := [2]float64{0, 0}
+	teamScores := make([]float64, len(r.ShipTarget.lobby.Teams))
 	type MVP struct {
 		Player *Player
 		Score  float64
@@ -154,18 +223,65 @@ func (r *Recorder) WriteToDB() {
 	if err != nil {
 		Logger.Panic("Failed to insert to get last insert id", zap.Error(err))
 	}
+	// The two columns in gameLobbies only hold the first two teams, so store every team here too.
+	for i, score := range teamScores {
+		tsQuery := "INSERT INTO `gameLobbyTeams` VALUES (?, ?, ?)"
+		_, err = db.Exec(tsQuery, glPK, i, score)
+		if err != nil {
+			Logger.Panic("Failed to insert into gameLobbyTeams", zap.Error(err))
+		}
+	}
 	hmQuery := "INSERT INTO `heatMaps` VALUES (?, ?, ?, ?)"
 	_, err = db.ExecContext(context.Background(), hmQuery, glPK, timestamp, r.ShipTarget.lobby.ID,
 		r.Data.PlayerHeatmapToCSV())
 	if err != nil {
 		Logger.Panic("Failed to insert into heatmaps", zap.Error(err))
 	}
+	// INSERTING CHAT MESSAGES
+	for _, line := range r.Data.Chat {
+		cmQuery := "INSERT INTO `chatMessages` VALUES (default, ?, ?, ?, ?, ?, ?)"
+		_, err = db.Exec(cmQuery, glPK, line.Username, line.Team, string(line.Scope), line.Text,
+			line.T)
+		if err != nil {
+			Logger.Panic("Failed to insert into chatMessages", zap.Error(err))
+		}
+	}
+	// INSERTING SHIP EVENTS
+	for _, line := range r.Data.Events {
+		seQuery := "INSERT INTO `shipEvents` VALUES (default, ?, ?, ?, ?)"
+		_, err = db.Exec(seQuery, glPK, line.Event, line.T, line.Duration)
+		if err != nil {
+			Logger.Panic("Failed to insert into shipEvents", zap.Error(err))
+		}
+	}
+	// INSERTING PLAYER STATISTICS
+	for _, line := range r.Data.Stats {
+		psQuery := "INSERT INTO `playerStats` VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		_, err = db.Exec(psQuery, glPK, line.Username, line.Team, line.Tokens, line.Distance,
+			line.MinigameTime, line.ShipTime, line.FlagsCaptured, line.MinigamesPlayed,
+			line.MinigamesWon)
+		if err != nil {
+			Logger.Panic("Failed to insert into playerStats", zap.Error(err))
+		}
+	}
+	// INSERTING AWARDS
+	for _, line := range r.Data.Awards {
+		awQuery := "INSERT INTO `gameAwards` VALUES (?, ?, ?, ?)"
+		_, err = db.Exec(awQuery, glPK, line.Award, line.Username, line.Value)
+		if err != nil {
+			Logger.Panic("Failed to insert into gameAwards", zap.Error(err))
+		}
+	}
 	// INSERTING INDIVIDUAL MINIGAME DATA
 	for _, mSession := range r.Data.Minigames {
 		// Insert the data related to a single session.
-		msQuery := "INSERT INTO `minigameSessions` VALUES (default,?, ?, ?, ?)"
+		stats, err := json.Marshal(mSession.Stats)
+		if err != nil {
+			Logger.Panic("Failed to encode minigame stats", zap.Error(err))
+		}
+		msQuery := "INSERT INTO `minigameSessions` VALUES (default,?, ?, ?, ?, ?)"
 		res, err = db.Exec(msQuery, mSession.Name, glPK, mSession.Duration,
-			mSession.SessionTimestamp)
+			mSession.SessionTimestamp, string(stats))
 		if err != nil {
 			Logger.Panic("Failed to insert into minigameSessions", zap.Error(err))
 		}
@@ -185,82 +301,33 @@ func (r *Recorder) WriteToDB() {
 	}
 }
 
-// ResultsToPlayerResults converts a map of player scores/player wins and a gameName and adds it to PlayerResult
-func ResultsToPlayerResults(ps map[*Player]float64, pw map[*Player]uint8, gameName string) []PlayerResult {
+// MinigameRecord records a finished minigame. Every participant is recorded with their score and
+// whether their team won.
+func (r *Recorder) MinigameRecord(
+	ctx *MinigameContext,
+	result MinigameResult,
+	duration time.Duration,
+) {
 	var pr []PlayerResult
-	for p := range ps {
-		pr = append(pr, PlayerResult{p.Name, p.Team.Index(), ps[p],
-			pw[p]})
-		Logger.Info(fmt.Sprintf("%s on team %d %s %s with score %.0f",
-			p.Name, p.Team.Index(),
-			func() string {
-				if pw[p] == 1 {
-					return "won"
-				} else {
-					return "lost"
-				}
-			}(), gameName, ps[p]))
-	}
-	return pr
-}
-
-/*
-	func (r *Recorder) ClickRaceRecordMP(ps map[*Player]float64, pw map[*Player]uint8) {
-		pr := ResultsToPlayerResults(ps, pw, "cps_race_1v1")
-		ms := MinigameSession{"cps_race_1v1", 10, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
 
-	func (r *Recorder) ClickRaceRecordSP(p *Player, score float64, won uint8) {
-		pr := ResultsToPlayerResults(map[*Player]float64{p: score}, map[*Player]uint8{p: won}, "cps_race_sp")
-		ms := MinigameSession{"cps_race_sp", 10, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
-
-	func (r *Recorder) RPSRecordMP(ps map[*Player]float64, pw map[*Player]uint8, duration float64) {
-		pr := ResultsToPlayerResults(ps, pw, "rps_1v1")
-		ms := MinigameSession{"rps_1v1", duration, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
+	_ = ctx.ForAllPlayers(func(p *Player) error {
+		var won uint8
+		outcome := "lost"
 
-	func (r *Recorder) MolesRecordSP(p *Player, score float64, won uint8) {
-		pr := ResultsToPlayerResults(map[*Player]float64{p: score}, map[*Player]uint8{p: won}, "whack_a_mole")
-		ms := MinigameSession{"whack_a_mole", 30, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
+		if p.Team == result.Winner() {
+			won = 1
+			outcome = "won"
+		}
 
-	func (r *Recorder) ShooterRecord(ps map[*Player]float64, pw map[*Player]uint8, duration float64, players int) {
-		pr := ResultsToPlayerResults(ps, pw, "shooter_1v1")
-		ms := MinigameSession{"rps_1v1", duration, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
+		pr = append(pr, PlayerResult{p.Name, p.Team.Index(), result.Score(p), won})
 
-	func (r *Recorder) CardRecordSP(ps map[*Player]float64, pw map[*Player]uint8, duration float64) {
-		pr := ResultsToPlayerResults(ps, pw, "card_match_sp")
-		ms := MinigameSession{"rps_1v1", duration, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
+		Logger.Info(fmt.Sprintf("%s on team %d %s %s with score %.0f",
+			p.Name, p.Team.Index(), outcome, ctx.proto.Name, result.Score(p)))
 
-	func (r *Recorder) FBRecordSP(p *Player, score float64, won uint8, duration float64) {
-		pr := ResultsToPlayerResults(map[*Player]float64{p: score}, map[*Player]uint8{p: won}, "fb_sp")
-		ms := MinigameSession{"fb_sp", duration, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
+		return nil
+	})
 
-	func (r *Recorder) FBRecordSP(p *Player, score float64, won uint8, duration float64) {
-		pr := ResultsToPlayerResults(map[*Player]float64{p: score}, map[*Player]uint8{p: won}, "fb_sp")
-		ms := MinigameSession{"fb_sp", duration, time.Now(), pr}
-		r.Data.Minigames = append(r.Data.Minigames, ms)
-	}
-*/
-func (r *Recorder) RecordSP(p *Player, score float64, won uint8, duration float64, gameName string) {
-	pr := ResultsToPlayerResults(map[*Player]float64{p: score}, map[*Player]uint8{p: won}, "fb_sp")
-	ms := MinigameSession{gameName, duration, time.Now(), pr}
-	r.Data.Minigames = append(r.Data.Minigames, ms)
-}
-func (r *Recorder) RecordMP(ps map[*Player]float64, pw map[*Player]uint8, duration float64, gameName string) {
-	pr := ResultsToPlayerResults(ps, pw, gameName)
-	ms := MinigameSession{gameName, duration, time.Now(), pr}
+	ms := MinigameSession{ctx.proto.Name, duration.Seconds(), time.Now(), pr, result.stats}
 	r.Data.Minigames = append(r.Data.Minigames, ms)
 }
 
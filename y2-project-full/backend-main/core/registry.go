@@ -0,0 +1,218 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// registeredMinigames maps the names of the minigame prototypes given to RegisterMinigame to the
+// prototypes.
+var registeredMinigames = make(map[string]MinigamePrototype)
+
+// RegisterMinigame makes the given minigame available to the server. Minigame packages call this
+// from their init functions, so importing a minigame package is enough to make it playable.
+//
+// The server panics if another minigame has already been registered with the same name.
+func RegisterMinigame(proto MinigamePrototype) {
+	if _, exists := registeredMinigames[proto.Name]; exists {
+		Logger.Panic("minigame registered twice", zap.String("minigame", proto.Name))
+	}
+
+	registeredMinigames[proto.Name] = proto
+}
+
+// RegisteredMinigames returns a map which maps the names of every registered minigame to its
+// prototype.
+func RegisteredMinigames() map[string]MinigamePrototype {
+	return maps.Clone(registeredMinigames)
+}
+
+// validate returns an error describing what is wrong with the prototype, or nil if nothing is.
+func (p *MinigamePrototype) validate() error {
+	if p.Name == "" {
+		return errors.New("minigame has no name")
+	}
+
+	if p.DisplayName == "" {
+		return fmt.Errorf("minigame %q has no display name", p.Name)
+	}
+
+	if p.Constructor == nil {
+		return fmt.Errorf("minigame %q has no constructor", p.Name)
+	}
+
+	if p.PlayerCount != 1 && (p.PlayerCount < 2 || p.PlayerCount%2 != 0) {
+		return fmt.Errorf("minigame %q has an invalid player count", p.Name)
+	}
+
+	return nil
+}
+
+// validateMinigames returns an error if any of the given prototypes is invalid, or if a ship map
+// uses a minigame that isn't among them.
+func validateMinigames(minigames map[string]MinigamePrototype) error {
+	if len(minigames) == 0 {
+		return errors.New("no minigames are registered")
+	}
+
+	for name, proto := range minigames {
+		if err := proto.validate(); err != nil {
+			return err
+		}
+
+		if name != proto.Name {
+			return fmt.Errorf("minigame %q is registered as %q", proto.Name, name)
+		}
+	}
+
+	for mapName, m := range shipMaps {
+		for _, slot := range m.slots {
+			if _, ok := minigames[slot.minigame]; !ok {
+				return fmt.Errorf("map %q uses unknown minigame %q", mapName, slot.minigame)
+			}
+		}
+	}
+
+	return nil
+}
+
+// disabledMinigamesFromArgs returns the set of minigames named by the `--disable-minigames`
+// command-line flag, which takes a comma-separated list of minigame names. The server exits if any
+// of the names is unknown.
+func disabledMinigamesFromArgs(minigames map[string]MinigamePrototype) map[string]bool {
+	disabled := make(map[string]bool)
+	list := argValue("--disable-minigames")
+
+	if list == nil {
+		return disabled
+	}
+
+	for _, name := range strings.Split(*list, ",") {
+		name = strings.TrimSpace(name)
+
+		if _, ok := minigames[name]; !ok {
+			Logger.Fatal("--disable-minigames names an unknown minigame", zap.String("given", name))
+		}
+
+		disabled[name] = true
+	}
+
+	return disabled
+}
+
+// ToMap returns the prototype's metadata in the form used in messages.
+func (p *MinigamePrototype) ToMap() map[string]interface{} {
+	counts := p.TeamCounts
+
+	if counts == nil && p.PlayerCount > 1 {
+		counts = []int{2}
+	}
+
+	return map[string]interface{}{
+		"name":         p.Name,
+		"display_name": p.DisplayName,
+		"description":  p.Description,
+		"rules":        p.Rules,
+		"tags":         p.Tags,
+		"player_count": p.PlayerCount,
+		"team_size":    p.TeamSize(),
+		"team_counts":  counts,
+		"worth":        p.Worth,
+		"cooldown":     p.Cooldown.Seconds(),
+	}
+}
+
+// enabledMinigames returns a map which maps the names of the minigames that lobbies may currently
+// use to their prototypes.
+func (mgr *LobbyManager) enabledMinigames() map[string]MinigamePrototype {
+	enabled := make(map[string]MinigamePrototype)
+
+	for name, proto := range mgr.minigames {
+		if !mgr.disabledMinigames[name] {
+			enabled[name] = proto
+		}
+	}
+
+	return enabled
+}
+
+// HandleMinigameList sends the client the metadata of every minigame, sorted by name, and whether
+// each one can currently be played.
+func (mgr *LobbyManager) HandleMinigameList(client *Client) error {
+	names := make([]string, 0, len(mgr.minigames))
+
+	for name := range mgr.minigames {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	list := make([]map[string]interface{}, 0, len(names))
+
+	for _, name := range names {
+		proto := mgr.minigames[name]
+
+		m := proto.ToMap()
+		m["enabled"] = !mgr.disabledMinigames[name]
+
+		list = append(list, m)
+	}
+
+	return client.Send(NewMessage("minigame_list").Add("minigames", list))
+}
+
+// setMinigameEnabled enables or disables the minigame with the given name. Flags for a disabled
+// minigame can't be activated, and players locked to one are released, but minigames that have
+// already started are played to the end.
+func (mgr *LobbyManager) setMinigameEnabled(name string, enabled bool) error {
+	Logger.Info(
+		"changing minigame availability",
+		zap.String("minigame", name),
+		zap.Bool("enabled", enabled),
+	)
+
+	if enabled {
+		delete(mgr.disabledMinigames, name)
+		return nil
+	}
+
+	mgr.disabledMinigames[name] = true
+
+	var errs []error
+
+	for _, act := range mgr.activities {
+		if ship := act.lobby.ship; ship != nil {
+			errs = append(errs, ship.closeDisabledFlags(name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeDisabledFlags releases the players locked to any activated flag for the given minigame.
+func (ship *Ship) closeDisabledFlags(name string) error {
+	var errs []error
+
+	for id, f := range ship.fm.flags {
+		if f.minigameProto.Name != name || !f.isActivated() {
+			continue
+		}
+
+		for p := range f.activation.lockedPlayers {
+			errs = append(errs, ship.notifyLockReleased(p, id, lockReleaseDisabled))
+		}
+
+		f.clearActivation()
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	// The released players may be wanted elsewhere.
+	return errors.Join(errors.Join(errs...), ship.addPlayersToFlags())
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"math/rand"
+	"time"
+)
+
+// rematchVoteDuration is how long players have after a game to vote for a rematch.
+const rematchVoteDuration = 30 * time.Second
+
+// A rematchVote is a player's choice in the post-game vote.
+type rematchVote string
+
+const (
+	// voteSameTeams asks for a rematch with the teams kept as they are.
+	voteSameTeams rematchVote = "same"
+
+	// voteShuffleTeams asks for a rematch with the players shuffled into new teams.
+	voteShuffleTeams rematchVote = "shuffle"
+
+	// voteDecline asks for no rematch.
+	voteDecline rematchVote = "decline"
+)
+
+// A postGame holds the state of the rematch vote that follows a finished game.
+type postGame struct {
+	// votes maps each player who has voted to their vote.
+	votes map[*Player]rematchVote
+
+	// timer counts down to the end of the vote.
+	timer FunctionTimer
+}
+
+// tally returns the number of votes for each option.
+func (pg *postGame) tally() map[rematchVote]int {
+	counts := map[rematchVote]int{voteSameTeams: 0, voteShuffleTeams: 0, voteDecline: 0}
+
+	for _, vote := range pg.votes {
+		counts[vote] += 1
+	}
+
+	return counts
+}
+
+// recordSeriesResult adds the result of a finished game to the lobby's series score. A nil winner
+// represents a draw.
+func (lobby *Lobby) recordSeriesResult(winner *Team) {
+	lobby.SeriesGames += 1
+
+	if winner != nil {
+		lobby.SeriesScores[winner.Index()] += 1
+	}
+}
+
+// resetSeries clears the lobby's series score.
+func (lobby *Lobby) resetSeries() {
+	lobby.SeriesScores = make([]int, len(lobby.Teams))
+	lobby.SeriesGames = 0
+}
+
+// shuffleTeams reassigns every player to a random team, keeping the teams as even as possible.
+//
+// This must only be called while every player is in the lobby activity.
+func (lobby *Lobby) shuffleTeams() {
+	var players []*Player
+
+	_ = lobby.ForAllPlayers(func(p *Player) error {
+		players = append(players, p)
+		return nil
+	})
+
+	rand.Shuffle(len(players), func(i, j int) {
+		players[i], players[j] = players[j], players[i]
+	})
+
+	// Deal the players out to each team in turn.
+	for i, p := range players {
+		if team := lobby.Teams[i%len(lobby.Teams)]; p.Team != team {
+			p.SwitchTeam(team)
+		}
+	}
+}
+
+// beginPostGame starts the rematch vote. It should be called once all players have been moved
+// back into the lobby activity after a game.
+func (act *LobbyActivity) beginPostGame() error {
+	act.logger().Info("starting rematch vote")
+
+	pg := &postGame{votes: make(map[*Player]rematchVote)}
+
+	pg.timer = SingleTimer(act.scheduler, time.Now().Add(rematchVoteDuration), func() error {
+		if act.postGame != pg {
+			// The vote has already finished.
+			return nil
+		}
+
+		return act.cancelPostGame("timeout")
+	})
+
+	act.postGame = pg
+
+	msg := NewMessage("lobby_rematch_vote_start")
+	_ = msg.Add("seconds_left", rematchVoteDuration.Seconds())
+	_ = msg.Add("series_scores", act.lobby.SeriesScores)
+	_ = msg.Add("series_games", act.lobby.SeriesGames)
+
+	return act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// endPostGame stops the rematch vote without notifying anybody.
+func (act *LobbyActivity) endPostGame() {
+	if act.postGame == nil {
+		return
+	}
+
+	act.postGame.timer.Stop()
+	act.postGame = nil
+}
+
+// cancelPostGame ends the rematch vote without a rematch and tells the players why. The lobby
+// returns to its normal state, where players must ready up to start a game.
+func (act *LobbyActivity) cancelPostGame(reason string) error {
+	act.logger().Info("rematch vote failed", zap.String("reason", reason))
+
+	act.endPostGame()
+
+	msg := NewMessage("lobby_rematch_cancelled").Add("reason", reason)
+
+	return act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// startRematch ends the rematch vote and starts the next game, shuffling the teams first if
+// requested.
+func (act *LobbyActivity) startRematch(shuffle bool) error {
+	act.endPostGame()
+
+	if shuffle {
+		act.lobby.shuffleTeams()
+
+		// Scores from before the shuffle belong to teams that no longer exist.
+		act.lobby.resetSeries()
+	}
+
+	if !act.lobby.IsReady() {
+		// Somebody must have left, leaving the teams unplayable.
+		return act.cancelPostGame("teams_not_ready")
+	}
+
+	act.logger().Info("starting rematch", zap.Bool("shuffle", shuffle))
+
+	notifyErr := act.lobby.ForAllPlayers(func(p *Player) error {
+		// Include the player's team so that they know where they stand before the ship welcomes them.
+		msg := NewMessage("lobby_rematch_starting")
+		_ = msg.Add("shuffle", shuffle)
+		_ = msg.Add("your_team", p.Team.Index())
+
+		return p.Client.Send(msg)
+	})
+
+	return errors.Join(notifyErr, act.doStartGame())
+}
+
+// evaluatePostGame checks whether the rematch vote has been decided and acts on the result. A
+// rematch happens once more than half of the players have voted for one; the teams are shuffled
+// if more of those voters asked for a shuffle than for the same teams.
+func (act *LobbyActivity) evaluatePostGame() error {
+	counts := act.postGame.tally()
+	playerCount := act.lobby.PlayerCount()
+
+	rematchVotes := counts[voteSameTeams] + counts[voteShuffleTeams]
+
+	if 2*rematchVotes > playerCount {
+		return act.startRematch(counts[voteShuffleTeams] > counts[voteSameTeams])
+	}
+
+	// If enough players have declined that a majority is no longer possible, give up early.
+	if 2*(playerCount-counts[voteDecline]) <= playerCount {
+		return act.cancelPostGame("declined")
+	}
+
+	return nil
+}
+
+// doRematchVote handles a rematch vote from the given player.
+func (act *LobbyActivity) doRematchVote(player *Player, message *Message) error {
+	if act.postGame == nil {
+		return player.Client.Send(NewMessage("lobby_rematch_no_vote_error"))
+	}
+
+	voteStr, err := message.GetString("vote")
+	vote := rematchVote(voteStr)
+
+	if err != nil || (vote != voteSameTeams && vote != voteShuffleTeams && vote != voteDecline) {
+		return player.Client.Send(NewMessage("lobby_rematch_vote_format_error"))
+	}
+
+	act.playerLogger(player).Info("player voted", zap.String("vote", voteStr))
+
+	act.postGame.votes[player] = vote
+
+	notifyErr := act.notifyRematchTally(player, vote)
+
+	return errors.Join(notifyErr, act.evaluatePostGame())
+}
+
+// notifyRematchTally tells every player in the lobby about a vote and the new vote totals.
+func (act *LobbyActivity) notifyRematchTally(voter *Player, vote rematchVote) error {
+	msg := NewMessage("lobby_rematch_vote_update")
+	_ = msg.Add("their_name", voter.Name)
+	_ = msg.Add("vote", string(vote))
+	_ = msg.Add("tally", act.postGame.tally())
+
+	return act.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// removeRematchVoter discards the vote of a player who has left the lobby and re-checks the vote,
+// since the number of players needed for a majority has changed.
+func (act *LobbyActivity) removeRematchVoter(player *Player) error {
+	if act.postGame == nil {
+		return nil
+	}
+
+	delete(act.postGame.votes, player)
+
+	if act.lobby.PlayerCount() == 0 {
+		act.endPostGame()
+		return nil
+	}
+
+	return act.evaluatePostGame()
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"cmp"
 	"errors"
 	"go.uber.org/zap"
 	"math"
@@ -32,6 +33,18 @@ type activation struct {
 
 	// lockedPlayers is the set of players who are locked to the flag.
 	lockedPlayers map[*Player]struct{}
+
+	// teamCount is the number of teams that will play the minigame once enough players are locked.
+	teamCount int
+
+	// activatingTeam is the team of the player who activated the flag.
+	activatingTeam *Team
+
+	// declined is the set of players who have unlocked themselves from the flag.
+	declined map[*Player]struct{}
+
+	// timeout is the timer after which the locks are released if the minigame hasn't started.
+	timeout FunctionTimer
 }
 
 // A flag is an object which a team can capture by winning a minigame.
@@ -58,6 +71,32 @@ type flag struct {
 	// activation is the flag's activation state.
 	// This will be nil when the flag has not been activated.
 	activation *activation
+
+	// shielded is true if and only if the flag's owner has protected it from the next capture.
+	shielded bool
+
+	// doubled is true if and only if the flag's owner has doubled its worth.
+	doubled bool
+
+	// handicapBonus is the number of extra tokens the flag is worth to its owner because the owner
+	// was short-handed when it captured the flag.
+	handicapBonus int
+
+	// allowedTeams is the set of teams whose members may play the flag's minigame, or nil if every
+	// team may.
+	allowedTeams map[*Team]struct{}
+
+	// decaysAt is the time at which the flag returns to neutral unless its owner defends it, or the
+	// zero time if the flag isn't decaying.
+	decaysAt time.Time
+
+	// eventBonus is the number of extra tokens the flag is worth to its owner because it was
+	// captured during a double worth event.
+	eventBonus int
+
+	// expired is true if and only if the flag is a bonus flag whose event has ended while its
+	// minigame was going. The flag is taken away when the minigame finishes.
+	expired bool
 }
 
 // isActivated returns true if and only if this flag is activated.
@@ -74,15 +113,27 @@ func (f *flag) needsPlayer(p *Player) bool {
 		)
 	}
 
+	if !f.allowsTeam(p.Team) || f.hasDeclined(p) {
+		return false
+	}
+
 	reqN := f.minigameProto.TeamSize()
 
-	// Count how many players there are on the same team as p.
+	// Count how many players there are on the same team as p, and which teams have players.
 	realN := 0
+	teams := make(map[*Team]struct{})
 
 	for pl := range f.activation.lockedPlayers {
 		if pl.Team == p.Team {
 			realN++
 		}
+
+		teams[pl.Team] = struct{}{}
+	}
+
+	if realN == 0 && len(teams) >= f.activation.teamCount {
+		// Enough other teams have already joined the minigame.
+		return false
 	}
 
 	// If the actual number of players we have on the team is fewer than the number required,
@@ -93,7 +144,7 @@ func (f *flag) needsPlayer(p *Player) bool {
 // Returns true if and only if the flag has enough players locked to it that the minigame can
 // start with them.
 func (f *flag) hasAllPlayers() bool {
-	return len(f.activation.lockedPlayers) == f.minigameProto.PlayerCount
+	return len(f.activation.lockedPlayers) == f.minigameProto.playersFor(f.activation.teamCount)
 }
 
 // The flagManager stores all of the flag information for the ship.
@@ -128,6 +179,17 @@ func (fm *flagManager) addMinigameFlag(id string, proto MinigamePrototype, pos P
 	}
 }
 
+// hasFlag returns true if and only if the given flag is still in the ship.
+func (fm *flagManager) hasFlag(f *flag) bool {
+	for _, flag := range fm.flags {
+		if flag == f {
+			return true
+		}
+	}
+
+	return false
+}
+
 // flagForPlayer returns a pointer to the flag that this player is locked to,
 // or nil if the player is not locked to any flag.
 func (fm *flagManager) flagForPlayer(p *Player) *flag {
@@ -202,8 +264,11 @@ func (fm *flagManager) clearForEndgame() {
 	Logger.Info("clearing flags for endgame state")
 
 	for _, flag := range fm.flags {
-		flag.activation = nil
+		flag.clearActivation()
 		flag.cooldown.Stop()
+
+		// Stopping the timer doesn't change our copy of it, and stopping it again would block.
+		flag.cooldown = ExpiredTimer()
 	}
 }
 
@@ -219,24 +284,21 @@ func (fm *flagManager) areAnyMinigamesOngoing() bool {
 	return false
 }
 
-// teamScores counts up the tokens captured by teams 0 and 1 and returns them in that order.
-func (fm *flagManager) teamScores() (s0, s1 int) {
+// teamScores counts up the tokens captured by each of the given number of teams and returns them
+// in team index order.
+func (fm *flagManager) teamScores(teamCount int) []int {
+	scores := make([]int, teamCount)
+
 	for _, f := range fm.flags {
 		if f.owner == nil {
 			// Flag has not been captured.
 			continue
 		}
 
-		worth := f.minigameProto.Worth
-
-		if f.owner.Index() == 0 {
-			s0 += worth
-		} else {
-			s1 += worth
-		}
+		scores[f.owner.Index()] += f.worth()
 	}
 
-	return
+	return scores
 }
 
 // The Ship is the main core environment.
@@ -257,6 +319,61 @@ type Ship struct {
 	// of the two players will have two tokens added to their individual score.
 	individualScores map[*Player]float64
 
+	// ratingChanges maps player pointers to the total change in their rating over this game so far.
+	ratingChanges map[*Player]float64
+
+	// progression maps player pointers to the XP and achievements they have earned during this
+	// game.
+	progression map[*Player]*gameProgress
+
+	// missedPings maps player pointers to the pings sent by their teammates while they were away
+	// from the ship.
+	missedPings map[*Player][]ping
+
+	// pingLimiter counts the pings sent by each player.
+	pingLimiter *rateLimiter[*Player]
+
+	// inventories maps player pointers to the power-ups they hold, in the order they were won.
+	inventories map[*Player][]powerUp
+
+	// speedBoosts maps player pointers to the timers for their speed boosts.
+	speedBoosts map[*Player]FunctionTimer
+
+	// fastestCaptures maps team pointers to the shortest minigame in which they captured a flag.
+	fastestCaptures map[*Team]time.Duration
+
+	// suddenDeath is the sudden-death flag, or nil if sudden death has not started.
+	suddenDeath *flag
+
+	// suddenDeathWinner is the team which won the sudden-death minigame, or nil if no team has.
+	suddenDeathWinner *Team
+
+	// startingSizes maps team pointers to the number of players the team started the game with.
+	startingSizes map[*Team]int
+
+	// vacancies maps team pointers to the number of places on the team that players who left have
+	// not had filled.
+	vacancies map[*Team]int
+
+	// bankedTokens is the number of tokens each team has earned other than through the flags it
+	// owns, such as by holding flags or owning bonus flags that have gone, in team index order.
+	bankedTokens []int
+
+	// playerStats maps player pointers to the statistics collected about them during the game.
+	playerStats map[*Player]*playerStats
+
+	// teamStats holds the statistics collected about each team, in team index order.
+	teamStats []teamStats
+
+	// activeEvent is the ship event that is currently running, or nil if there isn't one.
+	activeEvent *shipEvent
+
+	// bonusFlagCount is the number of bonus flags that have been added, used to give them IDs.
+	bonusFlagCount int
+
+	// lastHoldIncome is the time up to which hold income has been paid.
+	lastHoldIncome time.Time
+
 	// Scheduler is the scheduler which this ship and the minigames can use to trigger events.
 	Scheduler Scheduler
 
@@ -264,6 +381,9 @@ type Ship struct {
 	// expires.
 	timer FunctionTimer
 
+	// settings is the copy of the lobby settings that this ship was created with.
+	settings LobbySettings
+
 	// isEndgame is true if and only if the ship is in the endgame state.
 	isEndgame bool
 	// Recorder is the pointer to the Recorder, nil if flag --record is not used.
@@ -273,15 +393,33 @@ type Ship struct {
 // NewShip returns a pointer to a new ship created from the given lobby and using the given
 // scheduler.
 func NewShip(lobby *Lobby, scheduler Scheduler) *Ship {
-	return &Ship{
+	ship := &Ship{
 		Scheduler:        scheduler,
 		lobby:            lobby,
 		fm:               &flagManager{flags: make(map[string]*flag)},
 		pm:               NewPositionManager("ship_mov_"),
 		individualScores: make(map[*Player]float64),
+		ratingChanges:    make(map[*Player]float64),
+		progression:      make(map[*Player]*gameProgress),
+		missedPings:      make(map[*Player][]ping),
+		pingLimiter:      newRateLimiter[*Player](maxPingsPerWindow, pingWindow),
+		inventories:      make(map[*Player][]powerUp),
+		speedBoosts:      make(map[*Player]FunctionTimer),
+		fastestCaptures:  make(map[*Team]time.Duration),
+		startingSizes:    make(map[*Team]int),
+		vacancies:        make(map[*Team]int),
+		bankedTokens:     make([]int, len(lobby.Teams)),
+		playerStats:      make(map[*Player]*playerStats),
+		teamStats:        make([]teamStats, len(lobby.Teams)),
 		timer:            ExpiredTimer(),
+		settings:         lobby.Settings.clone(),
 		isEndgame:        false,
 	}
+
+	ship.pm.SpeedLimit = ship.moveSpeed
+	ship.pm.Visible = ship.canSee
+
+	return ship
 }
 
 func (ship *Ship) logger() *zap.Logger {
@@ -292,88 +430,97 @@ func (ship *Ship) logger() *zap.Logger {
 func (ship *Ship) createInitialScores() {
 	_ = ship.lobby.ForAllPlayers(func(player *Player) error {
 		ship.individualScores[player] = 0
+		ship.startStats(player)
 
 		return nil
 	})
 }
 
-// createFlags places flags in the ship.
+// createFlags places flags in the ship according to the map and minigame pool in the settings.
 func (ship *Ship) createFlags() {
-	// todo: Lay out flags randomly.
-	// For now we just place a few demo flags for testing.
+	ship.logger().Info("placing flags", zap.String("map", ship.settings.Map))
 
-	ship.logger().Info("placing flags")
+	protos := ship.lobby.manager.enabledMinigames()
+	m := shipMaps[ship.settings.Map]
 
-	protos := ship.lobby.manager.minigames
+	// Only use minigames that the teams are big enough for. The lobby can't be ready unless there
+	// is at least one of these, but the map's own minigames are used if there somehow isn't.
+	pool := ship.settings.playablePool(protos, ship.lobby.smallestTeamSize(), len(ship.lobby.Teams))
 
-	ship.fm.addMinigameFlag("flag0", protos["shooter_3v3"], Position{
-		X: 0,
-		Y: 0,
-	})
+	for i, name := range m.assignMinigames(pool) {
+		slot := m.slots[i]
+		proto, ok := protos[name]
 
-	ship.fm.addMinigameFlag("flag1", protos["race_2v2"], Position{
-		X: -192,
-		Y: 208,
-	})
+		if !ok {
+			// The map's own minigame has been disabled, so the slot is left empty.
+			ship.logger().Warn(
+				"no minigame available for flag",
+				zap.String("flag", slot.id),
+				zap.String("minigame", name),
+			)
 
-	ship.fm.addMinigameFlag("flag2", protos["card_match_sp"], Position{
-		X: 192,
-		Y: 208,
-	})
+			continue
+		}
 
-	ship.fm.addMinigameFlag("shush", protos["fb_sp"], Position{
-		X: 192,
-		Y: 0,
-	})
+		ship.fm.addMinigameFlag(slot.id, proto, slot.pos)
+	}
+}
 
-	ship.fm.addMinigameFlag("wam", protos["whack_a_mole"], Position{
-		X: -192,
-		Y: 0,
-	})
+// spawnSpacing is the distance between neighbouring players when a team spawns.
+const spawnSpacing float64 = 32
 
-	ship.fm.addMinigameFlag("blah", protos["rps_1v1"], Position{
-		X: 0,
-		Y: 256,
-	})
+// A teamSpawn describes where a team spawns in the ship.
+type teamSpawn struct {
+	// centre is the middle of the team's row of spawn positions.
+	centre Position
 
-	ship.fm.addMinigameFlag("dmspt", protos["shooter_1v1"], Position{
-		X: 0,
-		Y: -256,
-	})
-
-	ship.fm.addMinigameFlag("idfk", protos["cps_race_sp"], Position{
-		X: 192,
-		Y: -208,
-	})
+	// along is the unit vector pointing along the team's row of spawn positions.
+	along Position
+}
 
-	ship.fm.addMinigameFlag("idfk_", protos["cps_race_1v1"], Position{
-		X: -192,
-		Y: -208,
-	})
+// teamSpawns contains the spawn for each team, in team index order. The first two teams spawn at
+// the bottom and top of the ship, and any others spawn on the left and right.
+var teamSpawns = [maxTeamCount]teamSpawn{
+	{centre: Position{X: 0, Y: 416}, along: Position{X: 1, Y: 0}},
+	{centre: Position{X: 0, Y: -400}, along: Position{X: 1, Y: 0}},
+	{centre: Position{X: -352, Y: 0}, along: Position{X: 0, Y: 1}},
+	{centre: Position{X: 352, Y: 0}, along: Position{X: 0, Y: 1}},
 }
 
-// spawnTeam randomly places the members of the team with the given index in the given positions.
+// spawnOffsets returns the offsets along the spawn row, in multiples of spawnSpacing, of the spawn
+// positions for a team with n members.
 //
-// If there is only one member, they will be placed in the middle. If there are two, they will be
-// placed on the left and right. If there are three, all three positions will be filled.
-func (ship *Ship) spawnTeam(index int, left Position, middle Position, right Position) {
-	// Get the members in a random order.
-	members := ship.lobby.Teams[index].randomisedMembers()
-
-	if len(members) == 1 {
-		// Only one member, so put them in the middle.
-		ship.pm.Map[members[0]] = middle
+// The positions form a row centred on the team's spawn point. Odd-sized teams fill the centre
+// position, while even-sized teams leave it empty so that the row stays symmetrical. For example,
+// one player spawns in the middle, two spawn on the left and right, and three fill all three.
+func spawnOffsets(n int) []int {
+	offsets := make([]int, 0, n)
+
+	for i := 0; i < n; i++ {
+		k := i - n/2
+
+		if n%2 == 0 && k >= 0 {
+			// Skip the centre.
+			k += 1
+		}
 
-		return
+		offsets = append(offsets, k)
 	}
 
-	// At least two members, so put the first two on the left and right.
-	ship.pm.Map[members[0]] = left
-	ship.pm.Map[members[1]] = right
+	return offsets
+}
+
+// spawnTeam randomly places the members of the team with the given index in a row at the given
+// spawn.
+func (ship *Ship) spawnTeam(index int, spawn teamSpawn) {
+	// Get the members in a random order.
+	members := ship.lobby.Teams[index].randomisedMembers()
 
-	// If there's another member, put them in the middle.
-	if len(members) == 3 {
-		ship.pm.Map[members[2]] = middle
+	for i, k := range spawnOffsets(len(members)) {
+		ship.pm.Map[members[i]] = Position{
+			X: spawn.centre.X + float64(k)*spawnSpacing*spawn.along.X,
+			Y: spawn.centre.Y + float64(k)*spawnSpacing*spawn.along.Y,
+		}
 	}
 }
 
@@ -382,45 +529,9 @@ func (ship *Ship) spawnTeam(index int, left Position, middle Position, right Pos
 func (ship *Ship) setInitialPositions() {
 	ship.logger().Info("spawning players")
 
-	// Spawn team zero at the bottom.
-	ship.spawnTeam(
-		0,
-
-		Position{
-			X: -32,
-			Y: 416,
-		},
-
-		Position{
-			X: 0,
-			Y: 416,
-		},
-
-		Position{
-			X: 32,
-			Y: 416,
-		},
-	)
-
-	// Spawn team one at the top.
-	ship.spawnTeam(
-		1,
-
-		Position{
-			X: -32,
-			Y: -400,
-		},
-
-		Position{
-			X: 0,
-			Y: -400,
-		},
-
-		Position{
-			X: 32,
-			Y: -400,
-		},
-	)
+	for i := range ship.lobby.Teams {
+		ship.spawnTeam(i, teamSpawns[i])
+	}
 }
 
 // welcomeAll moves all players into the ship activity and sends them a welcome (i.e.
@@ -452,18 +563,21 @@ func (ship *Ship) tick() error {
 
 	msg := NewMessage("ship_tick").Add("seconds_left", secsLeft)
 
-	return ship.ForAllShipPlayers(func(p *Player) error {
+	tickErr := ship.ForAllShipPlayers(func(p *Player) error {
 		return p.Client.Send(msg)
 	})
+
+	// The map may have flags decaying or paying out over time.
+	return errors.Join(tickErr, ship.decayFlags(), ship.payHoldIncome())
 }
 
 // cachedGameDuration is used by gameDuration to store the game duration once it has been determined
 // for the first time.
 var cachedGameDuration time.Duration = 0
 
-// gameDuration returns the duration that should be used for the ship stage. It first checks the
-// command-line arguments for a duration override, but returns fullGameDuration if there is no
-// override present.
+// gameDuration returns the default duration for the ship stage, which lobbies start with in their
+// settings. It first checks the command-line arguments for a duration override, but returns
+// fullGameDuration if there is no override present.
 func gameDuration() time.Duration {
 	if cachedGameDuration != time.Duration(0) {
 		return cachedGameDuration
@@ -515,7 +629,7 @@ func (ship *Ship) startTimer() {
 	ship.timer = TickingTimer(
 		ship.Scheduler,
 
-		time.Now().Add(gameDuration()),
+		time.Now().Add(ship.settings.ShipDuration),
 		shipTickInterval,
 
 		// Call tick on every interval.
@@ -533,6 +647,7 @@ func (ship *Ship) Start() error {
 	ship.logger().Info("entering ship stage")
 
 	ship.createInitialScores()
+	ship.recordStartingSizes()
 	ship.createFlags()
 	ship.setInitialPositions()
 
@@ -540,7 +655,9 @@ func (ship *Ship) Start() error {
 	// so we do it before starting the core timer.
 	welcomeErr := ship.welcomeAll()
 
+	ship.lastHoldIncome = time.Now()
 	ship.startTimer()
+	ship.scheduleNextEvent(time.Now().Add(shipEventInterval))
 	record = slices.Contains(os.Args, "--record")
 	if record {
 		ship.logger().Info("recording ship data")
@@ -554,9 +671,14 @@ func (ship *Ship) Start() error {
 func (ship *Ship) welcomePlayer(p *Player) error {
 	ship.logger().Info("welcoming player", zap.String("name", p.Name))
 
+	return p.Client.Send(ship.welcomeMessage(p))
+}
+
+// welcomeMessage builds the message that tells a player about the ship layout and content.
+func (ship *Ship) welcomeMessage(p *Player) *Message {
 	msg := NewMessage("ship_welcome")
 
-	_ = msg.Add("game_duration", gameDuration().Seconds())
+	_ = msg.Add("game_duration", ship.settings.ShipDuration.Seconds())
 
 	_ = msg.Add("your_spawn", map[string]float64{
 		"x": ship.pm.Map[p].X,
@@ -566,6 +688,10 @@ func (ship *Ship) welcomePlayer(p *Player) error {
 	peerSpawns := make(map[string]map[string]float64)
 
 	_ = p.ForAllShipPeers(func(peer *Player) error {
+		if !ship.canSee(p, peer) {
+			return nil
+		}
+
 		peerSpawns[peer.Name] = map[string]float64{
 			"x": ship.pm.Map[peer].X,
 			"y": ship.pm.Map[peer].Y,
@@ -579,21 +705,30 @@ func (ship *Ship) welcomePlayer(p *Player) error {
 	flagInfo := make(map[string]map[string]interface{})
 
 	for id, f := range ship.fm.flags {
-		flagInfo[id] = map[string]interface{}{
-			"pos": map[string]float64{
-				"x": f.pos.X,
-				"y": f.pos.Y,
-			},
-			"minigame":     f.minigameProto.Name,
-			"worth":        f.minigameProto.Worth,
-			"player_count": f.minigameProto.PlayerCount,
-			"cooldown":     f.minigameProto.Cooldown.Seconds(),
-		}
+		flagInfo[id] = ship.flagInfo(f)
 	}
 
 	_ = msg.Add("flags", flagInfo)
+	_ = msg.Add("flag_rules", ship.flagRules().ToMap())
 
-	return p.Client.Send(msg)
+	return msg
+}
+
+// flagInfo describes where the flag is and what its minigame needs.
+func (ship *Ship) flagInfo(f *flag) map[string]interface{} {
+	teamCount := f.minigameProto.teamsPlaying(len(ship.lobby.Teams))
+
+	return map[string]interface{}{
+		"pos": map[string]float64{
+			"x": f.pos.X,
+			"y": f.pos.Y,
+		},
+		"minigame":     f.minigameProto.Name,
+		"worth":        f.minigameProto.Worth,
+		"player_count": f.minigameProto.playersFor(teamCount),
+		"team_count":   teamCount,
+		"cooldown":     ship.settings.Cooldown(&f.minigameProto).Seconds(),
+	}
 }
 
 // welcomePlayerBack sends a player a message bringing them up-to-date on the current ship
@@ -620,6 +755,10 @@ func (ship *Ship) welcomePlayerBack(p *Player) error {
 	peerPositions := make(map[string]map[string]float64)
 
 	_ = p.ForAllShipPeers(func(peer *Player) error {
+		if !ship.canSee(p, peer) {
+			return nil
+		}
+
 		peerPositions[peer.Name] = map[string]float64{
 			"x": ship.pm.Map[peer].X,
 			"y": ship.pm.Map[peer].Y,
@@ -629,7 +768,38 @@ func (ship *Ship) welcomePlayerBack(p *Player) error {
 	})
 
 	_ = msg.Add("peer_positions", peerPositions)
+	_ = msg.Add("flag_states", ship.flagStates())
+	_ = msg.Add("powerups", ship.inventory(p))
+
+	// Show the player what their teammates pinged while they were away.
+	_ = msg.Add("pings", ship.takeMissedPings(p))
+
+	if event := ship.eventState(); event != nil {
+		_ = msg.Add("event", event)
+	}
+
+	selfErr := p.Client.Send(msg)
 
+	otherMsg := NewMessage("ship_welcome_back_peer")
+	_ = otherMsg.Add("their_name", p.Name)
+	_ = otherMsg.Add("spawn", spawnObject)
+
+	// Notify players who are in the ship.
+	peerErr := p.ForAllShipPeers(func(peer *Player) error {
+		if !ship.canSee(peer, p) {
+			return nil
+		}
+
+		return peer.Client.Send(otherMsg)
+	})
+
+	return errors.Join(selfErr, peerErr)
+}
+
+// flagStates returns the current state of every flag that has something worth reporting, such as
+// an owner, a cooldown, locked players or an ongoing minigame. Flags with nothing to report are
+// left out.
+func (ship *Ship) flagStates() map[string]map[string]interface{} {
 	flagStates := make(map[string]map[string]interface{})
 
 	for id, f := range ship.fm.flags {
@@ -643,6 +813,18 @@ func (ship *Ship) welcomePlayerBack(p *Player) error {
 			info["cooldown_left"] = f.cooldown.TimeLeft().Seconds()
 		}
 
+		if f.shielded {
+			info["shielded"] = true
+		}
+
+		if f.doubled {
+			info["double_worth"] = true
+		}
+
+		if left, decaying := f.decaysIn(); decaying {
+			info["decays_in"] = left.Seconds()
+		}
+
 		if f.isActivated() {
 			lockedNames := make([]string, 0)
 
@@ -672,20 +854,7 @@ func (ship *Ship) welcomePlayerBack(p *Player) error {
 		flagStates[id] = info
 	}
 
-	_ = msg.Add("flag_states", flagStates)
-
-	selfErr := p.Client.Send(msg)
-
-	otherMsg := NewMessage("ship_welcome_back_peer")
-	_ = otherMsg.Add("their_name", p.Name)
-	_ = otherMsg.Add("spawn", spawnObject)
-
-	// Notify players who are in the ship.
-	peerErr := p.ForAllShipPeers(func(peer *Player) error {
-		return peer.Client.Send(otherMsg)
-	})
-
-	return errors.Join(selfErr, peerErr)
+	return flagStates
 }
 
 // addPlayerToFlag locks a player to a flag. It panics if the flag is not active.
@@ -710,14 +879,18 @@ func (ship *Ship) addPlayerToFlag(f *flag, p *Player) error {
 		zap.String("player", p.Name),
 	)
 
+	expiresIn := f.activation.timeout.TimeLeft().Seconds()
+
 	selfMsg := NewMessage("ship_player_lock_set")
 	_ = selfMsg.Add("flag_id", id)
+	_ = selfMsg.Add("expires_in", expiresIn)
 
 	selfErr := p.Client.Send(selfMsg)
 
 	peerMsg := NewMessage("ship_peer_lock_set")
 	_ = peerMsg.Add("their_name", p.Name)
 	_ = peerMsg.Add("flag_id", id)
+	_ = peerMsg.Add("expires_in", expiresIn)
 
 	peerErr := p.ForAllShipPeers(func(peer *Player) error {
 		return peer.Client.Send(peerMsg)
@@ -760,6 +933,18 @@ func (ship *Ship) findEligiblePlayersForFlags() map[*flag][]*Player {
 	return flagsAndEligiblePlayers
 }
 
+// sortByDistance sorts the given players by their distance from the given position, nearest
+// first. Players at the same distance are kept in a random order.
+func (ship *Ship) sortByDistance(players []*Player, pos Position) {
+	rand.Shuffle(len(players), func(i, j int) {
+		players[i], players[j] = players[j], players[i]
+	})
+
+	slices.SortStableFunc(players, func(a, b *Player) int {
+		return cmp.Compare(ship.pm.Map[a].DistSq(pos), ship.pm.Map[b].DistSq(pos))
+	})
+}
+
 // addPlayersToFlags locks as many players as possible to flags.
 // It then starts minigames for flags that have become ready.
 func (ship *Ship) addPlayersToFlags() error {
@@ -775,10 +960,14 @@ func (ship *Ship) addPlayersToFlags() error {
 	errs := make([]error, 0)
 
 	for f, players := range flagsAndEligiblePlayers {
+		// When a team has more players than the minigame needs, the players nearest the flag are
+		// the ones who take part.
+		ship.sortByDistance(players, f.pos)
+
 		// Keep adding players until no more are needed.
 		for len(players) > 0 {
-			// Select a random player to add.
-			p := players[rand.Int()%len(players)]
+			// Select the nearest remaining player to add.
+			p := players[0]
 
 			// Add the player to the flag.
 			errs = append(errs, ship.addPlayerToFlag(f, p))
@@ -809,6 +998,15 @@ func (ship *Ship) notifyMinigameStart(f *flag) error {
 
 	ship.logger().Info("notifying players of minigame start", zap.String("flag", flagID))
 
+	// The flag's owner, if it is playing, is defending the flag.
+	var defender *Team
+
+	for p := range f.activation.lockedPlayers {
+		if f.owner != nil && p.Team == f.owner {
+			defender = f.owner
+		}
+	}
+
 	joinErrs := make([]error, 0)
 
 	// Notify the players that will be taking part in the minigame that they are joining it.
@@ -828,6 +1026,10 @@ func (ship *Ship) notifyMinigameStart(f *flag) error {
 		_ = msgForPlayer.Add("flag_id", flagID)
 		_ = msgForPlayer.Add("peers", peerNames)
 
+		if defender != nil {
+			_ = msgForPlayer.Add("defending_team", defender.Index())
+		}
+
 		joinErrs = append(joinErrs, p.Client.Send(msgForPlayer))
 	}
 
@@ -867,8 +1069,20 @@ func (ship *Ship) startMinigameForFlag(f *flag) error {
 		p.Activity = f.minigame
 	}
 
+	// Tell the minigame which teams are playing it.
+	for _, team := range ship.lobby.Teams {
+		for p := range f.activation.lockedPlayers {
+			if p.Team == team {
+				f.minigame.teams = append(f.minigame.teams, team)
+				break
+			}
+		}
+	}
+
+	f.minigame.playerCount = len(f.activation.lockedPlayers)
+
 	// Clear the flag's activation state.
-	f.activation = nil
+	f.clearActivation()
 
 	// Start the minigame.
 	startErr := f.minigame.Start()
@@ -889,6 +1103,11 @@ func (ship *Ship) sendCooldownTick(p *Player, id string, left float64) error {
 // notifyCooldownTick reports the remaining cooldown time for the given flag to all players in
 // the ship.
 func (ship *Ship) notifyCooldownTick(flag *flag) error {
+	if !ship.fm.hasFlag(flag) {
+		// The flag was taken away, but a tick was already on its way.
+		return nil
+	}
+
 	return ship.ForAllShipPlayers(func(p *Player) error {
 		// We recalculate the remaining time for each message we have to send in case it takes
 		// a long time to send any of the messages.
@@ -901,6 +1120,10 @@ func (ship *Ship) notifyCooldownTick(flag *flag) error {
 // notifyCooldownEnd tells all players in the ship that the remaining time for the given flag's
 // cooldown is zero.
 func (ship *Ship) notifyCooldownEnd(flag *flag) error {
+	if !ship.fm.hasFlag(flag) {
+		return nil
+	}
+
 	return ship.ForAllShipPlayers(func(p *Player) error {
 		return ship.sendCooldownTick(p, ship.fm.idForFlag(flag), 0)
 	})
@@ -916,7 +1139,7 @@ func (ship *Ship) startCooldown(flag *flag) {
 	flag.cooldown = TickingTimer(
 		ship.Scheduler,
 
-		time.Now().Add(flag.minigameProto.Cooldown),
+		time.Now().Add(ship.cooldownFor(flag)),
 		cooldownTickInterval,
 
 		func() error {
@@ -935,10 +1158,12 @@ func (ship *Ship) notifyMinigameResult(flagID string, result MinigameResult) err
 	msg := NewMessage("ship_minigame_finished")
 	_ = msg.Add("flag_id", flagID)
 
-	if result.winningTeam != nil {
-		_ = msg.Add("winning_team", result.winningTeam.Index())
+	if winner := result.Winner(); winner != nil {
+		_ = msg.Add("winning_team", winner.Index())
 	}
 
+	_ = msg.Add("ranking", result.rankingIndices())
+
 	return ship.ForAllShipPlayers(func(p *Player) error {
 		return p.Client.Send(msg)
 	})
@@ -968,13 +1193,26 @@ func (ship *Ship) namedIndividualScores() map[string]float64 {
 // end notifies all players that the core has ended and puts them all back into a lobby activity.
 func (ship *Ship) end() error {
 	ship.logger().Info("ending ship stage")
+
+	// Work out the statistics while the bots are still here.
+	playerStats := ship.namedPlayerStats()
+	teamStats := ship.teamStatsList()
+	awards := ship.awards()
+
 	if ship.Recorder != nil { // Is recorder a not a nil pointer?
 		ship.Recorder.Timer.Stop() // Stop the timer, and write to db.
+		ship.recordStats(awards)
 		ship.Recorder.WriteToDB()
 	}
+
+	ship.lobby.ship = nil
+
+	// Bots only play in the ship, so they don't go back to the lobby.
+	botErr := ship.removeBots()
+
 	if ship.lobby.PlayerCount() == 0 {
 		// Nothing to do.
-		return nil
+		return botErr
 	}
 
 	// Create the message that we'll use to tell the players that the core has ended.
@@ -984,14 +1222,38 @@ func (ship *Ship) end() error {
 	// performances.
 	_ = endMsg.Add("individual_scores", ship.namedIndividualScores())
 
-	// Add the team scores so that the frontend can display those and the overall winner.
-	{
-		s0, s1 := ship.fm.teamScores()
+	// Add the team scores, in team index order, so that the frontend can display those and the
+	// overall winner.
+	_ = endMsg.Add("team_scores", ship.teamScores())
+
+	// Add the statistics and awards for the summary screen.
+	_ = endMsg.Add("player_stats", playerStats)
+	_ = endMsg.Add("team_stats", teamStats)
+	_ = endMsg.Add("awards", awards)
+
+	// Equal scores may have been split by a tie-break, so say who won and how.
+	winner, decidedBy := ship.winningTeam()
 
-		// Add as an array.
-		_ = endMsg.Add("team_scores", [2]int{s0, s1})
+	if winner != nil {
+		_ = endMsg.Add("winning_team", winner.Index())
+		_ = endMsg.Add("decided_by", decidedBy)
 	}
 
+	// Give out XP for the game before the profiles are stored with the new ratings.
+	progressErr := ship.awardGameProgress(winner)
+
+	// Update ratings from the result and report how each player's rating has moved.
+	rateErr := ship.rateGame(winner)
+
+	_ = endMsg.Add("rating_changes", ship.namedRatingChanges())
+	_ = endMsg.Add("progression", ship.namedProgression())
+
+	// Count this game towards the lobby's series and report the running series score.
+	ship.lobby.recordSeriesResult(winner)
+
+	_ = endMsg.Add("series_scores", ship.lobby.SeriesScores)
+	_ = endMsg.Add("series_games", ship.lobby.SeriesGames)
+
 	// Get the activity for the lobby that our players are in.
 	lobbyAct := ship.lobby.manager.GetActivity(ship.lobby.ID)
 
@@ -1003,13 +1265,16 @@ func (ship *Ship) end() error {
 		)
 	}
 
-	return ship.lobby.ForAllPlayers(func(p *Player) error {
+	endErr := ship.lobby.ForAllPlayers(func(p *Player) error {
 		// Move all the players back to the lobby activity. Any further messages will be handled by
 		// the lobby activity, not this ship activity.
 		p.Activity = lobbyAct
 
 		return p.Client.Send(endMsg)
 	})
+
+	// Give the players the chance to go again straight away.
+	return errors.Join(botErr, progressErr, rateErr, endErr, lobbyAct.beginPostGame())
 }
 
 // tryEnd ends the core if and only if there are no ongoing minigames. Otherwise, it does nothing.
@@ -1023,9 +1288,9 @@ func (ship *Ship) tryEnd() error {
 		return nil
 	}
 
-	// Sanity check.
+	// Sanity check. Players who joined the lobby during the endgame wait in the lobby activity.
 	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
-		if p.Activity != ship {
+		if p.Activity != ship && !p.InLobbyActivity() {
 			Logger.Panic(
 				"found a player outside of the ship, but there are no minigames ongoing",
 				zap.Any("player", p),
@@ -1035,6 +1300,13 @@ func (ship *Ship) tryEnd() error {
 		return nil
 	})
 
+	// A drawn game may go to sudden death instead of ending.
+	contenders, _ := ship.resolveTie()
+
+	if started, err := ship.trySuddenDeath(contenders); started {
+		return err
+	}
+
 	return ship.end()
 }
 
@@ -1094,8 +1366,8 @@ func (ship *Ship) triggerEndgame(nameOfLeaver *string) error {
 	return errors.Join(leaveErr, endgameErr)
 }
 
-// removePlayer removes the given player from the ship and the lobby. Other players will be notified
-// and the ship will enter the endgame state.
+// removePlayer removes the given player from the ship and the lobby. Other players will be
+// notified, and the lobby's disconnect policy decides whether the game carries on without them.
 //
 // This will panic if player.Activity is not the ship activity. This is intended to force the caller
 // to consider any cleanup that may be required if the player is in some non-ship activity.
@@ -1109,20 +1381,43 @@ func (ship *Ship) removePlayer(player *Player) error {
 		)
 	}
 
+	// Remember what the ship needs to carry on without the player.
+	team := player.Team
+	name := player.Name
+	rating := player.Rating()
+	pos := ship.pm.Map[player]
+
 	// Delete the player's position and score.
-	delete(ship.pm.Map, player)
+	ship.pm.Forget(player)
 	delete(ship.individualScores, player)
+	delete(ship.playerStats, player)
+	delete(ship.ratingChanges, player)
+	delete(ship.missedPings, player)
+	ship.pingLimiter.Forget(player)
+	delete(ship.inventories, player)
+
+	if boost, ok := ship.speedBoosts[player]; ok {
+		boost.Stop()
+		delete(ship.speedBoosts, player)
+	}
+
+	// The game may carry on, so the player mustn't be left waiting for a flag.
+	lockErr := ship.releaseLeavingPlayer(player)
 
 	// Whatever the player was doing before, they can't do it when they're not in the ship
 	// anymore...
 	player.Activity = nil
 
+	// The player has given up on the game, so they take a loss for it while their profile can
+	// still be reached.
+	rateErr := ship.rateLeaver(player)
+
 	// Remove from the lobby. Among other things, this removes the link between the Player and
 	// Client objects, making the Player object practically useless.
-	ship.lobby.RemovePlayer(player)
+	removeErr := ship.lobby.RemovePlayer(player)
 
-	// When a player disconnects, we should move into the endgame state.
-	return ship.triggerEndgame(&player.Name)
+	// The disconnect policy decides whether the game can carry on.
+	return errors.Join(lockErr, rateErr, removeErr, ship.handleDeparture(team, name, rating, pos))
 }
 
 // spreadPlayers places the given players evenly along an arc such that they are all `dist` away
@@ -1177,28 +1472,57 @@ func (ship *Ship) endMinigame(ctx *MinigameContext, result MinigameResult) error
 		zap.Any("result", result),
 	)
 
+	// The time spent getting ready doesn't count towards how long the minigame took.
+	played := ctx.playTime()
+
+	if ship.Recorder != nil {
+		ship.Recorder.MinigameRecord(ctx, result, played)
+	}
+
 	if !ship.isEndgame {
 		// Start the cooldown immediately.
 		ship.startCooldown(flag)
 	}
 
+	var captureErr error
+
+	// captured is true if and only if the winning team took the flag from another team or nobody.
+	captured := false
+
 	// Retain the winning team as the flag owner. If no winning team is given, the previous flag
 	// owner stays.
-	if result.winningTeam != nil {
-		flag.owner = result.winningTeam
+	if winner := result.Winner(); winner != nil {
+		previousOwner := flag.owner
+		captureErr = ship.captureFlag(flag, winner)
+		captured = flag.owner == winner && previousOwner != winner
+
+		if captured && ctx.hasRun() {
+			// A flag won because somebody left before the minigame started wasn't captured
+			// quickly.
+			ship.recordCapture(winner, played)
+		}
+
+		ship.teamStats[winner.Index()].minigamesWon++
 	}
 
 	// Announce the result to the players who are in the ship.
 	resultErr := ship.notifyMinigameResult(flagID, result)
 
+	rateErr := ship.rateMinigame(ctx, result)
+
 	flag.minigame = nil
 
 	var connectedParticipants []*Player
 
+	// granted maps winning players to the power-ups they have just won.
+	granted := make(map[*Player]powerUp)
+
 	// Find the fraction of the minigame's worth that gets added to each winning player's individual
 	// score.
 	individualWorth := flag.minigameProto.IndividualWorth()
 
+	var progressErrs []error
+
 	_ = ctx.ForAllPlayers(func(p *Player) error {
 		if p == result.disconnected {
 			// Player is no longer connected.
@@ -1207,10 +1531,23 @@ func (ship *Ship) endMinigame(ctx *MinigameContext, result MinigameResult) error
 
 		connectedParticipants = append(connectedParticipants, p)
 
-		if p.Team == result.winningTeam {
+		won := p.Team == result.Winner()
+		ship.recordMinigameStats(p, flag, played, won, won && captured)
+
+		progressErr := ship.progressMinigame(p, flag.minigameProto.Name, won, won && captured)
+		progressErrs = append(progressErrs, progressErr)
+
+		if p.Team == result.Winner() {
 			// While we're here, add this player's share of the minigame's token worth to their
 			// individual score.
 			ship.individualScores[p] += individualWorth
+
+			if !ship.isEndgame && flag != ship.suddenDeath {
+				// Power-ups can't be used in the endgame, so there's no point in giving them out.
+				if pu := ship.grantPowerUp(p); pu != "" {
+					granted[p] = pu
+				}
+			}
 		}
 
 		return nil
@@ -1234,31 +1571,73 @@ func (ship *Ship) endMinigame(ctx *MinigameContext, result MinigameResult) error
 		p.Activity = ship
 
 		wbErr = errors.Join(wbErr, ship.welcomePlayerBack(p))
+
+		if pu, ok := granted[p]; ok {
+			wbErr = errors.Join(wbErr, ship.notifyPowerUpGranted(p, pu))
+		}
 	}
 
 	// Make sure the minigame context can no longer be used.
 	ctx.invalidate()
 
+	var expireErr error
+
+	if flag.expired {
+		// The flag's bonus flag event ended while the minigame was going.
+		expireErr = ship.expireBonusFlag(flag)
+	}
+
 	// Try to end the ship stage if we're past the end of the game.
 	var endErr error
 
-	if ship.timer.HasEnded() {
+	if flag == ship.suddenDeath {
+		// Whoever wins sudden death wins the game.
+		ship.suddenDeathWinner = result.Winner()
+
+		if result.disconnected == nil {
+			endErr = ship.triggerEndgame(nil)
+		}
+	} else if ship.timer.HasEnded() {
 		endErr = ship.triggerEndgame(nil)
 	}
 
+	progressErr := errors.Join(progressErrs...)
+
 	if result.disconnected != nil {
 		// Move the player back into the ship so that removePlayer is satisfied that any appropriate
 		// cleanup has been performed.
 		result.disconnected.Activity = ship
 
 		// The minigame ended because a player disconnected. Remove that player from the ship.
-		return errors.Join(resultErr, wbErr, endErr, ship.removePlayer(result.disconnected))
+		removeErr := ship.removePlayer(result.disconnected)
+
+		// If the game carries on, the other participants may be needed elsewhere.
+		return errors.Join(
+			captureErr,
+			resultErr,
+			rateErr,
+			wbErr,
+			endErr,
+			expireErr,
+			progressErr,
+			removeErr,
+			ship.addPlayersToFlags(),
+		)
 	}
 
 	// Try to start other waiting minigames now that we have more players free.
 	gameErr := ship.addPlayersToFlags()
 
-	return errors.Join(resultErr, wbErr, endErr, gameErr)
+	return errors.Join(
+		captureErr,
+		resultErr,
+		rateErr,
+		wbErr,
+		endErr,
+		expireErr,
+		progressErr,
+		gameErr,
+	)
 }
 
 // findNearestFlag returns a pointer to the flag closest to the given player.
@@ -1309,17 +1688,30 @@ func (ship *Ship) activateFlag(player *Player, flag *flag) error {
 		flag.activation = &activation{
 			startTime:     time.Now(),
 			lockedPlayers: map[*Player]struct{}{player: {}},
+			teamCount:     1,
 		}
 
 		return ship.startMinigameForFlag(flag)
 	}
 
+	teamCount := flag.minigameProto.teamsPlaying(len(ship.lobby.Teams))
+
+	if flag.allowedTeams != nil {
+		teamCount = flag.minigameProto.teamsPlaying(len(flag.allowedTeams))
+	}
+
 	// Multiplayer core.
 	flag.activation = &activation{
-		startTime:     time.Now(),
-		lockedPlayers: make(map[*Player]struct{}),
+		startTime:      time.Now(),
+		lockedPlayers:  make(map[*Player]struct{}),
+		teamCount:      teamCount,
+		activatingTeam: player.Team,
+		declined:       make(map[*Player]struct{}),
 	}
 
+	// Don't let the locked players wait forever if nobody else turns up.
+	ship.startActivationTimeout(flag)
+
 	// Lock and notify.
 	lockErr := ship.addPlayerToFlag(flag, player)
 
@@ -1348,7 +1740,16 @@ func (ship *Ship) tryActivateFlag(player *Player) error {
 
 	id := ship.fm.idForFlag(flag)
 
-	if flag.owner == player.Team {
+	if ship.suddenDeath != nil && flag != ship.suddenDeath {
+		// Only the sudden-death flag can be played in sudden death.
+		return player.Client.Send(NewMessage("ship_flag_closed_in_sudden_death").Add("flag_id", id))
+	}
+
+	if !flag.allowsTeam(player.Team) {
+		return player.Client.Send(NewMessage("ship_flag_not_contending").Add("flag_id", id))
+	}
+
+	if flag.owner == player.Team && !ship.flagRules().defend {
 		return player.Client.Send(NewMessage("ship_flag_already_captured").Add("flag_id", id))
 	}
 
@@ -1364,6 +1765,10 @@ func (ship *Ship) tryActivateFlag(player *Player) error {
 		return player.Client.Send(NewMessage("ship_flag_already_activated").Add("flag_id", id))
 	}
 
+	if ship.lobby.manager.disabledMinigames[flag.minigameProto.Name] {
+		return player.Client.Send(NewMessage("ship_flag_minigame_disabled").Add("flag_id", id))
+	}
+
 	return ship.activateFlag(player, flag)
 }
 
@@ -1376,6 +1781,18 @@ func (ship *Ship) HandleMessage(player *Player, message *Message) error {
 		return ship.removePlayer(player)
 	}
 
+	if message.Type == "ship_ping" {
+		return ship.doPing(player, message)
+	}
+
+	if message.Type == "ship_flag_unlock" {
+		return ship.doFlagUnlock(player)
+	}
+
+	if message.Type == "ship_powerup_use" {
+		return ship.doPowerUpUse(player, message)
+	}
+
 	handled, err := ship.pm.HandleMessage(player, message)
 
 	if handled {
@@ -1395,3 +1812,14 @@ func (ship *Ship) ForAllShipPlayers(fn func(*Player) error) error {
 		return fn(p)
 	})
 }
+
+// scheduler returns the ship's scheduler, which the minigames played for its flags share.
+func (ship *Ship) scheduler() Scheduler {
+	return ship.Scheduler
+}
+
+// forAllPlayers calls fn for every player in the lobby, whether they are in the ship or in a
+// minigame.
+func (ship *Ship) forAllPlayers(fn func(*Player) error) error {
+	return ship.lobby.ForAllPlayers(fn)
+}
@@ -0,0 +1,304 @@
+package core
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// shipEventInterval is the time between the starts of consecutive ship events. The first event
+// starts this long after the game does.
+const shipEventInterval = 2 * time.Minute
+
+// shipEventDuration is the length of time for which each ship event lasts.
+const shipEventDuration = 60 * time.Second
+
+// bonusFlagClearance is the smallest distance between a bonus flag and any other flag.
+const bonusFlagClearance = 96.0
+
+// bonusFlagAttempts is the number of random positions tried when placing a bonus flag before
+// settling for one that is too close to another flag.
+const bonusFlagAttempts = 20
+
+// bonusFlagArea is the corner of the area, centred on the origin, within which bonus flags spawn.
+var bonusFlagArea = Position{X: 256, Y: 320}
+
+// A shipEventKind is a kind of event that can shake up the ship for a while during the game.
+type shipEventKind string
+
+const (
+	// eventDoubleWorth doubles the worth of flags captured while it lasts.
+	eventDoubleWorth shipEventKind = "double_worth"
+
+	// eventBonusFlag adds a flag at a random position, which is taken away when the event ends.
+	eventBonusFlag shipEventKind = "bonus_flag"
+
+	// eventHalfCooldowns halves the cooldowns of flags whose minigames finish while it lasts.
+	eventHalfCooldowns shipEventKind = "half_cooldowns"
+
+	// eventBlackout hides players from the other teams while it lasts.
+	eventBlackout shipEventKind = "blackout"
+)
+
+// shipEventKinds contains every kind of ship event.
+var shipEventKinds = []shipEventKind{
+	eventDoubleWorth,
+	eventBonusFlag,
+	eventHalfCooldowns,
+	eventBlackout,
+}
+
+// A shipEvent is an event that is currently affecting the ship.
+type shipEvent struct {
+	// kind is the kind of event.
+	kind shipEventKind
+
+	// ends is the time at which the event ends.
+	ends time.Time
+
+	// bonusFlag is the flag added by a bonus flag event, or nil for other events.
+	bonusFlag *flag
+}
+
+// hasEnded returns true if and only if the ship has finished and its players have gone back to the
+// lobby. Timers that outlive the ship use this to do nothing.
+func (ship *Ship) hasEnded() bool {
+	return ship.lobby.ship != ship
+}
+
+// eventActive returns true if and only if an event of the given kind is currently running.
+func (ship *Ship) eventActive(kind shipEventKind) bool {
+	return ship.activeEvent != nil && ship.activeEvent.kind == kind
+}
+
+// canSee returns true if and only if the viewer is allowed to know where the subject is.
+func (ship *Ship) canSee(viewer *Player, subject *Player) bool {
+	return !ship.eventActive(eventBlackout) || viewer.Team == subject.Team
+}
+
+// cooldownFor returns the length of the cooldown that the flag gets if its minigame finishes now.
+func (ship *Ship) cooldownFor(f *flag) time.Duration {
+	cooldown := ship.settings.Cooldown(&f.minigameProto)
+
+	if ship.eventActive(eventHalfCooldowns) {
+		return cooldown / 2
+	}
+
+	return cooldown
+}
+
+// eventBonusFor returns the number of extra tokens a flag with the given base worth is worth if it
+// is captured now.
+func (ship *Ship) eventBonusFor(worth int) int {
+	if ship.eventActive(eventDoubleWorth) {
+		return worth
+	}
+
+	return 0
+}
+
+// scheduleNextEvent sets a timer for the next ship event, as long as it can finish before the
+// ship timer runs out.
+func (ship *Ship) scheduleNextEvent(at time.Time) {
+	if !ship.settings.ShipEvents || at.Add(shipEventDuration).After(ship.timer.End) {
+		return
+	}
+
+	SingleTimer(ship.Scheduler, at, func() error {
+		if ship.hasEnded() {
+			return nil
+		}
+
+		ship.scheduleNextEvent(at.Add(shipEventInterval))
+
+		// Events would only get in the way of the endgame and sudden death.
+		if ship.isEndgame || ship.suddenDeath != nil {
+			return nil
+		}
+
+		return ship.startEvent(shipEventKinds[rand.Intn(len(shipEventKinds))])
+	})
+}
+
+// startEvent puts an event of the given kind into effect and announces it to the players.
+func (ship *Ship) startEvent(kind shipEventKind) error {
+	var bonusID string
+	var bonus *flag
+
+	if kind == eventBonusFlag {
+		if bonusID, bonus = ship.addBonusFlag(); bonus == nil {
+			// No minigame can be played with the players left, so do something else instead.
+			kind = eventDoubleWorth
+		}
+	}
+
+	ship.logger().Info("starting ship event", zap.String("event", string(kind)))
+
+	event := &shipEvent{kind: kind, ends: time.Now().Add(shipEventDuration), bonusFlag: bonus}
+	ship.activeEvent = event
+
+	if ship.Recorder != nil {
+		ship.Recorder.EventRecord(ship, string(kind), shipEventDuration.Seconds())
+	}
+
+	msg := NewMessage("ship_event_started")
+	_ = msg.Add("event", kind)
+	_ = msg.Add("seconds", shipEventDuration.Seconds())
+
+	if bonus != nil {
+		_ = msg.Add("flag_id", bonusID)
+		_ = msg.Add("flag", ship.flagInfo(bonus))
+	}
+
+	SingleTimer(ship.Scheduler, event.ends, func() error {
+		if ship.hasEnded() {
+			return nil
+		}
+
+		return ship.endEvent(event)
+	})
+
+	return ship.lobby.ForAllPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// endEvent takes the event's effects away and tells the players that it is over.
+func (ship *Ship) endEvent(event *shipEvent) error {
+	ship.logger().Info("ending ship event", zap.String("event", string(event.kind)))
+
+	if ship.activeEvent == event {
+		ship.activeEvent = nil
+	}
+
+	var flagErr error
+
+	if event.bonusFlag != nil {
+		flagErr = ship.expireBonusFlag(event.bonusFlag)
+	}
+
+	sendErr := ship.lobby.ForAllPlayers(func(p *Player) error {
+		msg := NewMessage("ship_event_ended").Add("event", event.kind)
+
+		if event.kind == eventBlackout && p.Activity == ship {
+			// Tell the player where everybody went while they couldn't see them.
+			positions := make(map[string]map[string]float64)
+
+			_ = p.ForAllShipPeers(func(peer *Player) error {
+				positions[peer.Name] = ship.pm.Map[peer].ToMap()
+				return nil
+			})
+
+			_ = msg.Add("positions", positions)
+		}
+
+		return p.Client.Send(msg)
+	})
+
+	return errors.Join(flagErr, sendErr)
+}
+
+// bonusFlagPosition returns a random position for a bonus flag, away from the other flags if
+// possible.
+func (ship *Ship) bonusFlagPosition() Position {
+	var pos Position
+
+	for i := 0; i < bonusFlagAttempts; i++ {
+		pos = Position{
+			X: (rand.Float64()*2 - 1) * bonusFlagArea.X,
+			Y: (rand.Float64()*2 - 1) * bonusFlagArea.Y,
+		}
+
+		spaced := true
+
+		for _, f := range ship.fm.flags {
+			if f.pos.DistSq(pos) < bonusFlagClearance*bonusFlagClearance {
+				spaced = false
+				break
+			}
+		}
+
+		if spaced {
+			break
+		}
+	}
+
+	return pos
+}
+
+// addBonusFlag adds a flag at a random position, using a random minigame from the lobby's pool
+// that the teams can play. It returns the new flag and its ID, or a nil flag if the teams can't
+// play any of the minigames.
+func (ship *Ship) addBonusFlag() (string, *flag) {
+	protos := ship.lobby.manager.enabledMinigames()
+	pool := ship.settings.playablePool(protos, ship.lobby.smallestTeamSize(), len(ship.lobby.Teams))
+
+	if len(pool) == 0 {
+		return "", nil
+	}
+
+	ship.bonusFlagCount++
+	id := "bonus" + strconv.Itoa(ship.bonusFlagCount)
+
+	ship.fm.addMinigameFlag(id, protos[pool[rand.Intn(len(pool))]], ship.bonusFlagPosition())
+
+	return id, ship.fm.flags[id]
+}
+
+// expireBonusFlag takes away a bonus flag at the end of its event. The team that owns it keeps the
+// tokens it is worth. If its minigame is still going, the flag is taken away when that finishes.
+func (ship *Ship) expireBonusFlag(f *flag) error {
+	if f.minigame != nil {
+		f.expired = true
+		return nil
+	}
+
+	id := ship.fm.idForFlag(f)
+
+	ship.logger().Info("removing bonus flag", zap.String("flag", id))
+
+	var errs []error
+
+	if f.isActivated() {
+		for p := range f.activation.lockedPlayers {
+			errs = append(errs, ship.notifyLockReleased(p, id, lockReleaseExpired))
+		}
+
+		f.clearActivation()
+	}
+
+	if f.owner != nil {
+		ship.bankedTokens[f.owner.Index()] += f.worth()
+	}
+
+	f.cooldown.Stop()
+	f.cooldown = ExpiredTimer()
+
+	delete(ship.fm.flags, id)
+
+	msg := NewMessage("ship_flag_removed").Add("flag_id", id)
+
+	errs = append(errs, ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	}))
+
+	// Players who were waiting for the flag may be needed elsewhere.
+	errs = append(errs, ship.addPlayersToFlags())
+
+	return errors.Join(errs...)
+}
+
+// eventState describes the running event for players who have missed its announcement, or returns
+// nil if there is no event running.
+func (ship *Ship) eventState() map[string]interface{} {
+	if ship.activeEvent == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"event":        ship.activeEvent.kind,
+		"seconds_left": max(time.Until(ship.activeEvent.ends), 0).Seconds(),
+	}
+}
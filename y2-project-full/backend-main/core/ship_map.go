@@ -0,0 +1,138 @@
+package core
+
+import (
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// defaultShipMap is the name of the map that lobbies use unless the host picks another.
+const defaultShipMap = "classic"
+
+// A flagSlot is a place on a ship map where a flag is put.
+type flagSlot struct {
+	// id is the ID given to the flag in this slot.
+	id string
+
+	// pos is the position of the flag.
+	pos Position
+
+	// minigame is the name of the minigame prototype that the flag uses if the lobby's minigame
+	// pool includes it.
+	minigame string
+}
+
+// A shipMap is a layout of flags in the ship.
+type shipMap struct {
+	// displayName is the human-readable name of the map.
+	displayName string
+
+	// slots contains the flag positions for the map.
+	slots []flagSlot
+
+	// suddenDeath is the position of the flag that is added if the game goes to sudden death.
+	suddenDeath Position
+
+	// rules are the optional flag mechanics that the map uses.
+	rules flagRules
+}
+
+// shipMaps maps the names of the maps that lobbies can choose from to the maps themselves.
+var shipMaps = map[string]shipMap{
+	"classic": {
+		displayName: "Classic",
+
+		slots: []flagSlot{
+			{id: "flag0", pos: Position{X: 0, Y: 0}, minigame: "shooter_3v3"},
+			{id: "flag1", pos: Position{X: -192, Y: 208}, minigame: "race_2v2"},
+			{id: "flag2", pos: Position{X: 192, Y: 208}, minigame: "card_match_sp"},
+			{id: "shush", pos: Position{X: 192, Y: 0}, minigame: "fb_sp"},
+			{id: "wam", pos: Position{X: -192, Y: 0}, minigame: "whack_a_mole"},
+			{id: "blah", pos: Position{X: 0, Y: 256}, minigame: "rps_1v1"},
+			{id: "dmspt", pos: Position{X: 0, Y: -256}, minigame: "shooter_1v1"},
+			{id: "idfk", pos: Position{X: 192, Y: -208}, minigame: "cps_race_sp"},
+			{id: "idfk_", pos: Position{X: -192, Y: -208}, minigame: "cps_race_1v1"},
+		},
+
+		suddenDeath: Position{X: 0, Y: 128},
+	},
+
+	// The duel map only uses the flags down the middle of the ship, which makes for short, busy
+	// games.
+	"duel": {
+		displayName: "Duel",
+
+		slots: []flagSlot{
+			{id: "flag0", pos: Position{X: 0, Y: 0}, minigame: "shooter_1v1"},
+			{id: "blah", pos: Position{X: 0, Y: 256}, minigame: "rps_1v1"},
+			{id: "dmspt", pos: Position{X: 0, Y: -256}, minigame: "cps_race_1v1"},
+		},
+
+		suddenDeath: Position{X: 0, Y: 128},
+	},
+
+	// On the siege map, flags have to be held rather than just taken: they pay out for as long as
+	// they are held, but slip back to neutral unless their owners defend them.
+	"siege": {
+		displayName: "Siege",
+
+		slots: []flagSlot{
+			{id: "flag0", pos: Position{X: 0, Y: 0}, minigame: "shooter_3v3"},
+			{id: "wam", pos: Position{X: -192, Y: 0}, minigame: "whack_a_mole"},
+			{id: "shush", pos: Position{X: 192, Y: 0}, minigame: "fb_sp"},
+			{id: "blah", pos: Position{X: 0, Y: 256}, minigame: "rps_1v1"},
+			{id: "dmspt", pos: Position{X: 0, Y: -256}, minigame: "shooter_1v1"},
+		},
+
+		suddenDeath: Position{X: 0, Y: 128},
+
+		rules: flagRules{
+			decayAfter: 90 * time.Second,
+			defend:     true,
+			holdIncome: 1,
+		},
+	},
+}
+
+// minigameNames returns the names of the minigames that the map uses by default.
+func (m *shipMap) minigameNames() []string {
+	names := make([]string, 0, len(m.slots))
+
+	for _, slot := range m.slots {
+		if !slices.Contains(names, slot.minigame) {
+			names = append(names, slot.minigame)
+		}
+	}
+
+	return names
+}
+
+// assignMinigames returns the name of the minigame to use for each slot of the map, in slot order.
+// Slots whose usual minigame is not in the pool are given minigames from the pool instead, taking
+// each pool member in turn (from a random starting point) so that the replacements are varied. If
+// the pool is empty, every slot keeps its usual minigame.
+func (m *shipMap) assignMinigames(pool []string) []string {
+	assigned := make([]string, len(m.slots))
+
+	// Sort so that the rotation doesn't depend on the order in which the pool was given.
+	sorted := slices.Clone(pool)
+	slices.Sort(sorted)
+
+	next := 0
+
+	if len(sorted) > 0 {
+		next = rand.Intn(len(sorted))
+	}
+
+	for i, slot := range m.slots {
+		if len(sorted) == 0 || slices.Contains(pool, slot.minigame) {
+			assigned[i] = slot.minigame
+			continue
+		}
+
+		assigned[i] = sorted[next]
+		next = (next + 1) % len(sorted)
+	}
+
+	return assigned
+}
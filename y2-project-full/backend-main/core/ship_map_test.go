@@ -0,0 +1,79 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAssignMinigames(t *testing.T) {
+	m := &shipMap{
+		slots: []flagSlot{
+			{id: "a", minigame: "rps_1v1"},
+			{id: "b", minigame: "shooter_1v1"},
+			{id: "c", minigame: "cps_race_1v1"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		pool []string
+
+		// allowed lists the minigames that each slot may be given.
+		allowed [][]string
+	}{
+		{
+			name:    "whole map in pool",
+			pool:    []string{"cps_race_1v1", "rps_1v1", "shooter_1v1"},
+			allowed: [][]string{{"rps_1v1"}, {"shooter_1v1"}, {"cps_race_1v1"}},
+		},
+		{
+			name:    "some slots replaced",
+			pool:    []string{"rps_1v1", "fb_sp"},
+			allowed: [][]string{{"rps_1v1"}, {"rps_1v1", "fb_sp"}, {"rps_1v1", "fb_sp"}},
+		},
+		{
+			name:    "no slots in pool",
+			pool:    []string{"fb_sp"},
+			allowed: [][]string{{"fb_sp"}, {"fb_sp"}, {"fb_sp"}},
+		},
+		{
+			name:    "empty pool keeps the map's minigames",
+			pool:    nil,
+			allowed: [][]string{{"rps_1v1"}, {"shooter_1v1"}, {"cps_race_1v1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The replacements start from a random point, so try a few times.
+			for i := 0; i < 20; i++ {
+				got := m.assignMinigames(tt.pool)
+
+				if len(got) != len(m.slots) {
+					t.Fatalf("got %d minigames, want %d", len(got), len(m.slots))
+				}
+
+				for slot, name := range got {
+					if !slices.Contains(tt.allowed[slot], name) {
+						t.Fatalf("slot %d got %q, want one of %v", slot, name, tt.allowed[slot])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAssignMinigamesVariesReplacements(t *testing.T) {
+	m := &shipMap{
+		slots: []flagSlot{
+			{id: "a", minigame: "x"},
+			{id: "b", minigame: "y"},
+		},
+	}
+
+	got := m.assignMinigames([]string{"p", "q"})
+
+	if got[0] == got[1] {
+		t.Errorf("both replaced slots got %q, want different minigames", got[0])
+	}
+}
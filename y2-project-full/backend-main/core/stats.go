@@ -0,0 +1,272 @@
+package core
+
+import (
+	"time"
+)
+
+// An award is a title given at the end of the game to the player who did best at something.
+type award string
+
+const (
+	// awardMVP goes to the player with the highest individual score.
+	awardMVP award = "mvp"
+
+	// awardMostFlags goes to the player who helped capture the most flags.
+	awardMostFlags award = "most_flags"
+
+	// awardMarathonRunner goes to the player who walked the furthest around the ship.
+	awardMarathonRunner award = "marathon_runner"
+)
+
+// A minigameTally counts the minigames of one kind that a player has played.
+type minigameTally struct {
+	// played is the number of minigames the player took part in.
+	played int
+
+	// won is the number of those minigames that the player's team won.
+	won int
+}
+
+// playerStats holds the statistics collected about a player during the game.
+type playerStats struct {
+	// minigames maps minigame prototype names to the player's tally for that minigame.
+	minigames map[string]*minigameTally
+
+	// flagsCaptured is the number of flags the player helped capture.
+	flagsCaptured int
+
+	// minigameTime is the total time the player has spent in minigames.
+	minigameTime time.Duration
+
+	// entered is the time at which the player entered the ship.
+	entered time.Time
+}
+
+// teamStats holds the statistics collected about a team during the game.
+type teamStats struct {
+	// flagsCaptured is the number of times the team took a flag from another team or from nobody.
+	flagsCaptured int
+
+	// minigamesWon is the number of minigames the team won.
+	minigamesWon int
+}
+
+// startStats begins collecting statistics for a player who has just entered the ship.
+func (ship *Ship) startStats(p *Player) {
+	ship.playerStats[p] = &playerStats{
+		minigames: make(map[string]*minigameTally),
+		entered:   time.Now(),
+	}
+}
+
+// recordMinigameStats adds a finished minigame, which was played for the given time, to a
+// participant's statistics.
+func (ship *Ship) recordMinigameStats(
+	p *Player,
+	f *flag,
+	played time.Duration,
+	won bool,
+	captured bool,
+) {
+	stats, ok := ship.playerStats[p]
+
+	if !ok {
+		return
+	}
+
+	name := f.minigameProto.Name
+	tally, ok := stats.minigames[name]
+
+	if !ok {
+		tally = &minigameTally{}
+		stats.minigames[name] = tally
+	}
+
+	tally.played++
+	stats.minigameTime += played
+
+	if won {
+		tally.won++
+	}
+
+	if captured {
+		stats.flagsCaptured++
+	}
+}
+
+// totals returns the number of minigames the player has played and won across all minigames.
+func (stats *playerStats) totals() (played int, won int) {
+	for _, tally := range stats.minigames {
+		played += tally.played
+		won += tally.won
+	}
+
+	return played, won
+}
+
+// playerStatsMap returns the player's statistics in the form used in messages.
+func (ship *Ship) playerStatsMap(p *Player) map[string]interface{} {
+	stats := ship.playerStats[p]
+
+	perMinigame := make(map[string]map[string]int)
+
+	for name, tally := range stats.minigames {
+		perMinigame[name] = map[string]int{"played": tally.played, "won": tally.won}
+	}
+
+	played, won := stats.totals()
+	inGame := time.Since(stats.entered)
+
+	return map[string]interface{}{
+		"minigames":        perMinigame,
+		"minigames_played": played,
+		"minigames_won":    won,
+		"tokens":           ship.individualScores[p],
+		"distance":         ship.pm.Distance[p],
+		"minigame_seconds": stats.minigameTime.Seconds(),
+		"ship_seconds":     (inGame - stats.minigameTime).Seconds(),
+		"flags_captured":   stats.flagsCaptured,
+	}
+}
+
+// statPlayers returns the players who have statistics for this game, which is everybody in the
+// ship.
+func (ship *Ship) statPlayers() []*Player {
+	players := make([]*Player, 0, len(ship.playerStats))
+
+	_ = ship.lobby.ForAllPlayers(func(p *Player) error {
+		if _, ok := ship.playerStats[p]; ok {
+			players = append(players, p)
+		}
+
+		return nil
+	})
+
+	return players
+}
+
+// namedPlayerStats returns a map which maps players' names to their statistics.
+func (ship *Ship) namedPlayerStats() map[string]map[string]interface{} {
+	m := make(map[string]map[string]interface{})
+
+	for _, p := range ship.statPlayers() {
+		m[p.Name] = ship.playerStatsMap(p)
+	}
+
+	return m
+}
+
+// teamStatsList returns each team's statistics in team index order.
+func (ship *Ship) teamStatsList() []map[string]interface{} {
+	scores := ship.teamScores()
+	list := make([]map[string]interface{}, 0, len(ship.lobby.Teams))
+
+	for i, team := range ship.lobby.Teams {
+		held := 0
+
+		for _, f := range ship.fm.flags {
+			if f.owner == team {
+				held++
+			}
+		}
+
+		distance := 0.0
+
+		for _, p := range ship.statPlayers() {
+			if p.Team == team {
+				distance += ship.pm.Distance[p]
+			}
+		}
+
+		list = append(list, map[string]interface{}{
+			"tokens":         scores[i],
+			"flags_held":     held,
+			"flags_captured": ship.teamStats[i].flagsCaptured,
+			"minigames_won":  ship.teamStats[i].minigamesWon,
+			"distance":       distance,
+		})
+	}
+
+	return list
+}
+
+// awardWinner returns the player with the highest value above zero for the given measure, and that
+// value. Ties go to the player whose name comes first. It returns nil if nobody scored above zero.
+func (ship *Ship) awardWinner(measure func(*Player) float64) (*Player, float64) {
+	var best *Player
+	bestValue := 0.0
+
+	for _, p := range ship.statPlayers() {
+		if p.IsBot() {
+			continue
+		}
+
+		v := measure(p)
+
+		if v > bestValue || (v == bestValue && best != nil && p.Name < best.Name) {
+			best = p
+			bestValue = v
+		}
+	}
+
+	return best, bestValue
+}
+
+// awards works out who has earned each award. Awards that nobody has earned are left out.
+func (ship *Ship) awards() map[award]map[string]interface{} {
+	measures := map[award]func(*Player) float64{
+		awardMVP: func(p *Player) float64 {
+			return ship.individualScores[p]
+		},
+		awardMostFlags: func(p *Player) float64 {
+			return float64(ship.playerStats[p].flagsCaptured)
+		},
+		awardMarathonRunner: func(p *Player) float64 {
+			return ship.pm.Distance[p]
+		},
+	}
+
+	m := make(map[award]map[string]interface{})
+
+	for a, measure := range measures {
+		if winner, value := ship.awardWinner(measure); winner != nil {
+			m[a] = map[string]interface{}{"name": winner.Name, "value": value}
+		}
+	}
+
+	return m
+}
+
+// recordStats passes the end-of-game statistics and awards to the recorder.
+func (ship *Ship) recordStats(awards map[award]map[string]interface{}) {
+	lines := make([]PlayerStatsLine, 0, len(ship.playerStats))
+
+	for _, p := range ship.statPlayers() {
+		stats := ship.playerStats[p]
+		played, won := stats.totals()
+
+		lines = append(lines, PlayerStatsLine{
+			Username:        p.Name,
+			Team:            p.Team.Index(),
+			Tokens:          ship.individualScores[p],
+			Distance:        ship.pm.Distance[p],
+			MinigameTime:    stats.minigameTime.Seconds(),
+			ShipTime:        (time.Since(stats.entered) - stats.minigameTime).Seconds(),
+			FlagsCaptured:   stats.flagsCaptured,
+			MinigamesPlayed: played,
+			MinigamesWon:    won,
+		})
+	}
+
+	awardLines := make([]AwardLine, 0, len(awards))
+
+	for a, info := range awards {
+		awardLines = append(awardLines, AwardLine{
+			Award:    string(a),
+			Username: info["name"].(string),
+			Value:    info["value"].(float64),
+		})
+	}
+
+	ship.Recorder.StatsRecord(lines, awardLines)
+}
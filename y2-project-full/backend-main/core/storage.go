@@ -0,0 +1,365 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// profileIDBytes is the number of random bytes in a profile ID. Profile IDs are the only thing a
+// guest needs to reclaim their profile, so they must be impossible to guess.
+const profileIDBytes = 16
+
+// A Profile is the persistent identity of a player. Unlike a Player, a profile outlives the
+// connection and the lobby.
+type Profile struct {
+	// ID is the secret identifier that the frontend uses to reclaim the profile.
+	ID string `json:"id"`
+
+	// Rating is the player's skill rating.
+	Rating float64 `json:"rating"`
+
+	// GamesPlayed is the number of ship games that the player has finished.
+	GamesPlayed int `json:"games_played"`
+
+	// XP is the experience the player has earned, which decides their level.
+	XP int `json:"xp"`
+
+	// Achievements maps the IDs of the achievements the player has unlocked to when they were
+	// unlocked.
+	Achievements map[string]time.Time `json:"achievements,omitempty"`
+
+	// Progress maps the IDs of achievements that the player has not unlocked yet to how many times
+	// they have done what the achievement asks.
+	Progress map[string]int `json:"progress,omitempty"`
+
+	// WinStreak is the number of minigames in a row that the player's team has won.
+	WinStreak int `json:"win_streak"`
+
+	// Account is the username of the account that owns the profile, or empty if the profile
+	// belongs to a guest. A profile that belongs to an account can only be used by logging in.
+	Account string `json:"account,omitempty"`
+}
+
+// newProfile returns a pointer to a new profile with a random ID and the starting rating.
+func newProfile() (*Profile, error) {
+	id := make([]byte, profileIDBytes)
+
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Profile{
+		ID:          hex.EncodeToString(id),
+		Rating:      initialRating,
+		GamesPlayed: 0,
+	}, nil
+}
+
+// worthKeeping returns true if and only if the profile holds anything that would be lost if it
+// were thrown away. Guests' profiles are only stored once they are worth keeping, so that guests
+// who never finish anything don't fill the storage.
+func (p *Profile) worthKeeping() bool {
+	return p.Account != "" ||
+		p.GamesPlayed > 0 ||
+		p.XP > 0 ||
+		p.Rating != initialRating ||
+		p.WinStreak > 0 ||
+		len(p.Achievements) > 0 ||
+		len(p.Progress) > 0
+}
+
+// A Storage keeps player profiles and accounts between connections.
+//
+// Storage methods are only called from the main thread.
+type Storage interface {
+	// LoadProfile returns a copy of the profile with the given ID, or nil if there is no such
+	// profile.
+	LoadProfile(id string) (*Profile, error)
+
+	// SaveProfile stores a copy of the given profile, replacing any profile with the same ID.
+	SaveProfile(profile *Profile) error
+
+	// LoadAccount returns a copy of the account with the given username, or nil if there is no
+	// such account. Usernames are compared without regard to case.
+	LoadAccount(username string) (*Account, error)
+
+	// SaveAccount stores a copy of the given account, replacing any account with the same
+	// username.
+	SaveAccount(account *Account) error
+}
+
+// A memoryStorage keeps profiles and accounts in memory. Everything is lost when the server stops.
+type memoryStorage struct {
+	// profiles maps profile IDs to profiles.
+	profiles map[string]Profile
+
+	// accounts maps account keys to accounts.
+	accounts map[string]Account
+}
+
+// newMemoryStorage returns a pointer to a new empty memory storage.
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		profiles: make(map[string]Profile),
+		accounts: make(map[string]Account),
+	}
+}
+
+func (s *memoryStorage) LoadProfile(id string) (*Profile, error) {
+	profile, ok := s.profiles[id]
+
+	if !ok {
+		return nil, nil
+	}
+
+	// Don't share the achievement maps with the stored copy.
+	profile.Achievements = maps.Clone(profile.Achievements)
+	profile.Progress = maps.Clone(profile.Progress)
+
+	return &profile, nil
+}
+
+func (s *memoryStorage) SaveProfile(profile *Profile) error {
+	stored := *profile
+	stored.Achievements = maps.Clone(profile.Achievements)
+	stored.Progress = maps.Clone(profile.Progress)
+
+	s.profiles[profile.ID] = stored
+
+	return nil
+}
+
+func (s *memoryStorage) LoadAccount(username string) (*Account, error) {
+	account, ok := s.accounts[accountKey(username)]
+
+	if !ok {
+		return nil, nil
+	}
+
+	// Don't share the token list with the stored copy.
+	account.DeviceTokens = slices.Clone(account.DeviceTokens)
+
+	return &account, nil
+}
+
+func (s *memoryStorage) SaveAccount(account *Account) error {
+	stored := *account
+	stored.DeviceTokens = slices.Clone(account.DeviceTokens)
+
+	s.accounts[accountKey(account.Username)] = stored
+
+	return nil
+}
+
+// A scheduledStorage is a Storage which needs the hub's scheduler before it can be used.
+type scheduledStorage interface {
+	// setScheduler gives the storage the scheduler for the main thread.
+	setScheduler(scheduler Scheduler)
+}
+
+// A fileStorage keeps profiles and accounts in memory and writes all of them to a JSON file
+// whenever any change. Changes made while handling one event are written together once the event
+// has been handled, and the file is written by a separate goroutine so that the main thread never
+// waits for the disk.
+type fileStorage struct {
+	// memoryStorage holds the current contents of the file.
+	memoryStorage
+
+	// path is the path of the JSON file.
+	path string
+
+	// scheduler is used to take a snapshot of the storage on the main thread once the current
+	// event has been handled.
+	scheduler Scheduler
+
+	// dirty is true if and only if there are changes which haven't been snapshotted yet.
+	dirty bool
+
+	// snapshotLock protects snapshot, which is shared with the writer goroutine.
+	snapshotLock sync.Mutex
+
+	// snapshot is the latest contents of the storage that haven't been written yet, or nil if
+	// they all have. Snapshots that are replaced before they are written are never written.
+	snapshot *fileContents
+
+	// wake tells the writer goroutine that there is a snapshot to write.
+	wake chan struct{}
+}
+
+// fileContents is the structure of the JSON file written by a file storage.
+type fileContents struct {
+	// Profiles maps profile IDs to profiles.
+	Profiles map[string]Profile `json:"profiles"`
+
+	// Accounts maps account keys to accounts.
+	Accounts map[string]Account `json:"accounts"`
+}
+
+// newFileStorage returns a pointer to a file storage which keeps its data at the given path. Any
+// data already in the file is loaded. A missing file is treated as empty.
+func newFileStorage(path string) (*fileStorage, error) {
+	s := &fileStorage{
+		memoryStorage: *newMemoryStorage(),
+		path:          path,
+		wake:          make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		go s.runWriter()
+
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	contents := fileContents{Profiles: s.profiles, Accounts: s.accounts}
+
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, err
+	}
+
+	if contents.Profiles != nil {
+		s.profiles = contents.Profiles
+	}
+
+	if contents.Accounts != nil {
+		s.accounts = contents.Accounts
+	}
+
+	go s.runWriter()
+
+	return s, nil
+}
+
+func (s *fileStorage) setScheduler(scheduler Scheduler) {
+	s.scheduler = scheduler
+}
+
+// markDirty arranges for the storage to be written once the current event has been handled.
+func (s *fileStorage) markDirty() {
+	if s.dirty {
+		// A snapshot has already been asked for, and it will include this change.
+		return
+	}
+
+	s.dirty = true
+
+	// The main thread can't wait for its own event queue, so queue the snapshot from elsewhere.
+	go s.scheduler.Add(func() error {
+		s.takeSnapshot()
+		return nil
+	})
+}
+
+// takeSnapshot copies the contents of the storage for the writer goroutine and wakes it up.
+//
+// Stored profiles and accounts are replaced rather than changed, so copying the maps is enough to
+// keep the snapshot from changing under the writer.
+func (s *fileStorage) takeSnapshot() {
+	s.dirty = false
+
+	contents := &fileContents{Profiles: maps.Clone(s.profiles), Accounts: maps.Clone(s.accounts)}
+
+	s.snapshotLock.Lock()
+	s.snapshot = contents
+	s.snapshotLock.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// The writer has already been woken and will pick up the new snapshot.
+	}
+}
+
+// runWriter writes snapshots to the file as they are taken, forever.
+func (s *fileStorage) runWriter() {
+	for range s.wake {
+		s.snapshotLock.Lock()
+		contents := s.snapshot
+		s.snapshot = nil
+		s.snapshotLock.Unlock()
+
+		if contents == nil {
+			continue
+		}
+
+		if err := s.write(contents); err != nil {
+			Logger.Error("failed to write storage file", zap.String("path", s.path), zap.Error(err))
+		}
+	}
+}
+
+// write replaces the file with the given contents. The data is written to a temporary file first
+// so that a crash part way through can't leave a half-written file behind.
+func (s *fileStorage) write(contents *fileContents) error {
+	data, err := json.Marshal(contents)
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *fileStorage) SaveProfile(profile *Profile) error {
+	_ = s.memoryStorage.SaveProfile(profile)
+	s.markDirty()
+
+	return nil
+}
+
+func (s *fileStorage) SaveAccount(account *Account) error {
+	_ = s.memoryStorage.SaveAccount(account)
+	s.markDirty()
+
+	return nil
+}
+
+// StorageFromArgs returns the storage given by the `--storage-file` command-line flag. Profiles and
+// accounts are kept in the named JSON file if the flag is given, or only in memory otherwise. The
+// server exits if the file can't be read.
+func StorageFromArgs() Storage {
+	path := argValue("--storage-file")
+
+	if path == nil {
+		Logger.Info("profiles and accounts will not be kept after the server stops")
+
+		return newMemoryStorage()
+	}
+
+	s, err := newFileStorage(*path)
+
+	if err != nil {
+		Logger.Fatal("failed to load storage file", zap.String("path", *path), zap.Error(err))
+	}
+
+	return s
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfileWorthKeeping(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		want    bool
+	}{
+		{"new guest", Profile{Rating: initialRating}, false},
+		{"account", Profile{Rating: initialRating, Account: "alice"}, true},
+		{"finished a game", Profile{Rating: initialRating, GamesPlayed: 1}, true},
+		{"earned xp", Profile{Rating: initialRating, XP: 5}, true},
+		{"rated", Profile{Rating: initialRating + 8}, true},
+		{"on a streak", Profile{Rating: initialRating, WinStreak: 1}, true},
+		{"making progress", Profile{Rating: initialRating, Progress: map[string]int{"a": 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.worthKeeping(); got != tt.want {
+				t.Errorf("worthKeeping() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStorageBatchesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s, err := newFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan func() error, 10)
+	s.setScheduler(Scheduler{event: events})
+
+	// Several saves while handling one event.
+	for _, id := range []string{"a", "b", "c"} {
+		_ = s.SaveProfile(&Profile{ID: id, Rating: initialRating})
+	}
+
+	// Only one snapshot should have been asked for.
+	snapshot := <-events
+	_ = snapshot()
+
+	select {
+	case <-events:
+		t.Fatal("more than one snapshot was scheduled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var contents fileContents
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for len(contents.Profiles) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("file has %d profiles, want 3", len(contents.Profiles))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &contents)
+		}
+	}
+
+	loaded, err := newFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ := loaded.LoadProfile("b"); p == nil {
+		t.Error("profile b was not loaded back from the file")
+	}
+}
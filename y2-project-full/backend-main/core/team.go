@@ -6,7 +6,8 @@ import (
 	"math/rand"
 )
 
-// A Team is three players who work together.
+// A Team is a group of players who work together. The lobby settings limit how many players a
+// team can have.
 type Team struct {
 	// Lobby is a pointer to the lobby that this team is in.
 	Lobby *Lobby
@@ -46,24 +47,26 @@ func (team *Team) RemovePlayer(player *Player) {
 	player.Team = nil
 }
 
-// OpposingTeam returns a pointer to the team that this team is against.
-func (team *Team) OpposingTeam() *Team {
-	if team.Lobby.Teams[0] == team {
-		return team.Lobby.Teams[1]
+// Opponents returns pointers to every other team in the lobby, in index order.
+func (team *Team) Opponents() []*Team {
+	var opponents []*Team
+
+	for _, other := range team.Lobby.Teams {
+		if other != team {
+			opponents = append(opponents, other)
+		}
 	}
 
-	return team.Lobby.Teams[0]
+	return opponents
 }
 
 // Index returns the index of this team in its lobby.
-// It panics if the lobby is neither index 0 nor 1.
+// It panics if the team is not in its lobby's team list.
 func (team *Team) Index() uint8 {
-	if team.Lobby.Teams[0] == team {
-		return 0
-	}
-
-	if team.Lobby.Teams[1] == team {
-		return 1
+	for i, t := range team.Lobby.Teams {
+		if t == team {
+			return uint8(i)
+		}
 	}
 
 	Logger.Panic("team is not in correct lobby")
@@ -72,6 +75,11 @@ func (team *Team) Index() uint8 {
 	return 0
 }
 
+// IsFull returns true if and only if the team has as many players as the lobby settings allow.
+func (team *Team) IsFull() bool {
+	return len(team.Players) >= team.Lobby.Settings.TeamSize
+}
+
 // ForAllMembers calls fn for every member of this team.
 func (team *Team) ForAllMembers(fn func(*Player) error) error {
 	errs := make([]error, 0)
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"go.uber.org/zap"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// suddenDeathDuration is the length of time for which the sudden-death flag can be played. If
+// nobody has won it by then, the game ends as a draw.
+const suddenDeathDuration = 2 * time.Minute
+
+// suddenDeathFlagID is the ID of the flag that is added for sudden death.
+const suddenDeathFlagID = "sudden_death"
+
+// A tieBreak is a rule that chooses between teams which have the same number of tokens at the end
+// of the game.
+type tieBreak string
+
+const (
+	// tieBreakMVP favours the team whose best player has the highest individual score.
+	tieBreakMVP tieBreak = "mvp"
+
+	// tieBreakFlagsHeld favours the team which owns the most flags.
+	tieBreakFlagsHeld tieBreak = "flags_held"
+
+	// tieBreakFastestCapture favours the team which captured a flag in the shortest minigame.
+	tieBreakFastestCapture tieBreak = "fastest_capture"
+)
+
+// tieBreaks contains every tie-break rule, in the order that new lobbies use them.
+var tieBreaks = []tieBreak{tieBreakMVP, tieBreakFlagsHeld, tieBreakFastestCapture}
+
+const (
+	// decidedByScore means that the winner had the most tokens.
+	decidedByScore = "score"
+
+	// decidedBySuddenDeath means that the winner won the sudden-death minigame.
+	decidedBySuddenDeath = "sudden_death"
+)
+
+// leaders returns the teams with the highest value for the given score.
+func leaders(teams []*Team, score func(*Team) float64) []*Team {
+	best := math.Inf(-1)
+	var top []*Team
+
+	for _, team := range teams {
+		s := score(team)
+
+		if s > best {
+			best = s
+			top = top[:0]
+		}
+
+		if s == best {
+			top = append(top, team)
+		}
+	}
+
+	return top
+}
+
+// tieBreakScore returns the given team's value for the tie-break rule. Higher values are better.
+func (ship *Ship) tieBreakScore(rule tieBreak, team *Team) float64 {
+	switch rule {
+	case tieBreakMVP:
+		best := 0.0
+
+		_ = team.ForAllMembers(func(p *Player) error {
+			best = max(best, ship.individualScores[p])
+			return nil
+		})
+
+		return best
+
+	case tieBreakFlagsHeld:
+		held := 0
+
+		for _, f := range ship.fm.flags {
+			if f.owner == team {
+				held++
+			}
+		}
+
+		return float64(held)
+
+	case tieBreakFastestCapture:
+		fastest, ok := ship.fastestCaptures[team]
+
+		if !ok {
+			// Teams that never captured anything can't win on this rule.
+			return math.Inf(-1)
+		}
+
+		return -fastest.Seconds()
+	}
+
+	Logger.Panic("unknown tie-break rule", zap.String("rule", string(rule)))
+
+	// Unreachable
+	return 0
+}
+
+// resolveTie finds the teams that are still in contention for the win once the team scores and
+// the lobby's tie-break rules have been applied. If only one team is left, the reason it won is
+// returned too. Once sudden death has been played, only its result counts: if nobody won it, the
+// teams that played it are left tied.
+func (ship *Ship) resolveTie() (contenders []*Team, decidedBy string) {
+	if ship.suddenDeathWinner != nil {
+		return []*Team{ship.suddenDeathWinner}, decidedBySuddenDeath
+	}
+
+	if ship.suddenDeath != nil {
+		for _, team := range ship.lobby.Teams {
+			if ship.suddenDeath.allowsTeam(team) {
+				contenders = append(contenders, team)
+			}
+		}
+
+		return contenders, ""
+	}
+
+	scores := ship.teamScores()
+
+	contenders = leaders(ship.lobby.Teams, func(team *Team) float64 {
+		return float64(scores[team.Index()])
+	})
+
+	if len(contenders) == 1 {
+		return contenders, decidedByScore
+	}
+
+	for _, rule := range ship.settings.TieBreaks {
+		contenders = leaders(contenders, func(team *Team) float64 {
+			return ship.tieBreakScore(rule, team)
+		})
+
+		if len(contenders) == 1 {
+			return contenders, string(rule)
+		}
+	}
+
+	return contenders, ""
+}
+
+// winningTeam returns the team that has won the game and the reason it won, or nil if the game is
+// a draw.
+func (ship *Ship) winningTeam() (*Team, string) {
+	contenders, decidedBy := ship.resolveTie()
+
+	if len(contenders) != 1 {
+		return nil, ""
+	}
+
+	return contenders[0], decidedBy
+}
+
+// finalPlace returns a function giving the place of each team at the end of the game. Teams are
+// placed by score, except that a winner chosen by a tie-break is placed ahead of the teams it was
+// tied with, and teams that drew sudden death share the best place among them.
+func (ship *Ship) finalPlace(winner *Team) func(*Team) int {
+	scores := ship.teamScores()
+
+	place := scorePlace(ship.lobby.Teams, func(team *Team) float64 {
+		return float64(scores[team.Index()])
+	})
+
+	return func(team *Team) int {
+		if winner == nil && ship.suddenDeath != nil && ship.suddenDeath.allowsTeam(team) {
+			best := place(team)
+
+			for other := range ship.suddenDeath.allowedTeams {
+				best = min(best, place(other))
+			}
+
+			return best
+		}
+
+		if winner == nil || team == winner {
+			return place(team)
+		}
+
+		if p := place(team); p > 0 {
+			return p
+		}
+
+		return 1
+	}
+}
+
+// suddenDeathMinigame chooses a minigame from the lobby's pool that the given teams can play
+// against each other with the players they have left. It returns false if there are none.
+func (ship *Ship) suddenDeathMinigame(contenders []*Team) (MinigamePrototype, bool) {
+	smallest := math.MaxInt
+
+	for _, team := range contenders {
+		smallest = min(smallest, len(team.Players))
+	}
+
+	var candidates []MinigamePrototype
+
+	enabled := ship.lobby.manager.enabledMinigames()
+
+	for _, name := range ship.settings.Minigames {
+		proto, ok := enabled[name]
+
+		if !ok || proto.PlayerCount == 1 {
+			continue
+		}
+
+		if proto.teamsPlaying(len(contenders)) == len(contenders) && proto.TeamSize() <= smallest {
+			candidates = append(candidates, proto)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return MinigamePrototype{}, false
+	}
+
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+// trySuddenDeath starts sudden death between the given teams if the lobby has it turned on, it
+// hasn't been played yet this game and there is a minigame that the teams can play. It returns
+// true if sudden death was started.
+func (ship *Ship) trySuddenDeath(contenders []*Team) (bool, error) {
+	if !ship.settings.SuddenDeath || ship.suddenDeath != nil || len(contenders) < 2 {
+		return false, nil
+	}
+
+	proto, ok := ship.suddenDeathMinigame(contenders)
+
+	if !ok {
+		ship.logger().Info("no minigame can be played for sudden death")
+		return false, nil
+	}
+
+	ship.logger().Info("starting sudden death", zap.String("minigame", proto.Name))
+
+	pos := shipMaps[ship.settings.Map].suddenDeath
+
+	ship.fm.addMinigameFlag(suddenDeathFlagID, proto, pos)
+
+	f := ship.fm.flags[suddenDeathFlagID]
+	f.allowedTeams = make(map[*Team]struct{})
+
+	teamIndices := make([]int, 0, len(contenders))
+
+	for _, team := range contenders {
+		f.allowedTeams[team] = struct{}{}
+		teamIndices = append(teamIndices, int(team.Index()))
+	}
+
+	ship.suddenDeath = f
+	ship.isEndgame = false
+
+	ship.timer = TickingTimer(
+		ship.Scheduler,
+
+		time.Now().Add(suddenDeathDuration),
+		shipTickInterval,
+
+		ship.suddenDeathTick,
+
+		// If nobody wins in time, the game is a draw.
+		func() error {
+			return ship.triggerEndgame(nil)
+		},
+	)
+
+	msg := NewMessage("ship_sudden_death")
+	_ = msg.Add("flag_id", suddenDeathFlagID)
+	_ = msg.Add("pos", pos.ToMap())
+	_ = msg.Add("minigame", proto.Name)
+	_ = msg.Add("player_count", proto.playersFor(len(contenders)))
+	_ = msg.Add("teams", teamIndices)
+	_ = msg.Add("seconds_left", suddenDeathDuration.Seconds())
+
+	return true, ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// suddenDeathTick tells the players in the ship how long is left for sudden death. Unlike the main
+// tick, it doesn't decay flags or pay hold income, since the scores are settled once the game goes
+// to sudden death.
+func (ship *Ship) suddenDeathTick() error {
+	if ship.timer.HasEnded() {
+		return nil
+	}
+
+	msg := NewMessage("ship_tick").Add("seconds_left", ship.timer.TimeLeft().Seconds())
+
+	return ship.ForAllShipPlayers(func(p *Player) error {
+		return p.Client.Send(msg)
+	})
+}
+
+// allowsTeam returns true if and only if members of the given team may play the flag's minigame.
+func (f *flag) allowsTeam(team *Team) bool {
+	if f.allowedTeams == nil {
+		return true
+	}
+
+	_, ok := f.allowedTeams[team]
+
+	return ok
+}
+
+// recordCapture remembers how long the given team took to capture a flag, for the fastest capture
+// tie-break.
+func (ship *Ship) recordCapture(team *Team, took time.Duration) {
+	if fastest, ok := ship.fastestCaptures[team]; !ok || took < fastest {
+		ship.fastestCaptures[team] = took
+	}
+}
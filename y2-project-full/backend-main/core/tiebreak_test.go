@@ -0,0 +1,269 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestShip returns a ship for a lobby with the given number of teams, each with one player.
+// Nothing is owned or scored yet.
+func newTestShip(teamCount int, rules ...tieBreak) *Ship {
+	lobby := &Lobby{}
+
+	for i := 0; i < teamCount; i++ {
+		team := lobby.newTeam()
+		team.AddPlayer(&Player{Name: fmt.Sprint("Player", i)})
+		lobby.Teams = append(lobby.Teams, team)
+	}
+
+	lobby.Settings.TieBreaks = rules
+
+	return &Ship{
+		lobby:            lobby,
+		fm:               &flagManager{flags: make(map[string]*flag)},
+		individualScores: make(map[*Player]float64),
+		fastestCaptures:  make(map[*Team]time.Duration),
+		bankedTokens:     make([]int, teamCount),
+		settings:         lobby.Settings.clone(),
+	}
+}
+
+// onlyPlayer returns the player on the given team, which must have exactly one.
+func onlyPlayer(team *Team) *Player {
+	for p := range team.Players {
+		return p
+	}
+
+	return nil
+}
+
+func TestLeaders(t *testing.T) {
+	lobby := &Lobby{}
+
+	for i := 0; i < 4; i++ {
+		lobby.Teams = append(lobby.Teams, lobby.newTeam())
+	}
+
+	tests := []struct {
+		name   string
+		scores []float64
+		want   []int
+	}{
+		{"one leader", []float64{1, 3, 2, 0}, []int{1}},
+		{"tied leaders", []float64{3, 1, 3, 2}, []int{0, 2}},
+		{"everybody tied", []float64{0, 0, 0, 0}, []int{0, 1, 2, 3}},
+		{"negative scores", []float64{-5, -2, -9, -2}, []int{1, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := leaders(lobby.Teams, func(team *Team) float64 {
+				return tt.scores[team.Index()]
+			})
+
+			got := make([]int, 0, len(top))
+
+			for _, team := range top {
+				got = append(got, int(team.Index()))
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("leaders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTie(t *testing.T) {
+	allRules := []tieBreak{tieBreakMVP, tieBreakFlagsHeld, tieBreakFastestCapture}
+
+	tests := []struct {
+		name  string
+		rules []tieBreak
+
+		// setup prepares the game on a ship with three teams.
+		setup func(ship *Ship)
+
+		wantTeams     []int
+		wantDecidedBy string
+	}{
+		{
+			name:  "decided by score",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				ship.bankedTokens = []int{2, 5, 3}
+			},
+			wantTeams:     []int{1},
+			wantDecidedBy: decidedByScore,
+		},
+		{
+			name:  "decided by mvp",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				ship.bankedTokens = []int{5, 5, 3}
+				ship.individualScores[onlyPlayer(ship.lobby.Teams[0])] = 2
+				ship.individualScores[onlyPlayer(ship.lobby.Teams[1])] = 4
+				ship.individualScores[onlyPlayer(ship.lobby.Teams[2])] = 9
+			},
+			wantTeams:     []int{1},
+			wantDecidedBy: string(tieBreakMVP),
+		},
+		{
+			name:  "decided by flags held",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				worth := MinigamePrototype{Worth: 1}
+
+				ship.fm.flags["a"] = &flag{minigameProto: worth, owner: ship.lobby.Teams[0]}
+				ship.fm.flags["b"] = &flag{minigameProto: worth, owner: ship.lobby.Teams[0]}
+				ship.fm.flags["c"] = &flag{minigameProto: worth, owner: ship.lobby.Teams[1]}
+				ship.bankedTokens = []int{0, 1, 0}
+			},
+			wantTeams:     []int{0},
+			wantDecidedBy: string(tieBreakFlagsHeld),
+		},
+		{
+			name:  "decided by fastest capture",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				ship.fastestCaptures[ship.lobby.Teams[0]] = 40 * time.Second
+				ship.fastestCaptures[ship.lobby.Teams[2]] = 25 * time.Second
+			},
+			wantTeams:     []int{2},
+			wantDecidedBy: string(tieBreakFastestCapture),
+		},
+		{
+			name:  "rules in the lobby's order",
+			rules: []tieBreak{tieBreakFastestCapture, tieBreakMVP},
+			setup: func(ship *Ship) {
+				ship.individualScores[onlyPlayer(ship.lobby.Teams[0])] = 9
+				ship.fastestCaptures[ship.lobby.Teams[1]] = 10 * time.Second
+			},
+			wantTeams:     []int{1},
+			wantDecidedBy: string(tieBreakFastestCapture),
+		},
+		{
+			name:  "no rules",
+			rules: nil,
+			setup: func(ship *Ship) {
+				ship.bankedTokens = []int{4, 4, 1}
+				ship.individualScores[onlyPlayer(ship.lobby.Teams[0])] = 9
+			},
+			wantTeams:     []int{0, 1},
+			wantDecidedBy: "",
+		},
+		{
+			name:  "still tied",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				ship.bankedTokens = []int{4, 4, 4}
+			},
+			wantTeams:     []int{0, 1, 2},
+			wantDecidedBy: "",
+		},
+		{
+			name:  "sudden death beats score",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				ship.bankedTokens = []int{9, 0, 0}
+				ship.suddenDeathWinner = ship.lobby.Teams[2]
+			},
+			wantTeams:     []int{2},
+			wantDecidedBy: decidedBySuddenDeath,
+		},
+		{
+			name:  "sudden death without a winner",
+			rules: allRules,
+			setup: func(ship *Ship) {
+				ship.suddenDeath = &flag{allowedTeams: map[*Team]struct{}{
+					ship.lobby.Teams[0]: {},
+					ship.lobby.Teams[2]: {},
+				}}
+
+				// Scores that change during sudden death don't decide the game.
+				ship.bankedTokens = []int{3, 0, 1}
+			},
+			wantTeams:     []int{0, 2},
+			wantDecidedBy: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ship := newTestShip(3, tt.rules...)
+			tt.setup(ship)
+
+			contenders, decidedBy := ship.resolveTie()
+
+			got := make(map[int]bool)
+
+			for _, team := range contenders {
+				got[int(team.Index())] = true
+			}
+
+			if len(got) != len(tt.wantTeams) || decidedBy != tt.wantDecidedBy {
+				t.Fatalf(
+					"resolveTie() = %v, %q, want %v, %q",
+					got, decidedBy, tt.wantTeams, tt.wantDecidedBy,
+				)
+			}
+
+			for _, i := range tt.wantTeams {
+				if !got[i] {
+					t.Errorf("team %d is not a contender, want contenders %v", i, tt.wantTeams)
+				}
+			}
+		})
+	}
+}
+
+func TestFinalPlace(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []int
+
+		// winner is the index of the winning team, or -1 for a draw.
+		winner int
+
+		// suddenDeath holds the indices of the teams that played sudden death, if it was played.
+		suddenDeath []int
+
+		want []int
+	}{
+		{"winner by score", []int{3, 5, 1, 3}, 1, nil, []int{1, 0, 3, 1}},
+		{"winner by tie-break", []int{5, 5, 1, 5}, 3, nil, []int{1, 1, 3, 0}},
+		{"draw", []int{5, 5, 1, 2}, -1, nil, []int{0, 0, 3, 2}},
+		{"sudden death won", []int{4, 3, 4, 1}, 2, []int{0, 2}, []int{1, 2, 0, 3}},
+		{"sudden death drawn", []int{4, 3, 2, 1}, -1, []int{0, 2}, []int{0, 1, 0, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ship := newTestShip(len(tt.scores))
+			ship.bankedTokens = tt.scores
+
+			if tt.suddenDeath != nil {
+				ship.suddenDeath = &flag{allowedTeams: make(map[*Team]struct{})}
+
+				for _, i := range tt.suddenDeath {
+					ship.suddenDeath.allowedTeams[ship.lobby.Teams[i]] = struct{}{}
+				}
+			}
+
+			var winner *Team
+
+			if tt.winner >= 0 {
+				winner = ship.lobby.Teams[tt.winner]
+			}
+
+			place := ship.finalPlace(winner)
+
+			for i, team := range ship.lobby.Teams {
+				if got := place(team); got != tt.want[i] {
+					t.Errorf("team %d placed %d, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
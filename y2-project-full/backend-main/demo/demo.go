@@ -10,12 +10,16 @@ import (
 // ProtoSp is the prototype for the demo minigame which only uses a single player.
 var ProtoSp = core.MinigamePrototype{
 	Name:        "demo_minigame_sp",
+	DisplayName: "Demo",
+	Description: "A minigame for testing the server.",
+	Rules:       "Win or lose at the press of a button.",
+	Tags:        []string{"test", "solo"},
 	PlayerCount: 1,
 	Worth:       1,
 	Cooldown:    5 * time.Second,
 	StoreCtor:   nil,
-	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, ship *core.Ship) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, &state{
+	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, host core.MinigameHost) *core.MinigameContext {
+		return core.NewMinigameContext(proto, store, host, &state{
 			pm: core.NewPositionManager("demo_mov_"),
 		})
 	},
@@ -24,12 +28,16 @@ var ProtoSp = core.MinigamePrototype{
 // Proto1v1 is the prototype for the demo minigame which uses two players.
 var Proto1v1 = core.MinigamePrototype{
 	Name:        "demo_minigame_1v1",
+	DisplayName: "Demo Duel",
+	Description: "A one-on-one minigame for testing the server.",
+	Rules:       "The first player to press the button wins for their team.",
+	Tags:        []string{"test", "versus"},
 	PlayerCount: 2,
 	Worth:       2,
 	Cooldown:    10 * time.Second,
 	StoreCtor:   nil,
-	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, ship *core.Ship) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, &state{
+	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, host core.MinigameHost) *core.MinigameContext {
+		return core.NewMinigameContext(proto, store, host, &state{
 			pm: core.NewPositionManager("demo_mov_"),
 		})
 	},
@@ -38,12 +46,16 @@ var Proto1v1 = core.MinigamePrototype{
 // Proto2v2 is the prototype for the demo minigame which uses four players.
 var Proto2v2 = core.MinigamePrototype{
 	Name:        "demo_minigame_2v2",
+	DisplayName: "Demo Doubles",
+	Description: "A two-on-two minigame for testing the server.",
+	Rules:       "The first player to press the button wins for their team.",
+	Tags:        []string{"test", "versus", "team"},
 	PlayerCount: 4,
 	Worth:       4,
 	Cooldown:    5 * time.Second,
 	StoreCtor:   nil,
-	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, ship *core.Ship) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, &state{
+	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, host core.MinigameHost) *core.MinigameContext {
+		return core.NewMinigameContext(proto, store, host, &state{
 			pm: core.NewPositionManager("demo_mov_"),
 		})
 	},
@@ -109,3 +121,10 @@ func (demo *state) Handle(ctx *core.MinigameContext, player *core.Player, messag
 func (demo *state) HandleDisconnection(ctx *core.MinigameContext, player *core.Player) error {
 	return ctx.End(core.MultiplayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(ProtoSp)
+	core.RegisterMinigame(Proto1v1)
+	core.RegisterMinigame(Proto2v2)
+}
@@ -7,15 +7,15 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"server/bird"
-	"server/click_race"
+	_ "server/bird"
+	_ "server/click_race"
 	"server/core"
-	"server/demo"
-	"server/match"
-	"server/moles"
-	"server/race"
-	"server/rps"
-	"server/shooter"
+	_ "server/demo"
+	_ "server/match"
+	_ "server/moles"
+	_ "server/race"
+	_ "server/rps"
+	_ "server/shooter"
 	"slices"
 )
 
@@ -24,26 +24,8 @@ func main() {
 		core.Logger = core.Logger.WithOptions(zap.IncreaseLevel(zap.InfoLevel))
 	}
 
-	// We have to pass in the minigame prototypes because Go doesn't allow circular package
-	// dependencies :/ This is ugly, but it works.
-	hub := core.NewHub(map[string]core.MinigamePrototype{
-		demo.ProtoSp.Name:        demo.ProtoSp,
-		demo.Proto1v1.Name:       demo.Proto1v1,
-		demo.Proto2v2.Name:       demo.Proto2v2,
-		match.Prototype.Name:     match.Prototype,
-		moles.Prototype.Name:     moles.Prototype,
-		click_race.ProtoSp.Name:  click_race.ProtoSp,
-		click_race.Proto1v1.Name: click_race.Proto1v1,
-		rps.Prototype.Name:       rps.Prototype,
-		shooter.Proto1v1.Name:    shooter.Proto1v1,
-		shooter.Proto2v2.Name:    shooter.Proto2v2,
-		shooter.Proto3v3.Name:    shooter.Proto3v3,
-		race.ProtoSp.Name:        race.ProtoSp,
-		race.Proto1v1.Name:       race.Proto1v1,
-		race.Proto2v2.Name:       race.Proto2v2,
-		race.Proto3v3.Name:       race.Proto3v3,
-		bird.ProtoSp.Name:        bird.ProtoSp,
-	})
+	// Minigame packages register their prototypes when they are imported.
+	hub := core.NewHub(core.RegisteredMinigames(), core.StorageFromArgs())
 
 	upgrader := websocket.Upgrader{CheckOrigin: func(req *http.Request) bool {
 		// We have to allow all origins because we are receiving connections from random
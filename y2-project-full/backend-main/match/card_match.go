@@ -12,8 +12,6 @@ import (
 // are considered a pair.
 type pattern = uint8
 
-var won = 0
-
 // patternCount is the number of different card patterns we have.
 const patternCount = 8
 
@@ -23,6 +21,10 @@ const gameDuration = 1 * time.Minute
 // tickInterval is the amount of time we leave between timer updates given to the client.
 const tickInterval = 500 * time.Millisecond
 
+// featPerfectClear is the feat reported when a player clears the grid without ever turning over a
+// pair of cards that don't match.
+const featPerfectClear = "perfect_clear"
+
 const (
 	// gridWidth is the number of columns of cards we have on the table.
 	gridWidth = 8
@@ -40,12 +42,17 @@ const (
 // Prototype is the prototype for a single-player card matching memory core.
 var Prototype = core.MinigamePrototype{
 	Name:        "card_match_sp",
+	DisplayName: "Card Match",
+	Description: "Find every pair of cards before time runs out.",
+	Rules: "Turn over two cards at a time. Matching cards stay face up. Clear the whole " +
+		"table within a minute to capture the flag.",
+	Tags:        []string{"memory", "solo"},
 	PlayerCount: 1,
 	Worth:       1,
 	Cooldown:    5 * time.Second,
 	StoreCtor:   nil,
-	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, ship *core.Ship) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, newState())
+	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, host core.MinigameHost) *core.MinigameContext {
+		return core.NewMinigameContext(proto, store, host, newState())
 	},
 }
 
@@ -115,6 +122,9 @@ type state struct {
 	// This will be nil if there is no face-up card.
 	flipped *card
 
+	// mismatches is the number of pairs the player has turned over that didn't match.
+	mismatches int
+
 	// timer is the timer that counts down to the core end.
 	timer *core.FunctionTimer
 }
@@ -134,7 +144,7 @@ func newState() *state {
 
 func (game *state) startTimer(ctx *core.MinigameContext) {
 	timer := core.TickingTimer(
-		ctx.Ship.Scheduler,
+		ctx.Scheduler(),
 
 		time.Now().Add(gameDuration),
 		tickInterval,
@@ -185,12 +195,22 @@ func (game *state) tick(ctx *core.MinigameContext) error {
 // end cleans up and ends the minigame.
 func (game *state) end(ctx *core.MinigameContext, result core.MinigameResult) error {
 	// Prevent any further ticks.
-	if ctx.Ship.Recorder != nil { // Record the result.
-		duration := gameDuration.Seconds() - game.timer.TimeLeft().Seconds()
-		ctx.Ship.Recorder.RecordSP(ctx.ExactlyOnePlayer(), 0, uint8(won), duration, "card_match_sp")
+	game.timer.Stop()
 
+	// The player scores a point for each pair they matched.
+	pairs := 0
+
+	for _, card := range game.table.grid {
+		if card.matched {
+			pairs++
+		}
 	}
-	game.timer.Stop()
+
+	pairs /= 2
+
+	result = result.WithScore(ctx.ExactlyOnePlayer(), float64(pairs))
+	result = result.WithStat("mismatches", float64(game.mismatches))
+
 	return ctx.End(result)
 }
 
@@ -203,9 +223,14 @@ func (game *state) endIfClear(ctx *core.MinigameContext, p *core.Player) error {
 			return nil
 		}
 	}
-	won = 1
+	var featErr error
+
+	if game.mismatches == 0 {
+		featErr = ctx.ReportFeat(p, featPerfectClear)
+	}
+
 	// Grid is clear, so the player has won.
-	return game.end(ctx, core.SinglePlayerWin(p))
+	return errors.Join(featErr, game.end(ctx, core.SinglePlayerWin(p)))
 }
 
 // flip turns over the card at (x, y). It reports an error to the client if the card has
@@ -233,6 +258,8 @@ func (game *state) flip(ctx *core.MinigameContext, p *core.Player, card *card) e
 
 		card.matched = true
 		game.flipped.matched = true
+	} else {
+		game.mismatches++
 	}
 
 	game.flipped = nil
@@ -305,3 +332,8 @@ func (game *state) HandleDisconnection(ctx *core.MinigameContext, player *core.P
 
 	return game.end(ctx, core.SinglePlayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(Prototype)
+}
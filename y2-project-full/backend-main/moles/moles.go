@@ -89,6 +89,11 @@ type moleStore struct {
 // Prototype is the prototype for a single-player whack-a-mole minigame.
 var Prototype = core.MinigamePrototype{
 	Name:        "whack_a_mole",
+	DisplayName: "Whack-a-Mole",
+	Description: "Hit the moles as they pop up.",
+	Rules: "Hit as many moles as you can before the time runs out. Beat the best score on " +
+		"the flag to capture it.",
+	Tags:        []string{"reflex", "solo"},
 	PlayerCount: 1,
 	Worth:       1,
 	Cooldown:    5 * time.Second,
@@ -100,8 +105,8 @@ var Prototype = core.MinigamePrototype{
 		}
 	},
 
-	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, ship *core.Ship) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, newState())
+	Constructor: func(proto *core.MinigamePrototype, store core.ScoreStore, host core.MinigameHost) *core.MinigameContext {
+		return core.NewMinigameContext(proto, store, host, newState())
 	},
 }
 
@@ -151,7 +156,7 @@ func (game *state) Start(ctx *core.MinigameContext) error {
 // startTimer begins the core duration and mole refresh timers.
 func (game *state) startTimers(ctx *core.MinigameContext) {
 	game.refreshTimer = core.SingleTimer(
-		ctx.Ship.Scheduler,
+		ctx.Scheduler(),
 		time.Now().Add(refreshInterval),
 
 		func() error {
@@ -160,7 +165,7 @@ func (game *state) startTimers(ctx *core.MinigameContext) {
 	)
 
 	game.gameTimer = core.SingleTimer(
-		ctx.Ship.Scheduler,
+		ctx.Scheduler(),
 		time.Now().Add(gameDuration),
 
 		func() error {
@@ -186,7 +191,7 @@ func (game *state) resetRefreshTimer(ctx *core.MinigameContext) {
 	game.refreshTimer.Stop()
 
 	game.refreshTimer = core.SingleTimer(
-		ctx.Ship.Scheduler,
+		ctx.Scheduler(),
 
 		time.Now().Add(refreshInterval),
 
@@ -263,21 +268,13 @@ func (game *state) endNaturally(ctx *core.MinigameContext) error {
 
 	// If the player did better than the threshold, they've won.
 	if game.score > store.threshold {
-		if ctx.Ship.Recorder != nil {
-			ctx.Ship.Recorder.RecordSP(ctx.ExactlyOnePlayer(), float64(game.score),
-				1, 30, "whack_a_mole")
-		}
 		result = core.SinglePlayerWin(ctx.ExactlyOnePlayer())
 
 		// Update the store so that any future captures will need to do better than this.
 		store.threshold = game.score
-	} else {
-		if ctx.Ship.Recorder != nil {
-			ctx.Ship.Recorder.RecordSP(ctx.ExactlyOnePlayer(), float64(game.score),
-				0, 30, "whack_a_mole")
-		}
 	}
-	return game.end(ctx, result)
+
+	return game.end(ctx, result.WithScore(ctx.ExactlyOnePlayer(), float64(game.score)))
 }
 
 // end finishes the core with the given result.
@@ -318,3 +315,8 @@ func (game *state) HandleDisconnection(ctx *core.MinigameContext, player *core.P
 
 	return game.end(ctx, core.SinglePlayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(Prototype)
+}
@@ -1,13 +1,11 @@
 package race
 
 import (
-	"fmt"
+	"errors"
 	"server/core"
 	"time"
 )
 
-var spWin = 0
-
 // timeout is the maximum time that the game will run for. The game ends when this timeout is
 // reached even if not all players have completed it.
 const timeout = 150 * time.Second
@@ -15,18 +13,26 @@ const timeout = 150 * time.Second
 // totalLaps is the number of laps a player must complete to finish the race.
 const totalLaps = 3
 
-// raceCtor is the constructor used for all race minigames, regardless of player count.
+// featRaceRecord is the feat reported when a player beats a time set by somebody else.
+const featRaceRecord = "race_record"
+
+// raceCtor is the constructor used for all race minigames, regardless of player count. Any number
+// of teams can race at once.
 func raceCtor(
 	proto *core.MinigamePrototype,
 	store core.ScoreStore,
-	ship *core.Ship,
+	host core.MinigameHost,
 ) *core.MinigameContext {
-	return core.NewMinigameContext(proto, store, ship, newState())
+	return core.NewMinigameContext(proto, store, host, newState())
 }
 
 // ProtoSp is the prototype for the race minigame which uses only one player.
 var ProtoSp = core.MinigamePrototype{
 	Name:        "race_sp",
+	DisplayName: "Race",
+	Description: "Race three laps against the clock.",
+	Rules:       "Finish three laps faster than the time to beat on the flag to capture it.",
+	Tags:        []string{"racing", "solo"},
 	PlayerCount: 1,
 	Worth:       1,
 	Cooldown:    15 * time.Second,
@@ -45,9 +51,15 @@ var ProtoSp = core.MinigamePrototype{
 // Proto1v1 is the prototype for the race minigame which uses two players.
 var Proto1v1 = core.MinigamePrototype{
 	Name:        "race_1v1",
+	DisplayName: "Race Duel",
+	Description: "Race three laps against another team.",
+	Rules: "Finish three laps before the others. Earlier finishes earn more points, and the " +
+		"team with the most points wins.",
+	Tags:        []string{"racing", "versus"},
 	PlayerCount: 2,
 	Worth:       2,
 	Cooldown:    10 * time.Second,
+	TeamCounts:  []int{2, 3, 4},
 	StoreCtor:   nil,
 	Constructor: raceCtor,
 }
@@ -55,9 +67,15 @@ var Proto1v1 = core.MinigamePrototype{
 // Proto2v2 is the prototype for the race minigame which uses four players.
 var Proto2v2 = core.MinigamePrototype{
 	Name:        "race_2v2",
+	DisplayName: "Team Race",
+	Description: "Race three laps in pairs.",
+	Rules: "Finish three laps before the others. Earlier finishes earn more points, and the " +
+		"team with the most points wins.",
+	Tags:        []string{"racing", "versus", "team"},
 	PlayerCount: 4,
 	Worth:       4,
 	Cooldown:    5 * time.Second,
+	TeamCounts:  []int{2, 3, 4},
 	StoreCtor:   nil,
 	Constructor: raceCtor,
 }
@@ -65,9 +83,15 @@ var Proto2v2 = core.MinigamePrototype{
 // Proto3v3 is the prototype for the race minigame which uses six players.
 var Proto3v3 = core.MinigamePrototype{
 	Name:        "race_3v3",
+	DisplayName: "Team Race",
+	Description: "Race three laps in teams of three.",
+	Rules: "Finish three laps before the others. Earlier finishes earn more points, and the " +
+		"team with the most points wins.",
+	Tags:        []string{"racing", "versus", "team"},
 	PlayerCount: 6,
 	Worth:       6,
 	Cooldown:    3 * time.Second,
+	TeamCounts:  []int{2, 3, 4},
 	StoreCtor:   nil,
 	Constructor: raceCtor,
 }
@@ -105,8 +129,11 @@ type state struct {
 	// timer counts down to the race timeout.
 	timer core.FunctionTimer
 
+	// spawns maps every player's name to the position where they start the race.
+	spawns map[string]core.Position
+
 	// unfinishedPlayers maps players' names to their state while they are racing. Entries only
-	// exist for players who have not yet finished.
+	// exist for players who have not yet finished. Bots never race, so they have no entries.
 	unfinishedPlayers map[string]playerState
 
 	// finishedPlayers maps players' names to their finish information. Entries only exist for
@@ -119,6 +146,7 @@ func newState() *state {
 	return &state{
 		startTime:         time.Time{},
 		timer:             core.ExpiredTimer(),
+		spawns:            make(map[string]core.Position),
 		unfinishedPlayers: make(map[string]playerState),
 		finishedPlayers:   make(map[string]finishInfo),
 	}
@@ -146,7 +174,10 @@ func (s *state) spawnAll(ctx *core.MinigameContext) {
 	if ctx.PlayerCount() == 1 {
 		// In the singleplayer version of the game, the player's spawn position is not determined
 		// by their team.
-		s.unfinishedPlayers[ctx.ExactlyOnePlayer().Name] = playerState{
+		name := ctx.ExactlyOnePlayer().Name
+
+		s.spawns[name] = availableSpawns[0][0]
+		s.unfinishedPlayers[name] = playerState{
 			pos:      availableSpawns[0][0],
 			lapCount: 0,
 		}
@@ -168,6 +199,13 @@ func (s *state) spawnAll(ctx *core.MinigameContext) {
 			spawn, *teamSpawns = (*teamSpawns)[0], (*teamSpawns)[1:]
 		}
 
+		s.spawns[p.Name] = spawn
+
+		if p.IsBot() {
+			// Bots stay on the start line, and the race doesn't wait for them to finish.
+			return nil
+		}
+
 		s.unfinishedPlayers[p.Name] = playerState{
 			pos:      spawn,
 			lapCount: 0,
@@ -180,7 +218,7 @@ func (s *state) spawnAll(ctx *core.MinigameContext) {
 // welcomeMessageBase returns the core welcome message that we use, regardless of player count.
 func (s *state) welcomeMessageBase(p *core.Player) *core.Message {
 	msg := core.NewMessage("race_welcome")
-	_ = msg.Add("your_spawn", s.unfinishedPlayers[p.Name].pos.ToMap())
+	_ = msg.Add("your_spawn", s.spawns[p.Name].ToMap())
 	_ = msg.Add("laps", totalLaps)
 	_ = msg.Add("timeout", timeout)
 
@@ -207,7 +245,7 @@ func (s *state) welcomePlayerMp(p *core.Player) error {
 		peerSpawns := make(map[string]map[string]float64)
 
 		_ = p.ForAllActivityPeers(func(peer *core.Player) error {
-			peerSpawns[peer.Name] = s.unfinishedPlayers[peer.Name].pos.ToMap()
+			peerSpawns[peer.Name] = s.spawns[peer.Name].ToMap()
 
 			return nil
 		})
@@ -231,49 +269,17 @@ func (s *state) welcomeAll(ctx *core.MinigameContext) error {
 
 // end cleans up after the state and ends the game with the given result.
 func (s *state) end(ctx *core.MinigameContext, result core.MinigameResult) error {
-	if ctx.Ship.Recorder != nil {
-		timeSpent := timeout.Seconds() - s.timer.TimeLeft().Seconds()
-		t0, t1 := s.calculatePoints(ctx)
-		playerCount := ctx.PlayerCount()
-		if playerCount == 1 {
-			if ctx.ExactlyOnePlayer().Team.Index() == 0 { // Record for race_sp
-				ctx.Ship.Recorder.RecordSP(ctx.ExactlyOnePlayer(), float64(t0), uint8(spWin), timeSpent,
-					"race_sp")
-			} else {
-				ctx.Ship.Recorder.RecordSP(ctx.ExactlyOnePlayer(), float64(t1), uint8(spWin), timeSpent,
-					"race_sp")
-			}
-		} else {
-			ps := make(map[*core.Player]float64)
-			pw := make(map[*core.Player]uint8)
-			var winningTeam *core.Team
-			if t0 > t1 { // Get winning team.
-				winningTeam = ctx.GetTeam(0)
-			} else {
-				winningTeam = ctx.GetTeam(1)
-			}
-			_ = ctx.ForAllPlayers(func(p *core.Player) error {
-				if p.Team == winningTeam {
-					pw[p] = 1
-				} else {
-					pw[p] = 0
-				}
-				var points float64 // Player gets 0 points for not finishing, else get the points from the store.
-				finish, didFinish := s.finishedPlayers[p.Name]
-				if !(didFinish) {
-					points = 0
-				} else {
-					points = float64(finish.points)
-				}
-				ps[p] = points
-				return nil
-			})
-			gameName := fmt.Sprintf("race_%[1]dv%[1]d", playerCount/2)
-			// race_1v1,race2v2,race3v3
-			ctx.Ship.Recorder.RecordMP(ps, pw, timeSpent, gameName)
-		}
-	}
 	s.timer.Stop()
+
+	// Players score the points for their finishing position, or nothing if they didn't finish.
+	_ = ctx.ForAllPlayers(func(p *core.Player) error {
+		if finish, didFinish := s.finishedPlayers[p.Name]; didFinish {
+			result = result.WithScore(p, float64(finish.points))
+		}
+
+		return nil
+	})
+
 	return ctx.End(result)
 }
 
@@ -285,10 +291,16 @@ func (s *state) endSp(ctx *core.MinigameContext) error {
 		store := ctx.Store.(*raceStore)
 
 		if finish.timeTaken < store.bestTime {
+			var featErr error
+
+			if store.bestTime < timeout {
+				// The time to beat was set by another player, so this is a new record.
+				featErr = ctx.ReportFeat(p, featRaceRecord)
+			}
+
 			store.bestTime = finish.timeTaken
-			spWin = 1
 			// Player beat the record time, so they win.
-			return s.end(ctx, core.SinglePlayerWin(p))
+			return errors.Join(featErr, s.end(ctx, core.SinglePlayerWin(p)))
 		}
 	}
 
@@ -297,8 +309,10 @@ func (s *state) endSp(ctx *core.MinigameContext) error {
 	return s.end(ctx, core.SinglePlayerLoss())
 }
 
-// calculatePoints returns the point totals for teams 0 and 1, in that order.
-func (s *state) calculatePoints(ctx *core.MinigameContext) (t0, t1 int) {
+// calculatePoints returns the point total for each team.
+func (s *state) calculatePoints(ctx *core.MinigameContext) map[*core.Team]int {
+	points := make(map[*core.Team]int)
+
 	_ = ctx.ForAllPlayers(func(p *core.Player) error {
 		finish, didFinish := s.finishedPlayers[p.Name]
 
@@ -307,30 +321,22 @@ func (s *state) calculatePoints(ctx *core.MinigameContext) (t0, t1 int) {
 			return nil
 		}
 
-		if p.Team.Index() == 0 {
-			t0 += finish.points
-		} else {
-			t1 += finish.points
-		}
+		points[p.Team] += finish.points
 
 		return nil
 	})
 
-	return
+	return points
 }
 
-// endMp decides the outcome of a multiplayer game and terminates the game.
+// endMp decides the outcome of a multiplayer game and terminates the game. Teams are ranked by
+// their point totals, and the result is a draw if the highest total is shared.
 func (s *state) endMp(ctx *core.MinigameContext) error {
-	t0, t1 := s.calculatePoints(ctx)
+	points := s.calculatePoints(ctx)
 
-	var result core.MinigameResult
-
-	// Default result is a draw, so we only modify it if the result is _not_ a draw.
-	if t0 > t1 {
-		result = core.MultiplayerResult(ctx.GetTeam(0))
-	} else {
-		result = core.MultiplayerResult(ctx.GetTeam(1))
-	}
+	result := core.ScoredResult(ctx.Teams(), func(team *core.Team) float64 {
+		return float64(points[team])
+	})
 
 	return s.end(ctx, result)
 }
@@ -354,7 +360,7 @@ func (s *state) startTimers(ctx *core.MinigameContext) {
 	// Store a reference time so we can calculate how long each player takes to finish the race.
 	s.startTime = time.Now()
 
-	s.timer = core.SingleTimer(ctx.Ship.Scheduler, time.Now().Add(timeout), func() error {
+	s.timer = core.SingleTimer(ctx.Scheduler(), time.Now().Add(timeout), func() error {
 		if s.timer.WasStopped() {
 			return nil
 		}
@@ -408,12 +414,12 @@ func (s *state) moveToFinishedState(p *core.Player, ctx *core.MinigameContext) e
 		// The number of points earned is equal to the number of players beaten plus one*. For
 		// example, in a 3v3 game the player who finishes first earns six points, the player who
 		// finishes second earns five, and so on until the player who finishes last, who earns a
-		// single point. A score of zero is reserved for players who do not finish.
+		// single point. A score of zero is reserved for players who do not finish, including bots.
 		//
-		// *The "plus one" is implicit here because we haven't yet removed the finishing player from
-		// unfinishedPlayers, which means they are counted as having "beaten themselves"; this is
-		// one extra player, so replaces the explicit addition.
-		points: len(s.unfinishedPlayers),
+		// *The "plus one" is implicit here because we haven't yet added the finishing player to
+		// finishedPlayers, which means they are counted as having "beaten themselves"; this is one
+		// extra player, so replaces the explicit addition.
+		points: ctx.PlayerCount() - len(s.finishedPlayers),
 	}
 
 	// This player has now finished, so remove their unfinished state...
@@ -423,7 +429,7 @@ func (s *state) moveToFinishedState(p *core.Player, ctx *core.MinigameContext) e
 	s.finishedPlayers[p.Name] = fInfo
 
 	if len(s.unfinishedPlayers) == 0 {
-		// No unfinished players left, so the game is over.
+		// No unfinished players left, so the game is over. Any bots are still on the start line.
 		return s.finishRace(ctx)
 	}
 
@@ -526,3 +532,11 @@ func (s *state) HandleDisconnection(ctx *core.MinigameContext, player *core.Play
 
 	return s.end(ctx, core.MultiplayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(ProtoSp)
+	core.RegisterMinigame(Proto1v1)
+	core.RegisterMinigame(Proto2v2)
+	core.RegisterMinigame(Proto3v3)
+}
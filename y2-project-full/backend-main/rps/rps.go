@@ -21,6 +21,11 @@ const targetWinCount uint = 3
 // Prototype is the prototype for the 1v1 rock-paper-scissors minigame.
 var Prototype = core.MinigamePrototype{
 	Name:        "rps_1v1",
+	DisplayName: "Rock Paper Scissors",
+	Description: "The classic game of rock, paper, scissors.",
+	Rules: "Pick rock, paper or scissors each round. Rock beats scissors, scissors beats " +
+		"paper and paper beats rock. The first to win three rounds wins.",
+	Tags:        []string{"luck", "versus"},
 	PlayerCount: 2,
 	Worth:       2,
 	Cooldown:    5 * time.Second,
@@ -29,9 +34,9 @@ var Prototype = core.MinigamePrototype{
 	Constructor: func(
 		proto *core.MinigamePrototype,
 		store core.ScoreStore,
-		ship *core.Ship,
+		host core.MinigameHost,
 	) *core.MinigameContext {
-		return core.NewMinigameContext(proto, store, ship, newState())
+		return core.NewMinigameContext(proto, store, host, newState())
 	},
 }
 
@@ -125,8 +130,6 @@ type state struct {
 	// postRoundTimer begins at the end of a round and counts down to either the next round or the
 	// end of the game, depending on the current game state.
 	postRoundTimer core.FunctionTimer
-	// totalGameTimer is the timer from game start to game end, used for the Recorder.
-	totalGameTimer core.FunctionTimer
 }
 
 // newState returns a pointer to a new empty rock-paper-scissors game state object.
@@ -143,7 +146,6 @@ func newState() *state {
 
 		selectionTimer: core.ExpiredTimer(),
 		postRoundTimer: core.ExpiredTimer(),
-		totalGameTimer: core.ExpiredTimer(),
 	}
 }
 
@@ -241,7 +243,7 @@ func (s *state) endRound(ctx *core.MinigameContext, winner *core.Player) error {
 
 	// Begin the post-round timer.
 	s.postRoundTimer = core.SingleTimer(
-		ctx.Ship.Scheduler,
+		ctx.Scheduler(),
 		time.Now().Add(postRoundWait),
 
 		func() error {
@@ -262,26 +264,18 @@ func (s *state) endWithResult(ctx *core.MinigameContext, result core.MinigameRes
 	s.postRoundTimer.Stop()
 	s.selectionTimer.Stop()
 
+	// Each player scores the number of rounds they won.
+	for i, p := range s.players {
+		if p != nil {
+			result = result.WithScore(p, float64(s.wins[i]))
+		}
+	}
+
 	return ctx.End(result)
 }
 
 // endWithWinner ends the game, declaring the given player the winner.
 func (s *state) endWithWinner(ctx *core.MinigameContext, winner *core.Player) error {
-	if ctx.Ship.Recorder != nil {
-		duration := (10*time.Minute - s.totalGameTimer.TimeLeft()).Seconds()
-		s.totalGameTimer.Stop()
-		ps := make(map[*core.Player]float64)
-		pw := make(map[*core.Player]uint8)
-		for _, v := range s.players {
-			ps[v] = float64(s.wins[s.playerIndex(v)])
-			if v == winner {
-				pw[v] = 1
-			} else {
-				pw[v] = 0
-			}
-		}
-		ctx.Ship.Recorder.RecordMP(ps, pw, duration, "rps_1v1")
-	}
 	return s.endWithResult(ctx, core.MultiplayerResult(winner.Team))
 }
 
@@ -308,7 +302,7 @@ func (s *state) startRound(ctx *core.MinigameContext) error {
 
 	// Start the selection timer.
 	s.selectionTimer = core.SingleTimer(
-		ctx.Ship.Scheduler,
+		ctx.Scheduler(),
 		time.Now().Add(selectionTimeout),
 
 		func() error {
@@ -387,15 +381,6 @@ func (s *state) Start(ctx *core.MinigameContext) error {
 
 		return p.Client.Send(welcome)
 	})
-	if ctx.Ship.Recorder != nil { // If recording is enabled, start the timer to record the total game time.
-		s.totalGameTimer = core.SingleTimer(
-			ctx.Ship.Scheduler, // The max game time is not specified so make the timer expire after 10 mins.
-			time.Now().Add(10*time.Minute),
-			func() error {
-				return nil
-			},
-		)
-	}
 	// Start the first round.
 	startErr := s.startRound(ctx)
 
@@ -418,3 +403,8 @@ func (s *state) Handle(ctx *core.MinigameContext, player *core.Player, message *
 func (s *state) HandleDisconnection(ctx *core.MinigameContext, player *core.Player) error {
 	return s.endWithResult(ctx, core.MultiplayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(Prototype)
+}
@@ -2,9 +2,9 @@ package shooter
 
 import (
 	"errors"
-	"fmt"
 	"go.uber.org/zap"
 	"server/core"
+	"slices"
 	"time"
 )
 
@@ -15,21 +15,31 @@ const gameDuration = 1 * time.Minute
 // initialHealth is the number of hitpoints each player starts with.
 const initialHealth uint8 = 5
 
-// shooterCtor is the constructor used for all shooter minigames, regardless of player count.
+// featFlawlessWin is the feat reported for each member of a winning team when none of them was hit.
+const featFlawlessWin = "flawless_win"
+
+// shooterCtor is the constructor used for all shooter minigames, regardless of player count. When
+// more than two teams play, the last team standing wins.
 func shooterCtor(
 	proto *core.MinigamePrototype,
 	store core.ScoreStore,
-	ship *core.Ship,
+	host core.MinigameHost,
 ) *core.MinigameContext {
-	return core.NewMinigameContext(proto, store, ship, newState())
+	return core.NewMinigameContext(proto, store, host, newState())
 }
 
 // Proto1v1 is the prototype for the shooter minigame which uses two players.
 var Proto1v1 = core.MinigamePrototype{
 	Name:        "shooter_1v1",
+	DisplayName: "Shooter Duel",
+	Description: "A one-on-one shootout.",
+	Rules: "Shoot the other players before they shoot you. The last team standing wins, or " +
+		"the team with the most players left when time runs out.",
+	Tags:        []string{"action", "versus"},
 	PlayerCount: 2,
 	Worth:       2,
 	Cooldown:    10 * time.Second,
+	TeamCounts:  []int{2, 3, 4},
 	StoreCtor:   nil,
 	Constructor: shooterCtor,
 }
@@ -37,9 +47,15 @@ var Proto1v1 = core.MinigamePrototype{
 // Proto2v2 is the prototype for the shooter minigame which uses four players.
 var Proto2v2 = core.MinigamePrototype{
 	Name:        "shooter_2v2",
+	DisplayName: "Shooter Doubles",
+	Description: "A two-on-two shootout.",
+	Rules: "Shoot the other players before they shoot you. The last team standing wins, or " +
+		"the team with the most players left when time runs out.",
+	Tags:        []string{"action", "versus", "team"},
 	PlayerCount: 4,
 	Worth:       4,
 	Cooldown:    5 * time.Second,
+	TeamCounts:  []int{2, 3, 4},
 	StoreCtor:   nil,
 	Constructor: shooterCtor,
 }
@@ -47,9 +63,15 @@ var Proto2v2 = core.MinigamePrototype{
 // Proto3v3 is the prototype for the shooter minigame which uses six players.
 var Proto3v3 = core.MinigamePrototype{
 	Name:        "shooter_3v3",
+	DisplayName: "Shooter Squads",
+	Description: "A three-on-three shootout.",
+	Rules: "Shoot the other players before they shoot you. The last team standing wins, or " +
+		"the team with the most players left when time runs out.",
+	Tags:        []string{"action", "versus", "team"},
 	PlayerCount: 6,
 	Worth:       6,
 	Cooldown:    3 * time.Second,
+	TeamCounts:  []int{2, 3, 4},
 	StoreCtor:   nil,
 	Constructor: shooterCtor,
 }
@@ -105,6 +127,10 @@ type state struct {
 
 	// deadPlayerBullets maps player pointers to slices of bullet positions for dead players.
 	deadPlayerBullets map[*core.Player][]core.Position
+
+	// eliminatedTeams contains the teams which have no players left alive, in the order in which
+	// they were eliminated.
+	eliminatedTeams []*core.Team
 }
 
 // newState returns a new empty shooter game state.
@@ -242,105 +268,109 @@ func (s *state) livingPlayerByName(name string) *core.Player {
 }
 
 // teamPlayerCounts returns the number of players there are alive on each team.
-func (s *state) teamPlayerCounts() (count0, count1 uint8) {
+func (s *state) teamPlayerCounts() map[*core.Team]int {
+	counts := make(map[*core.Team]int)
+
 	for p := range s.alivePlayers {
-		if p.Team.Index() == 0 {
-			count0 += 1
-		} else {
-			count1 += 1
-		}
+		counts[p.Team] += 1
 	}
 
-	return
+	return counts
+}
+
+// ranking returns the teams in the game ranked by the number of players they have alive. Teams
+// with nobody alive are ranked by how long they survived, so the team eliminated first comes last.
+func (s *state) ranking(ctx *core.MinigameContext) core.MinigameResult {
+	counts := s.teamPlayerCounts()
+
+	return core.ScoredResult(ctx.Teams(), func(team *core.Team) float64 {
+		if counts[team] > 0 {
+			// Every team with somebody alive beats every team that has been eliminated.
+			return float64(len(s.eliminatedTeams) + counts[team])
+		}
+
+		return float64(slices.Index(s.eliminatedTeams, team))
+	})
 }
 
 // end ends the game with the given result.
 func (s *state) end(ctx *core.MinigameContext, result core.MinigameResult) error {
 	// Stop the timer so it doesn't fire later.
-	if ctx.Ship.Recorder != nil {
-		c0, c1 := s.teamPlayerCounts()
-		var winningTeam *core.Team
-		if c0 > c1 {
-			winningTeam = ctx.GetTeam(0)
-		} else {
-			winningTeam = ctx.GetTeam(1)
+	s.timer.Stop()
+
+	// Players score 1 if they are still alive and 0 otherwise.
+	_ = ctx.ForAllPlayers(func(p *core.Player) error {
+		if _, alive := s.alivePlayers[p]; alive {
+			result = result.WithScore(p, 1)
 		}
-		ps := make(map[*core.Player]float64)
-		pw := make(map[*core.Player]uint8)
-		players := 0 // Count the players so the correct game name can be added to db.
-		_ = ctx.ForAllPlayers(func(p *core.Player) error {
-			players++
-			if p.Team == winningTeam {
-				pw[p] = 1
-			} else {
-				pw[p] = 0
-			}
-			_, alive := s.alivePlayers[p] // Score is 1 if alive, 0 if dead.
-			if alive {
-				ps[p] = 1
-			} else {
-				ps[p] = 0
-			}
+
+		return nil
+	})
+
+	featErr := s.reportFlawlessWin(ctx, result.Winner())
+
+	return errors.Join(featErr, ctx.End(result))
+}
+
+// reportFlawlessWin reports a flawless win for every member of the winning team if none of them
+// was hit. It does nothing if there is no winner.
+func (s *state) reportFlawlessWin(ctx *core.MinigameContext, winner *core.Team) error {
+	if winner == nil {
+		return nil
+	}
+
+	var members []*core.Player
+
+	_ = ctx.ForAllPlayers(func(p *core.Player) error {
+		if p.Team == winner {
+			members = append(members, p)
+		}
+
+		return nil
+	})
+
+	for _, p := range members {
+		if ps, alive := s.alivePlayers[p]; !alive || ps.health != initialHealth {
 			return nil
-		})
-		duration := gameDuration.Seconds() - s.timer.TimeLeft().Seconds()
-		gameName := fmt.Sprintf("shooter_%[1]dv%[1]d", players/2)
-		// shooter1v1, shooter2v2, shooter3v3
-		ctx.Ship.Recorder.RecordMP(ps, pw, duration, gameName)
+		}
+	}
 
+	var errs []error
+
+	for _, p := range members {
+		errs = append(errs, ctx.ReportFeat(p, featFlawlessWin))
 	}
-	s.timer.Stop()
 
-	return ctx.End(result)
+	return errors.Join(errs...)
 }
 
-// tryEnd ends the game if one team has been wiped out.
+// tryEnd ends the game if all but one team has been wiped out.
 func (s *state) tryEnd(ctx *core.MinigameContext) error {
-	c0, c1 := s.teamPlayerCounts()
+	counts := s.teamPlayerCounts()
 
-	if c0+c1 == 0 {
+	if len(counts) == 0 {
 		core.Logger.Panic(
-			"shooter minigame has no players on either team",
+			"shooter minigame has no players on any team",
 			zap.Any("state", s),
 		)
 
 		panic("unreachable")
 	}
 
-	if c0 == 0 {
-		// Team 1 has eliminated T0, so T1 wins.
-		return s.end(ctx, core.MultiplayerResult(ctx.GetTeam(1)))
-	}
-
-	if c1 == 0 {
-		// Team 0 has eliminated T1, so T0 wins.
-		return s.end(ctx, core.MultiplayerResult(ctx.GetTeam(0)))
+	if len(counts) > 1 {
+		// There are players remaining on more than one team, so the game is not over yet.
+		return nil
 	}
 
-	// There are players remaining on both teams, so the game is not over yet.
-	return nil
+	// The last team standing wins.
+	return s.end(ctx, s.ranking(ctx))
 }
 
 // onTimeout is called when the main game timer expires.
 func (s *state) onTimeout(ctx *core.MinigameContext) error {
-	c0, c1 := s.teamPlayerCounts()
-
-	// Draw by default.
-	var winningTeam *core.Team = nil
-
-	if c0 > c1 {
-		// Team 0 has more players alive than T1, so T0 wins.
-		winningTeam = ctx.GetTeam(0)
-	} else if c1 > c0 {
-		// Team 1 has more players alive than T0, so T1 wins.
-		winningTeam = ctx.GetTeam(1)
-	}
-
-	// If both teams have equal player counts, the result is a draw (so we don't need to do
-	// anything).
-
-	// End the game.
-	return s.end(ctx, core.MultiplayerResult(winningTeam))
+	// The team with the most players alive wins. If the most players alive is shared, the result
+	// is a draw.
+	return s.end(ctx, s.ranking(ctx))
 }
 
 // handleBulletHit processes a bullet hit message.
@@ -368,6 +398,11 @@ func (s *state) handleBulletHit(
 		// The player is now dead.
 		delete(s.alivePlayers, victim)
 		s.deadPlayerBullets[victim] = vState.bullets
+
+		if s.teamPlayerCounts()[victim.Team] == 0 {
+			// That was the last player alive on their team.
+			s.eliminatedTeams = append(s.eliminatedTeams, victim.Team)
+		}
 	} else {
 		// The player is still alive.
 		s.alivePlayers[victim] = vState
@@ -461,7 +496,7 @@ func (s *state) welcomeAll(ctx *core.MinigameContext) error {
 
 // startTimer begins the game timer.
 func (s *state) startTimer(ctx *core.MinigameContext) {
-	s.timer = core.SingleTimer(ctx.Ship.Scheduler, time.Now().Add(gameDuration), func() error {
+	s.timer = core.SingleTimer(ctx.Scheduler(), time.Now().Add(gameDuration), func() error {
 		if s.timer.WasStopped() {
 			// Game finished between timer expiry and this function running.
 			return nil
@@ -499,3 +534,10 @@ func (s *state) Handle(ctx *core.MinigameContext, player *core.Player, message *
 func (s *state) HandleDisconnection(ctx *core.MinigameContext, player *core.Player) error {
 	return s.end(ctx, core.MultiplayerDisconnection(player))
 }
+
+// init registers the package's minigames with the core.
+func init() {
+	core.RegisterMinigame(Proto1v1)
+	core.RegisterMinigame(Proto2v2)
+	core.RegisterMinigame(Proto3v3)
+}
@@ -23,6 +23,35 @@ SET time_zone = "+00:00";
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `chatMessages`
+--
+
+CREATE TABLE `chatMessages` (
+  `messageID` int NOT NULL,
+  `lobbyPK` int NOT NULL,
+  `name` varchar(10) NOT NULL,
+  `team` tinyint NOT NULL,
+  `scope` enum('lobby','team','ship') NOT NULL,
+  `message` varchar(800) NOT NULL,
+  `timeIntoGame` float NOT NULL COMMENT 'in seconds'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `gameAwards`
+--
+
+CREATE TABLE `gameAwards` (
+  `lobbyPK` int NOT NULL,
+  `award` enum('mvp','most_flags','marathon_runner') NOT NULL,
+  `username` varchar(255) NOT NULL,
+  `value` float NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `gameLobbies`
 --
@@ -39,6 +68,18 @@ CREATE TABLE `gameLobbies` (
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `gameLobbyTeams`
+--
+
+CREATE TABLE `gameLobbyTeams` (
+  `lobbyPK` int NOT NULL,
+  `teamIndex` tinyint NOT NULL,
+  `score` float NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `heatMaps`
 --
@@ -87,19 +128,72 @@ CREATE TABLE `minigameSessions` (
   `gameName` varchar(50) NOT NULL,
   `lobbyPK` int NOT NULL,
   `timeSpent` float NOT NULL COMMENT 'in seconds',
-  `session_timestamp` timestamp NOT NULL COMMENT 'time when minigame ends.'
+  `session_timestamp` timestamp NOT NULL COMMENT 'time when minigame ends.',
+  `stats` json DEFAULT NULL COMMENT 'custom statistics reported by the minigame'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `playerStats`
+--
+
+CREATE TABLE `playerStats` (
+  `lobbyPK` int NOT NULL,
+  `username` varchar(255) NOT NULL,
+  `teamIndex` tinyint NOT NULL,
+  `tokens` float NOT NULL,
+  `distance` float NOT NULL,
+  `minigameTime` float NOT NULL COMMENT 'in seconds',
+  `shipTime` float NOT NULL COMMENT 'in seconds',
+  `flagsCaptured` int NOT NULL,
+  `minigamesPlayed` int NOT NULL,
+  `minigamesWon` int NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `shipEvents`
+--
+
+CREATE TABLE `shipEvents` (
+  `eventID` int NOT NULL,
+  `lobbyPK` int NOT NULL,
+  `event` enum('double_worth','bonus_flag','half_cooldowns','blackout') NOT NULL,
+  `timeIntoGame` float NOT NULL COMMENT 'in seconds',
+  `duration` float NOT NULL COMMENT 'in seconds'
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
 --
 -- Indexes for dumped tables
 --
 
+--
+-- Indexes for table `chatMessages`
+--
+ALTER TABLE `chatMessages`
+  ADD PRIMARY KEY (`messageID`),
+  ADD KEY `lobbyPK` (`lobbyPK`);
+
+--
+-- Indexes for table `gameAwards`
+--
+ALTER TABLE `gameAwards`
+  ADD PRIMARY KEY (`lobbyPK`,`award`);
+
 --
 -- Indexes for table `gameLobbies`
 --
 ALTER TABLE `gameLobbies`
   ADD PRIMARY KEY (`lobbyPK`) USING BTREE;
 
+--
+-- Indexes for table `gameLobbyTeams`
+--
+ALTER TABLE `gameLobbyTeams`
+  ADD PRIMARY KEY (`lobbyPK`,`teamIndex`);
+
 --
 -- Indexes for table `heatMaps`
 --
@@ -126,10 +220,29 @@ ALTER TABLE `minigameSessions`
   ADD KEY `gameName` (`gameName`),
   ADD KEY `lobbyPK` (`lobbyPK`);
 
+--
+-- Indexes for table `playerStats`
+--
+ALTER TABLE `playerStats`
+  ADD PRIMARY KEY (`lobbyPK`,`username`);
+
+--
+-- Indexes for table `shipEvents`
+--
+ALTER TABLE `shipEvents`
+  ADD PRIMARY KEY (`eventID`),
+  ADD KEY `lobbyPK` (`lobbyPK`);
+
 --
 -- AUTO_INCREMENT for dumped tables
 --
 
+--
+-- AUTO_INCREMENT for table `chatMessages`
+--
+ALTER TABLE `chatMessages`
+  MODIFY `messageID` int NOT NULL AUTO_INCREMENT;
+
 --
 -- AUTO_INCREMENT for table `gameLobbies`
 --
@@ -154,10 +267,34 @@ ALTER TABLE `minigamePlayers`
 ALTER TABLE `minigameSessions`
   MODIFY `sessionID` int NOT NULL AUTO_INCREMENT;
 
+--
+-- AUTO_INCREMENT for table `shipEvents`
+--
+ALTER TABLE `shipEvents`
+  MODIFY `eventID` int NOT NULL AUTO_INCREMENT;
+
 --
 -- Constraints for dumped tables
 --
 
+--
+-- Constraints for table `chatMessages`
+--
+ALTER TABLE `chatMessages`
+  ADD CONSTRAINT `chatMessages_ibfk_1` FOREIGN KEY (`lobbyPK`) REFERENCES `gameLobbies` (`lobbyPK`);
+
+--
+-- Constraints for table `gameAwards`
+--
+ALTER TABLE `gameAwards`
+  ADD CONSTRAINT `gameAwards_ibfk_1` FOREIGN KEY (`lobbyPK`) REFERENCES `gameLobbies` (`lobbyPK`);
+
+--
+-- Constraints for table `gameLobbyTeams`
+--
+ALTER TABLE `gameLobbyTeams`
+  ADD CONSTRAINT `gameLobbyTeams_ibfk_1` FOREIGN KEY (`lobbyPK`) REFERENCES `gameLobbies` (`lobbyPK`);
+
 --
 -- Constraints for table `heatMaps`
 --
@@ -176,6 +313,18 @@ ALTER TABLE `minigamePlayers`
 ALTER TABLE `minigameSessions`
   ADD CONSTRAINT `minigameSessions_ibfk_1` FOREIGN KEY (`gameName`) REFERENCES `minigameInfo` (`gameName`),
   ADD CONSTRAINT `minigameSessions_ibfk_2` FOREIGN KEY (`lobbyPK`) REFERENCES `gameLobbies` (`lobbyPK`);
+
+--
+-- Constraints for table `playerStats`
+--
+ALTER TABLE `playerStats`
+  ADD CONSTRAINT `playerStats_ibfk_1` FOREIGN KEY (`lobbyPK`) REFERENCES `gameLobbies` (`lobbyPK`);
+
+--
+-- Constraints for table `shipEvents`
+--
+ALTER TABLE `shipEvents`
+  ADD CONSTRAINT `shipEvents_ibfk_1` FOREIGN KEY (`lobbyPK`) REFERENCES `gameLobbies` (`lobbyPK`);
 COMMIT;
 
 /*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;